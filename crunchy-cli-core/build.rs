@@ -1,4 +1,8 @@
 fn main() -> std::io::Result<()> {
+    // emitting any `rerun-if` instruction disables the default of rerunning the build script if a
+    // file of the package changes, so it must be requested explicitly
+    println!("cargo:rerun-if-changed=.");
+    println!("cargo:rerun-if-env-changed=CRUNCHY_CLI_GIT_HASH");
     println!(
         "cargo:rustc-env=GIT_HASH={}",
         std::env::var("CRUNCHY_CLI_GIT_HASH")
@@ -8,6 +12,13 @@ fn main() -> std::io::Result<()> {
         "cargo:rustc-env=BUILD_DATE={}",
         chrono::Utc::now().format("%F")
     );
+    // base64 encoded ed25519 public key which verifies the checksums of releases for self-updates.
+    // builds without it can't update themselves
+    println!("cargo:rerun-if-env-changed=CRUNCHY_CLI_UPDATE_PUBLIC_KEY");
+    println!(
+        "cargo:rustc-env=UPDATE_PUBLIC_KEY={}",
+        std::env::var("CRUNCHY_CLI_UPDATE_PUBLIC_KEY").unwrap_or_default()
+    );
 
     Ok(())
 }
@@ -0,0 +1,29 @@
+//! Archive a single season of a series, by running crunchy-cli as a library.
+//!
+//! ```shell
+//! $ cargo run --example archive_season --features native-tls -- https://www.crunchyroll.com/series/GY8VEQ95Y/darling-in-the-franxx 1
+//! ```
+//!
+//! The arguments are passed to [`crunchy_cli_core::main`] like they would be passed on the command
+//! line, so every flag of `crunchy-cli archive` can be used here too.
+
+use std::env;
+
+#[tokio::main]
+async fn main() {
+    let args: Vec<String> = env::args().collect();
+    let (Some(series_url), Some(season)) = (args.get(1), args.get(2)) else {
+        eprintln!("Usage: archive_season <series url> <season number>");
+        std::process::exit(1)
+    };
+
+    crunchy_cli_core::main(&[
+        "crunchy-cli".to_string(),
+        "archive".to_string(),
+        "--output".to_string(),
+        "{series_name}/S{season_number}E{episode_number} - {title}.mkv".to_string(),
+        // the url filter limits the download to the season
+        format!("{}[S{}]", series_url, season),
+    ])
+    .await
+}
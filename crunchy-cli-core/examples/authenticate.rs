@@ -0,0 +1,26 @@
+//! Authenticate with Crunchyroll and persist the session.
+//!
+//! ```shell
+//! $ CRUNCHY_EMAIL=<email> CRUNCHY_PASSWORD=<password> cargo run --example authenticate --features native-tls
+//! ```
+//!
+//! The session is stored at the same place as `crunchy-cli login` stores it, so all other examples
+//! (and crunchy-cli itself) can use it afterwards without credentials.
+
+mod common;
+
+use anyhow::Result;
+
+#[tokio::main]
+async fn main() -> Result<()> {
+    let crunchy = common::login().await?;
+    common::persist_session(&crunchy).await?;
+
+    let account = crunchy.account().await?;
+    println!(
+        "Logged in as {} (premium: {})",
+        account.profile_name,
+        crunchy.premium().await
+    );
+    Ok(())
+}
@@ -0,0 +1,48 @@
+use anyhow::{bail, Result};
+use crunchyroll_rs::crunchyroll::SessionToken;
+use crunchyroll_rs::Crunchyroll;
+use std::env;
+use std::fs;
+use std::path::PathBuf;
+
+/// Same session file crunchy-cli uses, so a session persisted by an example can also be used by
+/// `crunchy-cli` (and the other way round).
+pub fn session_file_path() -> Option<PathBuf> {
+    dirs::config_dir().map(|config_dir| config_dir.join("crunchy-cli").join("session"))
+}
+
+/// Login with the persisted session, or with the `CRUNCHY_EMAIL` and `CRUNCHY_PASSWORD`
+/// environment variables if no session is persisted yet.
+pub async fn login() -> Result<Crunchyroll> {
+    if let Some(session) = session_file_path().and_then(|p| fs::read_to_string(p).ok()) {
+        if let Some(refresh_token) = session.strip_prefix("refresh_token:") {
+            return Ok(Crunchyroll::builder()
+                .login_with_refresh_token(refresh_token)
+                .await?);
+        }
+    }
+
+    let (Ok(email), Ok(password)) = (env::var("CRUNCHY_EMAIL"), env::var("CRUNCHY_PASSWORD"))
+    else {
+        bail!("No persisted session found, please set `CRUNCHY_EMAIL` and `CRUNCHY_PASSWORD`")
+    };
+    Ok(Crunchyroll::builder()
+        .login_with_credentials(email, password)
+        .await?)
+}
+
+/// Persist the session of `crunchy`, so the next [`login`] doesn't need credentials.
+pub async fn persist_session(crunchy: &Crunchyroll) -> Result<()> {
+    let SessionToken::RefreshToken(refresh_token) = crunchy.session_token().await else {
+        bail!("Only sessions with a refresh token can be persisted")
+    };
+    let Some(session_file_path) = session_file_path() else {
+        bail!("Cannot find config path")
+    };
+    fs::create_dir_all(session_file_path.parent().unwrap())?;
+    fs::write(
+        session_file_path,
+        format!("refresh_token:{}", refresh_token),
+    )?;
+    Ok(())
+}
@@ -0,0 +1,52 @@
+//! Stream an episode or movie with mpv, without downloading it first.
+//!
+//! ```shell
+//! $ cargo run --example stream_to_mpv --features native-tls -- https://www.crunchyroll.com/watch/GRDQPM1ZY/alone-and-lonesome
+//! ```
+//!
+//! Requires a persisted session (see the `authenticate` example) and mpv in the `PATH`. Only
+//! streams which aren't DRM protected can be played.
+
+mod common;
+
+use anyhow::{bail, Result};
+use crunchyroll_rs::parse::UrlType;
+use crunchyroll_rs::MediaCollection;
+use std::env;
+use std::process::Command;
+
+#[tokio::main]
+async fn main() -> Result<()> {
+    let Some(url) = env::args().nth(1) else {
+        bail!("Usage: stream_to_mpv <episode or movie url>")
+    };
+    let Some(UrlType::EpisodeOrMovie(id)) = crunchyroll_rs::parse_url(url) else {
+        bail!("The url must be an episode or movie url")
+    };
+
+    let crunchy = common::login().await?;
+    let stream = match crunchy.media_collection_from_id(id).await? {
+        MediaCollection::Episode(episode) => episode.stream_maybe_without_drm().await?,
+        MediaCollection::Movie(movie) => movie.stream_maybe_without_drm().await?,
+        _ => bail!("The url must be an episode or movie url"),
+    };
+    if stream.session.uses_stream_limits {
+        stream.invalidate().await?;
+        bail!("The stream is DRM protected and cannot be played with mpv")
+    }
+
+    let status = Command::new("mpv")
+        .arg(format!(
+            "--http-header-fields=Authorization: Bearer {}",
+            crunchy.access_token().await
+        ))
+        .arg(&stream.url)
+        .status();
+    // every active stream counts towards the stream limit of the account, so it must be released
+    // again even if mpv failed
+    stream.invalidate().await?;
+    if !status?.success() {
+        bail!("mpv exited with an error")
+    }
+    Ok(())
+}
@@ -0,0 +1,52 @@
+//! Build a RSS feed of the next unwatched episodes of your watchlist.
+//!
+//! ```shell
+//! $ cargo run --example watchlist_rss --features native-tls > watchlist.xml
+//! ```
+//!
+//! Requires a persisted session, see the `authenticate` example.
+
+mod common;
+
+use anyhow::Result;
+use crunchyroll_rs::list::WatchlistOptions;
+use crunchyroll_rs::MediaCollection;
+
+#[tokio::main]
+async fn main() -> Result<()> {
+    let crunchy = common::login().await?;
+
+    let mut items = vec![];
+    for entry in crunchy.watchlist(WatchlistOptions::default()).await? {
+        if entry.fully_watched {
+            continue;
+        }
+        let MediaCollection::Episode(episode) = entry.panel else {
+            continue;
+        };
+        items.push(format!(
+            "    <item>\n      <title>{}</title>\n      <link>https://www.crunchyroll.com/watch/{}</link>\n      <guid>{}</guid>\n      <pubDate>{}</pubDate>\n      <description>{}</description>\n    </item>",
+            escape(&format!(
+                "{} S{:02}E{:0>2} - {}",
+                episode.series_title, episode.season_number, episode.episode, episode.title
+            )),
+            episode.id,
+            episode.id,
+            episode.episode_air_date.to_rfc2822(),
+            escape(&episode.description)
+        ))
+    }
+
+    println!(
+        "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<rss version=\"2.0\">\n  <channel>\n    <title>Crunchyroll watchlist</title>\n    <link>https://www.crunchyroll.com/watchlist</link>\n    <description>Next unwatched episodes of the watchlist</description>\n{}\n  </channel>\n</rss>",
+        items.join("\n")
+    );
+    Ok(())
+}
+
+fn escape(s: &str) -> String {
+    s.replace('&', "&amp;")
+        .replace('<', "&lt;")
+        .replace('>', "&gt;")
+        .replace('"', "&quot;")
+}
@@ -1,24 +1,37 @@
 use crate::archive::filter::ArchiveFilter;
+use crate::utils::availability::sort_by_leaving_at;
+use crate::utils::completion::{ffmpeg_preset_candidates, locale_candidates, series_candidates};
 use crate::utils::context::Context;
 use crate::utils::download::{
-    DownloadBuilder, DownloadFormat, DownloadFormatMetadata, MergeBehavior,
+    cache_subtitle_name, concat_videos, DownloadBuilder, DownloadFormat, DownloadFormatMetadata,
+    MergeBehavior, SegmentTimeouts,
 };
-use crate::utils::ffmpeg::FFmpegPreset;
+use crate::utils::ffmpeg::{FFmpegPreset, MuxOption};
 use crate::utils::filter::Filter;
 use crate::utils::format::{Format, SingleFormat};
+use crate::utils::history::{add_to_history, monthly_cap_reached, History};
+use crate::utils::hook::PostProcessHook;
+use crate::utils::i18n::tr;
 use crate::utils::locale::{all_locale_in_locales, resolve_locales, LanguageTagging};
 use crate::utils::log::progress;
-use crate::utils::os::{free_file, has_ffmpeg, is_special_file};
+use crate::utils::notify::notify;
+use crate::utils::os::{free_file, has_ffmpeg, is_special_file, OutputLock};
 use crate::utils::parse::parse_url;
-use crate::utils::video::stream_data_from_stream;
+use crate::utils::signal::{detached_output, stop_requested, Job};
+use crate::utils::skipped::{is_unavailable, SkippedManifest};
+use crate::utils::video::{is_drm, stream_data_from_stream};
+use crate::utils::write::WriteOptions;
 use crate::Execute;
 use anyhow::bail;
 use anyhow::Result;
 use chrono::Duration;
+use clap_complete::engine::ArgValueCandidates;
+use crunchyroll_rs::crunchyroll::SessionToken;
 use crunchyroll_rs::media::{Resolution, Subtitle};
 use crunchyroll_rs::Locale;
-use log::{debug, warn};
+use log::{debug, info, warn};
 use regex::Regex;
+use std::collections::HashSet;
 use std::fmt::{Display, Formatter};
 use std::iter::zip;
 use std::ops::Sub;
@@ -34,6 +47,7 @@ pub struct Archive {
     #[arg(long_help = format!("Audio languages. Can be used multiple times. \
     Available languages are:\n  {}\nIETF tagged language codes for the shown available locales can be used too", Locale::all().into_iter().map(|l| format!("{:<6} → {}", l.to_string(), l.to_human_readable())).collect::<Vec<String>>().join("\n  ")))]
     #[arg(short, long, default_values_t = vec![Locale::ja_JP, crate::utils::locale::system_locale()])]
+    #[arg(add = ArgValueCandidates::new(locale_candidates))]
     pub(crate) audio: Vec<Locale>,
     #[arg(skip)]
     output_audio_locales: Vec<String>,
@@ -42,6 +56,7 @@ pub struct Archive {
     #[arg(long_help = format!("Subtitle languages. Can be used multiple times. \
     Available languages are: {}\nIETF tagged language codes for the shown available locales can be used too", Locale::all().into_iter().map(|l| l.to_string()).collect::<Vec<String>>().join(", ")))]
     #[arg(short, long, default_values_t = Locale::all())]
+    #[arg(add = ArgValueCandidates::new(locale_candidates))]
     pub(crate) subtitle: Vec<Locale>,
     #[arg(skip)]
     output_subtitle_locales: Vec<String>,
@@ -73,6 +88,14 @@ pub struct Archive {
     If not set, the '-o'/'--output' flag will be used as name template")]
     #[arg(long)]
     pub(crate) output_specials: Option<String>,
+    #[arg(help = "Additionally merge all episodes of a season into a single file")]
+    #[arg(
+        long_help = "Additionally merge all episodes of a season into a single file with a chapter for every episode, e.g. to watch a whole season on devices without proper playlist support. \
+    The value is the name of the merged file and uses the same template options as '-o'/'--output' (the episode specific options are filled with the first episode of the season). \
+    Only episodes which are downloaded in the same run are included"
+    )]
+    #[arg(long)]
+    pub(crate) season_output: Option<String>,
 
     #[arg(help = "Sanitize the output file for use with all operating systems. \
     This option only affects template options and not static characters.")]
@@ -88,6 +111,15 @@ pub struct Archive {
     #[arg(short, long, default_value = "best")]
     #[arg(value_parser = crate::utils::clap::clap_parse_resolution)]
     pub(crate) resolution: Resolution,
+    #[arg(
+        help = "Use the closest available resolution if the one given via `-r` / `--resolution` isn't available"
+    )]
+    #[arg(
+        long_help = "Use the closest available resolution if the one given via `-r` / `--resolution` isn't available. \
+    The next lower resolution is preferred, only if every available resolution is higher than the requested one, the lowest available is used"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) closest_resolution: bool,
 
     #[arg(
         help = "Sets the behavior of the stream merging. Valid behaviors are 'auto', 'sync', 'audio' and 'video'"
@@ -135,6 +167,7 @@ pub struct Archive {
     Available presets: \n  {}", FFmpegPreset::available_matches_human_readable().join("\n  ")))]
     #[arg(long)]
     #[arg(value_parser = FFmpegPreset::parse)]
+    #[arg(add = ArgValueCandidates::new(ffmpeg_preset_candidates))]
     pub(crate) ffmpeg_preset: Option<FFmpegPreset>,
     #[arg(
         help = "The number of threads used by ffmpeg to generate the output file. Does not work with every codec/preset"
@@ -146,12 +179,48 @@ pub struct Archive {
     )]
     #[arg(long)]
     pub(crate) ffmpeg_threads: Option<usize>,
+    #[arg(help = "Key to decrypt DRM protected videos. Must be 32 hex characters")]
+    #[arg(
+        long_help = "Key to decrypt DRM protected videos. Must be 32 hex characters. \
+    The key is used for all tracks, a key id (<kid>:<key>) isn't supported. \
+    crunchy-cli doesn't obtain keys itself, you have to supply them. \
+    Without a key, DRM protected videos are skipped with a warning instead of producing unplayable output"
+    )]
+    #[arg(long, value_parser = crate::utils::clap::clap_parse_decryption_key)]
+    pub(crate) decryption_key: Option<String>,
+
+    #[arg(
+        help = "Options how the output file should be muxed. The only valid option for '.mkv' files is 'cues-front'"
+    )]
+    #[arg(long_help = "Options how the output file should be muxed. \
+    The only valid option for '.mkv' files is 'cues-front' (moves the seeking index to the front of the file, so it can be streamed from network shares or web servers without reading the whole file first; requires ffmpeg 6.0 or newer)")]
+    #[arg(long)]
+    #[arg(value_parser = MuxOption::parse)]
+    pub(crate) mux_option: Vec<MuxOption>,
 
     #[arg(
         help = "Set which subtitle language should be set as default / auto shown when starting a video"
     )]
     #[arg(long)]
+    #[arg(add = ArgValueCandidates::new(locale_candidates))]
     pub(crate) default_subtitle: Option<Locale>,
+    #[arg(help = "Set which audio language should be set as default when starting a video")]
+    #[arg(
+        long_help = "Set which audio language should be set as default when starting a video. \
+    If not set, the first audio track is the default one (see `--audio-order`)"
+    )]
+    #[arg(long)]
+    #[arg(add = ArgValueCandidates::new(locale_candidates))]
+    pub(crate) default_audio: Option<Locale>,
+    #[arg(
+        help = "Sets the order of the audio tracks. Valid orders are 'audio' and 'original-first'"
+    )]
+    #[arg(long_help = "Sets the order of the audio tracks. \
+    Valid orders are 'audio' (the order in which the languages are given via `-a` / `--audio`) and 'original-first' (the original audio of the video comes first, all dubs follow in the order in which they are given via `-a` / `--audio`). \
+    Many video players are choosing the first audio track if no track is flagged as default")]
+    #[arg(long, default_value_t = AudioOrder::Audio)]
+    #[arg(value_parser = AudioOrder::parse)]
+    pub(crate) audio_order: AudioOrder,
     #[arg(help = "Include fonts in the downloaded file")]
     #[arg(long)]
     pub(crate) include_fonts: bool,
@@ -171,6 +240,15 @@ pub struct Archive {
     #[arg(long, default_value_t = false)]
     pub(crate) no_closed_caption: bool,
 
+    #[arg(help = "Add a custom metadata tag to the output file. Can be used multiple times")]
+    #[arg(
+        long_help = "Add a custom metadata tag to the output file, e.g. `--metadata source=crunchyroll`. Can be used multiple times. \
+    Must be in format of <key>=<value>. Note that not every container supports arbitrary tags"
+    )]
+    #[arg(long = "metadata", value_name = "KEY=VALUE")]
+    #[arg(value_parser = crate::utils::clap::clap_parse_metadata_tag)]
+    pub(crate) metadata_tags: Vec<(String, String)>,
+
     #[arg(help = "Skip files which are already existing by their name")]
     #[arg(long, default_value_t = false)]
     pub(crate) skip_existing: bool,
@@ -184,25 +262,99 @@ pub struct Archive {
     #[arg(long, default_values_t = SkipExistingMethod::default())]
     #[arg(value_parser = SkipExistingMethod::parse)]
     pub(crate) skip_existing_method: Vec<SkipExistingMethod>,
+    #[arg(help = "Skip videos which are already in the download history")]
+    #[arg(
+        long_help = "Skip videos which are already in the download history (with at least the requested audio), regardless of where they were saved. \
+    Use `--history` to share the history between multiple instances of crunchy-cli, so that every video is only downloaded once"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) skip_downloaded: bool,
+    #[arg(help = "Check if all videos can be downloaded before starting the first download")]
+    #[arg(
+        long_help = "Check if all videos can be downloaded before starting the first download. \
+    Videos which can't be downloaded (e.g. because of missing premium, region restrictions or the stream limit of your account) are reported and skipped"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) preflight: bool,
+    #[arg(help = "Record videos which can't be downloaded in a file")]
+    #[arg(
+        long_help = "Record videos which can't be downloaded (e.g. because of missing premium or region restrictions) together with the reason why in a json file. \
+    The archive continues with the remaining videos. Use `--retry-skipped` to retry the recorded videos later"
+    )]
+    #[arg(long)]
+    pub(crate) skipped: Option<PathBuf>,
+    #[arg(help = "Retry all videos recorded in the `--skipped` file")]
+    #[arg(long_help = "Retry all videos recorded in the `--skipped` file. \
+    Videos which are downloaded successfully are removed from the file, videos which still can't be downloaded stay in it")]
+    #[arg(long, default_value_t = false)]
+    #[arg(requires = "skipped")]
+    pub(crate) retry_skipped: bool,
     #[arg(help = "Skip special episodes")]
     #[arg(long, default_value_t = false)]
     pub(crate) skip_specials: bool,
+    #[arg(
+        help = "Download the videos which leave the Crunchyroll catalog first before all others"
+    )]
+    #[arg(
+        long_help = "Download the videos which leave the Crunchyroll catalog first before all others. \
+    The urls are sorted by the earliest announced removal date of their episodes, urls without announced removal are downloaded last. \
+    Requires to fetch all episodes of every url before the first download starts"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) leaving_first: bool,
+    #[arg(help = "Only download episodes which are not (fully) watched yet")]
+    #[arg(
+        long_help = "Only download episodes which are not (fully) watched yet. \
+    Episodes which are partially watched are downloaded too. Requires to be logged in with an account"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) unwatched: bool,
 
     #[arg(help = "Skip any interactive input")]
     #[arg(short, long, default_value_t = false)]
     pub(crate) yes: bool,
 
+    #[clap(flatten)]
+    pub(crate) post_process: PostProcessHook,
+
     #[arg(help = "The number of threads used to download")]
     #[arg(short, long, default_value_t = num_cpus::get())]
     pub(crate) threads: usize,
+    #[clap(flatten)]
+    pub(crate) segment_timeouts: SegmentTimeouts,
+    #[clap(flatten)]
+    pub(crate) write_options: WriteOptions,
+    #[arg(help = "Reduce the memory usage, e.g. on a Raspberry Pi or router-class devices")]
+    #[arg(
+        long_help = "Reduce the memory usage, e.g. on a Raspberry Pi or router-class devices. \
+    Segments are downloaded one after another (so no segments must be buffered until the previous ones are downloaded), the write buffer is reduced and ffmpeg uses only one thread. \
+    This overwrites `--threads` and `--ffmpeg-threads`"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) low_memory: bool,
 
     #[arg(help = "Crunchyroll series url(s)")]
-    #[arg(required = true)]
+    #[arg(required_unless_present = "retry_skipped")]
+    #[arg(add = ArgValueCandidates::new(series_candidates))]
     pub(crate) urls: Vec<String>,
 }
 
 impl Execute for Archive {
     fn pre_check(&mut self) -> Result<()> {
+        self.post_process.check()?;
+        self.segment_timeouts.check()?;
+        self.write_options.check()?;
+        if self.low_memory {
+            self.threads = 1;
+            self.ffmpeg_threads = Some(1);
+            self.write_options.low_memory();
+        } else if cfg!(target_pointer_width = "32") {
+            info!("Running on a 32-bit system. Use `--low-memory` if the download fails because of missing memory")
+        }
+        if self.low_memory && matches!(self.merge, MergeBehavior::Sync) {
+            warn!("`--merge sync` needs a lot of memory to compare the audios, consider using another merge behavior with `--low-memory`")
+        }
+
         if !has_ffmpeg() {
             bail!("FFmpeg is needed to run this command")
         } else if PathBuf::from(&self.output)
@@ -227,6 +379,33 @@ impl Execute for Archive {
             }
         }
 
+        if let Some(season_output) = &self.season_output {
+            if self.output == "-" || is_special_file(&self.output) {
+                bail!("`--season-output` can only be used if the episodes are written to regular files")
+            } else if PathBuf::from(season_output)
+                .extension()
+                .unwrap_or_default()
+                .to_string_lossy()
+                != "mkv"
+            {
+                bail!("File extension for `--season-output` is not '.mkv'. Currently only matroska / '.mkv' files are supported")
+            }
+            if self.skip_existing || self.skip_downloaded {
+                warn!("Episodes which are skipped by `--skip-existing` or `--skip-downloaded` are not included in the `--season-output` file")
+            }
+        }
+
+        if let Some(mux_option) = self
+            .mux_option
+            .iter()
+            .find(|o| !o.supports_extension("mkv"))
+        {
+            bail!(
+                "Mux option '{}' cannot be used with '.mkv' files",
+                mux_option
+            )
+        }
+
         if self.include_chapters
             && !matches!(self.merge, MergeBehavior::Sync)
             && !matches!(self.merge, MergeBehavior::Audio)
@@ -241,6 +420,12 @@ impl Execute for Archive {
         self.audio = all_locale_in_locales(self.audio.clone());
         self.subtitle = all_locale_in_locales(self.subtitle.clone());
 
+        if let Some(default_audio) = &self.default_audio {
+            if !self.audio.contains(default_audio) {
+                bail!("`--default-audio` must be one of the languages given via `-a` / `--audio`")
+            }
+        }
+
         if let Some(language_tagging) = &self.language_tagging {
             self.audio = resolve_locales(&self.audio);
             self.subtitle = resolve_locales(&self.subtitle);
@@ -268,23 +453,60 @@ impl Execute for Archive {
         if !ctx.crunchy.premium().await {
             warn!("You may not be able to download all requested videos when logging in anonymously or using a non-premium account")
         }
+        if self.unwatched && matches!(ctx.crunchy.session_token().await, SessionToken::Anonymous) {
+            bail!("`--unwatched` cannot be used when logging in anonymously")
+        }
+
+        let mut skipped_manifest = self
+            .skipped
+            .clone()
+            .map(SkippedManifest::load)
+            .transpose()?;
+        let mut urls = self.urls.clone();
+        if self.retry_skipped {
+            let skipped_urls = skipped_manifest.as_ref().unwrap().urls();
+            if skipped_urls.is_empty() && urls.is_empty() {
+                info!("No skipped videos to retry");
+                return Ok(());
+            }
+            urls.extend(skipped_urls)
+        }
 
         let mut parsed_urls = vec![];
 
-        for (i, url) in self.urls.clone().into_iter().enumerate() {
-            let progress_handler = progress!("Parsing url {}", i + 1);
+        for (i, url) in urls.into_iter().enumerate() {
+            let progress_handler = progress!("{}", tr!("Parsing url {0}", i + 1));
             match parse_url(&ctx.crunchy, url.clone(), true).await {
                 Ok((media_collection, url_filter)) => {
-                    progress_handler.stop(format!("Parsed url {}", i + 1));
+                    progress_handler.stop(tr!("Parsed url {0}", i + 1));
                     parsed_urls.push((media_collection, url_filter))
                 }
                 Err(e) => bail!("url {} could not be parsed: {}", url, e),
             };
         }
 
-        for (i, (media_collection, url_filter)) in parsed_urls.into_iter().enumerate() {
-            let progress_handler = progress!("Fetching series details");
-            let single_format_collection = ArchiveFilter::new(
+        if self.leaving_first {
+            let progress_handler =
+                progress!("{}", tr!("Checking when the videos leave the catalog"));
+            parsed_urls = sort_by_leaving_at(parsed_urls).await?;
+            progress_handler.stop(tr!("Sorted urls by their removal date"))
+        }
+
+        let mut downloaded = 0;
+        let mut skipped = 0;
+        // identifiers of all videos which were already processed. the same video might be included
+        // in multiple urls (e.g. a series url and an episode url of it)
+        let mut processed = HashSet::new();
+        // read once instead of for every video, entries of other instances are added via refresh
+        let mut history = if self.skip_downloaded {
+            History::load()?
+        } else {
+            History::default()
+        };
+
+        'urls: for (i, (media_collection, url_filter)) in parsed_urls.into_iter().enumerate() {
+            let progress_handler = progress!("{}", tr!("Fetching series details"));
+            let mut single_format_collection = ArchiveFilter::new(
                 url_filter,
                 self.clone(),
                 !self.yes,
@@ -295,19 +517,44 @@ impl Execute for Archive {
             .await?;
 
             if single_format_collection.is_empty() {
-                progress_handler.stop(format!("Skipping url {} (no matching videos found)", i + 1));
+                progress_handler.stop(tr!("Skipping url {0} (no matching videos found)", i + 1));
                 continue;
             }
-            progress_handler.stop(format!("Loaded series information for url {}", i + 1));
+            progress_handler.stop(tr!("Loaded series information for url {0}", i + 1));
+
+            if self.preflight {
+                let progress_handler =
+                    progress!("{}", tr!("Checking if all videos can be downloaded"));
+                let failed = single_format_collection.preflight().await;
+                if failed.is_empty() {
+                    progress_handler.stop(tr!("All videos can be downloaded"))
+                } else {
+                    progress_handler.stop(tr!("{0} video(s) can't be downloaded", failed.len()));
+                    for (video, reason) in failed {
+                        warn!("Skipping {}: {}", video.display_name(), reason);
+                        if let Some(skipped_manifest) = &mut skipped_manifest {
+                            skipped_manifest.add(&video, reason);
+                            skipped_manifest.save()?
+                        }
+                        skipped += 1
+                    }
+                }
+                if single_format_collection.is_empty() {
+                    continue;
+                }
+            }
 
             single_format_collection.full_visual_output();
 
             let download_builder =
                 DownloadBuilder::new(ctx.client.clone(), ctx.rate_limiter.clone())
                     .default_subtitle(self.default_subtitle.clone())
+                    .default_audio(self.default_audio.clone())
                     .download_fonts(self.include_fonts)
                     .ffmpeg_preset(self.ffmpeg_preset.clone().unwrap_or_default())
                     .ffmpeg_threads(self.ffmpeg_threads)
+                    .decryption_key(self.decryption_key.clone())
+                    .mux_options(self.mux_option.clone())
                     .output_format(Some("matroska".to_string()))
                     .audio_sort(Some(self.audio.clone()))
                     .subtitle_sort(Some(self.subtitle.clone()))
@@ -321,17 +568,84 @@ impl Execute for Archive {
                         _ => None,
                     })
                     .threads(self.threads)
+                    .segment_timeouts(self.segment_timeouts.clone())
+                    .write_options(self.write_options.clone())
                     .audio_locale_output_map(
                         zip(self.audio.clone(), self.output_audio_locales.clone()).collect(),
                     )
                     .subtitle_locale_output_map(
                         zip(self.subtitle.clone(), self.output_subtitle_locales.clone()).collect(),
-                    );
+                    )
+                    .metadata_tags(self.metadata_tags.clone());
+
+            // downloaded episodes of the current season, used for `--season-output`
+            let mut season_files: Vec<(Format, PathBuf)> = vec![];
 
             for single_formats in single_format_collection.into_iter() {
-                let (download_formats, mut format) = get_format(&self, &single_formats).await?;
+                if stop_requested() {
+                    // the current season is incomplete, so it isn't merged
+                    info!("Stopped before downloading all videos. Run the same command again with `--skip-existing` to continue");
+                    break 'urls;
+                }
+
+                if season_files
+                    .last()
+                    .map_or(false, |(f, _)| f.season_id != single_formats[0].season_id)
+                {
+                    merge_season(&self, &season_files)?;
+                    season_files.clear()
+                }
+
+                if !processed.insert(single_formats[0].identifier.clone()) {
+                    debug!(
+                        "Skipping {} ({}) as it was already processed",
+                        single_formats[0].title, single_formats[0].identifier
+                    );
+                    continue;
+                }
+                // only the audios which are actually available can be in the history, requested
+                // but missing ones would prevent that the video is ever skipped
+                let available_audio: Vec<Locale> =
+                    single_formats.iter().map(|s| s.audio.clone()).collect();
+                if self.skip_downloaded
+                    && history.contains(&single_formats[0].identifier, &available_audio)
+                {
+                    debug!(
+                        "Skipping {} ({}) as it is already in the download history",
+                        single_formats[0].title, single_formats[0].identifier
+                    );
+                    continue;
+                }
+
+                // a single video which isn't available (e.g. because of region restrictions) must not
+                // abort the whole archive
+                let format = match get_format(&self, &single_formats).await {
+                    Ok(format) => format,
+                    Err(e) if is_unavailable(&e) => {
+                        warn!("Skipping {}: {}", single_formats[0].display_name(), e);
+                        if let Some(skipped_manifest) = &mut skipped_manifest {
+                            skipped_manifest.add(&single_formats[0], e.to_string());
+                            skipped_manifest.save()?
+                        }
+                        skipped += 1;
+                        continue;
+                    }
+                    Err(e) => return Err(e),
+                };
+                let Some((download_formats, mut format)) = format else {
+                    skipped += 1;
+                    continue;
+                };
+                let estimated_size = download_formats
+                    .iter()
+                    .map(|d| d.estimated_size(format.duration))
+                    .sum();
+                let audio_sort = self.audio_order.sort_locales(&self.audio, &single_formats);
 
-                let mut downloader = download_builder.clone().build();
+                let mut downloader = download_builder
+                    .clone()
+                    .audio_sort(Some(audio_sort.clone()))
+                    .build();
                 for download_format in download_formats {
                     downloader.add_format(download_format)
                 }
@@ -412,10 +726,10 @@ impl Execute for Archive {
                 }
 
                 format.locales.sort_by(|(a, _), (b, _)| {
-                    self.audio
+                    audio_sort
                         .iter()
                         .position(|l| l == a)
-                        .cmp(&self.audio.iter().position(|l| l == b))
+                        .cmp(&audio_sort.iter().position(|l| l == b))
                 });
                 for (_, subtitles) in format.locales.iter_mut() {
                     subtitles.sort_by(|a, b| {
@@ -426,11 +740,76 @@ impl Execute for Archive {
                     })
                 }
 
+                // the output of stdout or special files can't be locked
+                let _lock = if is_special_file(&path) || path.to_string_lossy() == "-" {
+                    None
+                } else {
+                    match OutputLock::acquire(&path)? {
+                        Some(lock) => Some(lock),
+                        None => {
+                            warn!(
+                                "Skipping '{}' as it is currently written by another process",
+                                path.to_string_lossy()
+                            );
+                            continue;
+                        }
+                    }
+                };
+
+                // another instance might have finished the video while this instance was preparing it
+                if self.skip_downloaded {
+                    history.refresh()?
+                }
+                if self.skip_downloaded
+                    && history.contains(&single_formats[0].identifier, &available_audio)
+                {
+                    debug!(
+                        "Skipping {} ({}) as it was downloaded by another instance",
+                        single_formats[0].title, single_formats[0].identifier
+                    );
+                    continue;
+                }
+
+                if monthly_cap_reached(estimated_size)? {
+                    info!(
+                        "Reached the monthly cap (`--monthly-cap`), skipping all remaining videos"
+                    );
+                    break 'urls;
+                }
+
                 format.visual_output(&path);
 
-                downloader.download(&path).await?
+                let job = Job::start();
+                downloader.download(&path).await?;
+                add_to_history(&format, &path);
+                if let Some(skipped_manifest) = &mut skipped_manifest {
+                    skipped_manifest.remove(&format.identifier);
+                    skipped_manifest.save()?
+                }
+                self.post_process.run(&path).await?;
+                drop(job);
+                downloaded += 1;
+
+                if self.season_output.is_some() {
+                    season_files.push((format, path))
+                }
+            }
+
+            merge_season(&self, &season_files)?;
+        }
+
+        if skipped > 0 {
+            if let Some(path) = &self.skipped {
+                warn!(
+                    "{} video(s) were skipped and recorded in '{}'. Use `--retry-skipped` to retry them",
+                    skipped,
+                    path.to_string_lossy()
+                )
+            } else {
+                warn!("{} video(s) were skipped", skipped)
             }
         }
+        notify(tr!("Downloaded {0} video(s)", downloaded));
 
         Ok(())
     }
@@ -466,17 +845,68 @@ impl SkipExistingMethod {
     }
 }
 
+#[derive(Clone, Debug, Eq, PartialEq)]
+pub(crate) enum AudioOrder {
+    Audio,
+    OriginalFirst,
+}
+
+impl Display for AudioOrder {
+    fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
+        let value = match self {
+            AudioOrder::Audio => "audio",
+            AudioOrder::OriginalFirst => "original-first",
+        };
+        write!(f, "{}", value)
+    }
+}
+
+impl AudioOrder {
+    fn parse(s: &str) -> Result<Self, String> {
+        match s.to_lowercase().as_str() {
+            "audio" => Ok(Self::Audio),
+            "original-first" => Ok(Self::OriginalFirst),
+            _ => Err(format!("invalid audio order '{}'", s)),
+        }
+    }
+
+    /// Get the order in which the audio locales of the given formats should be stored.
+    fn sort_locales(&self, audio: &[Locale], single_formats: &[SingleFormat]) -> Vec<Locale> {
+        let mut locales = audio.to_vec();
+        if let AudioOrder::OriginalFirst = self {
+            if let Some(original) = single_formats.iter().find(|f| f.original) {
+                locales.retain(|l| l != &original.audio);
+                locales.insert(0, original.audio.clone())
+            }
+        }
+        locales
+    }
+}
+
+/// Returns [`None`] if the video is DRM protected and no key to decrypt it is given.
 async fn get_format(
     archive: &Archive,
     single_formats: &Vec<SingleFormat>,
-) -> Result<(Vec<DownloadFormat>, Format)> {
+) -> Result<Option<(Vec<DownloadFormat>, Format)>> {
     let mut format_pairs = vec![];
     let mut single_format_to_format_pairs = vec![];
 
     for single_format in single_formats {
         let stream = single_format.stream().await?;
-        let Some((video, audio, _)) =
-            stream_data_from_stream(&stream, &archive.resolution, None).await?
+        if is_drm(&stream) && archive.decryption_key.is_none() {
+            warn!(
+                "Skipping {}: it is DRM protected and can only be downloaded with `--decryption-key`",
+                single_format.display_name()
+            );
+            return Ok(None);
+        }
+        let Some((video, audio, _)) = stream_data_from_stream(
+            &stream,
+            &archive.resolution,
+            archive.closest_resolution,
+            None,
+        )
+        .await?
         else {
             if single_format.is_episode() {
                 bail!(
@@ -497,13 +927,13 @@ async fn get_format(
             }
         };
 
-        let subtitles: Vec<(Subtitle, bool)> = archive
+        let subtitles: Vec<(Subtitle, bool, String)> = archive
             .subtitle
             .iter()
             .flat_map(|s| {
                 let mut subtitles = vec![];
                 if let Some(caption) = stream.captions.get(s) {
-                    subtitles.push((caption.clone(), true))
+                    subtitles.push((caption.clone(), true, single_format.episode_id.clone()))
                 }
                 if let Some(subtitle) = stream.subtitles.get(s) {
                     // the subtitle is probably cc if the audio is not japanese or only one subtitle
@@ -512,13 +942,16 @@ async fn get_format(
                     // only include the subtitles if no cc subtitle is already present or if it's
                     // not cc
                     if subtitles.is_empty() || !cc {
-                        subtitles.push((subtitle.clone(), cc))
+                        subtitles.push((subtitle.clone(), cc, single_format.episode_id.clone()))
                     }
                 }
                 subtitles
             })
             .collect();
 
+        if !subtitles.is_empty() {
+            cache_subtitle_name(single_format)
+        }
         format_pairs.push((single_format, video.clone(), audio, subtitles.clone()));
         single_format_to_format_pairs.push((single_format.clone(), video, subtitles));
 
@@ -613,10 +1046,72 @@ async fn get_format(
         }
     }
 
-    Ok((
+    Ok(Some((
         download_formats,
         Format::from_single_formats(single_format_to_format_pairs),
-    ))
+    )))
+}
+
+/// Merge all downloaded episodes of a season into a single file with a chapter for every episode.
+fn merge_season(archive: &Archive, season_files: &[(Format, PathBuf)]) -> Result<()> {
+    let Some(season_output) = &archive.season_output else {
+        return Ok(());
+    };
+    let Some((first, _)) = season_files.first() else {
+        return Ok(());
+    };
+    if season_files.len() < 2 {
+        debug!(
+            "Not merging season {} of {} as it only contains one episode",
+            first.season_number, first.series_name
+        );
+        return Ok(());
+    }
+    // all videos must have the same streams to be concatenated
+    if season_files.iter().any(|(f, _)| f.locales != first.locales) {
+        warn!(
+            "Cannot merge season {} of {} as not all episodes have the same audio and subtitle languages",
+            first.season_number, first.series_name
+        );
+        return Ok(());
+    }
+
+    let path = first.format_path(
+        season_output.into(),
+        archive.universal_output,
+        archive.language_tagging.as_ref(),
+    );
+    let progress_handler = progress!(
+        "{}",
+        tr!(
+            "Merging season {0} of {1}",
+            first.season_number,
+            first.series_name
+        )
+    );
+    let videos: Vec<(PathBuf, String)> = season_files
+        .iter()
+        .map(|(format, path)| {
+            (
+                path.clone(),
+                format!("Episode {} - {}", format.episode_number, format.title),
+            )
+        })
+        .collect();
+    concat_videos(
+        &videos,
+        &format!("{} - {}", first.series_name, first.season_title),
+        &archive.metadata_tags,
+        &path,
+    )?;
+    progress_handler.stop(tr!(
+        "Merged season {0} of {1} into '{2}'",
+        first.season_number,
+        first.series_name,
+        path.to_string_lossy()
+    ));
+
+    Ok(())
 }
 
 fn get_video_streams(path: &Path) -> Result<Option<(Vec<Locale>, Vec<Locale>)>> {
@@ -624,12 +1119,13 @@ fn get_video_streams(path: &Path) -> Result<Option<(Vec<Locale>, Vec<Locale>)>>
         Regex::new(r"(?m)Stream\s#\d+:\d+\((?P<language>.+)\):\s(?P<type>(Audio|Subtitle))")
             .unwrap();
 
-    let ffmpeg = Command::new("ffmpeg")
-        .stdout(Stdio::null())
-        .stderr(Stdio::piped())
-        .arg("-hide_banner")
-        .args(["-i", &path.to_string_lossy()])
-        .output()?;
+    let ffmpeg = detached_output(
+        Command::new("ffmpeg")
+            .stdout(Stdio::null())
+            .stderr(Stdio::piped())
+            .arg("-hide_banner")
+            .args(["-i", &path.to_string_lossy()]),
+    )?;
     let ffmpeg_output = String::from_utf8(ffmpeg.stderr)?;
 
     let mut audio = vec![];
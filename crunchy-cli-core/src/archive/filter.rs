@@ -1,5 +1,5 @@
 use crate::archive::command::Archive;
-use crate::utils::filter::{real_dedup_vec, Filter};
+use crate::utils::filter::{is_watched, real_dedup_vec, Filter};
 use crate::utils::format::{Format, SingleFormat, SingleFormatCollection};
 use crate::utils::interactive_select::{check_for_duplicated_seasons, get_duplicated_seasons};
 use crate::utils::parse::{fract, UrlFilter};
@@ -261,6 +261,11 @@ impl Filter for ArchiveFilter {
             return Ok(None);
         }
 
+        // skip the episode if it was already watched
+        if self.archive.unwatched && is_watched(&episode).await? {
+            return Ok(None);
+        }
+
         let mut episodes = vec![];
         if !matches!(self.visited, Visited::Series) && !matches!(self.visited, Visited::Season) {
             if self.archive.audio.contains(&episode.audio_locale) {
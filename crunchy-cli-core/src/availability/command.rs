@@ -0,0 +1,325 @@
+use crate::utils::context::Context;
+use crate::utils::notify::notify;
+use crate::utils::os::{quarantine, write_atomic};
+use crate::utils::parse::parse_url;
+use crate::Execute;
+use anyhow::{bail, Result};
+use chrono::{DateTime, Utc};
+use crunchyroll_rs::{Locale, MediaCollection, Series};
+use log::{debug, warn};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::PathBuf;
+
+#[derive(Debug, clap::Parser)]
+#[clap(
+    about = "Watch series for availability changes, like new dubs or episodes which are leaving soon"
+)]
+pub struct Availability {
+    #[arg(help = "File to store the last known availability in")]
+    #[arg(long_help = "File to store the last known availability in. \
+    Changes are detected by comparing the current availability with the one of the last run, so without this file (and without `--interval`) every run starts from scratch")]
+    #[arg(long)]
+    state: Option<PathBuf>,
+    #[arg(
+        help = "Check the series periodically. Must be in format of <hours>h<minutes>m<seconds>s"
+    )]
+    #[arg(long, value_parser = crate::utils::clap::clap_parse_duration)]
+    interval: Option<chrono::Duration>,
+    #[arg(
+        help = "Report episodes which leave the catalog within this time. Must be in format of <hours>h<minutes>m<seconds>s"
+    )]
+    #[arg(long, default_value = "720h", value_parser = crate::utils::clap::clap_parse_duration)]
+    leaving_soon: chrono::Duration,
+
+    #[arg(help = "Print the changes as json lines")]
+    #[arg(long_help = "Print the changes as json lines. \
+    Every line contains the event ('dub_added', 'dub_removed', 'episode_added', 'episode_removed' or 'leaving_soon'), the series and the affected audio language or episode")]
+    #[arg(long, default_value_t = false)]
+    json: bool,
+
+    #[arg(help = "Urls of the series to watch")]
+    #[arg(required = true)]
+    urls: Vec<String>,
+}
+
+#[derive(Debug, Deserialize, Serialize)]
+struct SeriesSnapshot {
+    title: String,
+    episodes: BTreeMap<String, EpisodeSnapshot>,
+}
+
+impl SeriesSnapshot {
+    async fn new(series: &Series, leaving_soon: DateTime<Utc>) -> Result<Self> {
+        let mut episodes = BTreeMap::new();
+        // dubs are separate seasons on Crunchyroll, so every audio language has its own episodes
+        for season in series.seasons().await? {
+            for episode in season.episodes().await? {
+                episodes.insert(
+                    episode.id.clone(),
+                    EpisodeSnapshot {
+                        title: episode.title.clone(),
+                        season_number: episode.season_number,
+                        episode: episode.episode.clone(),
+                        audio: episode.audio_locale.clone(),
+                        availability_ends: episode.availability_ends.timestamp(),
+                        leaving_soon: episode.availability_ends < leaving_soon,
+                    },
+                );
+            }
+        }
+        Ok(Self {
+            title: series.title.clone(),
+            episodes,
+        })
+    }
+
+    fn audio_locales(&self) -> Vec<Locale> {
+        let mut audio_locales: Vec<Locale> = vec![];
+        for episode in self.episodes.values() {
+            if !audio_locales.contains(&episode.audio) {
+                audio_locales.push(episode.audio.clone())
+            }
+        }
+        audio_locales
+    }
+}
+
+#[derive(Clone, Debug, Deserialize, Serialize)]
+struct EpisodeSnapshot {
+    title: String,
+    season_number: u32,
+    episode: String,
+    audio: Locale,
+    availability_ends: i64,
+    #[serde(default)]
+    leaving_soon: bool,
+}
+
+#[derive(Debug, Serialize)]
+#[serde(tag = "event", rename_all = "snake_case")]
+enum AvailabilityEvent {
+    DubAdded {
+        series_id: String,
+        series_title: String,
+        audio: Locale,
+    },
+    DubRemoved {
+        series_id: String,
+        series_title: String,
+        audio: Locale,
+    },
+    EpisodeAdded {
+        series_id: String,
+        series_title: String,
+        episode_id: String,
+        episode: EpisodeSnapshot,
+    },
+    EpisodeRemoved {
+        series_id: String,
+        series_title: String,
+        episode_id: String,
+        episode: EpisodeSnapshot,
+    },
+    LeavingSoon {
+        series_id: String,
+        series_title: String,
+        episode_id: String,
+        episode: EpisodeSnapshot,
+    },
+}
+
+impl std::fmt::Display for AvailabilityEvent {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        let episode_fmt = |episode: &EpisodeSnapshot| {
+            format!(
+                "S{:02}E{:0>2} - {} ({})",
+                episode.season_number, episode.episode, episode.title, episode.audio
+            )
+        };
+        match self {
+            AvailabilityEvent::DubAdded {
+                series_title,
+                audio,
+                ..
+            } => write!(f, "{}: {} dub was added", series_title, audio),
+            AvailabilityEvent::DubRemoved {
+                series_title,
+                audio,
+                ..
+            } => write!(f, "{}: {} dub was removed", series_title, audio),
+            AvailabilityEvent::EpisodeAdded {
+                series_title,
+                episode,
+                ..
+            } => write!(f, "{}: {} was added", series_title, episode_fmt(episode)),
+            AvailabilityEvent::EpisodeRemoved {
+                series_title,
+                episode,
+                ..
+            } => write!(f, "{}: {} was removed", series_title, episode_fmt(episode)),
+            AvailabilityEvent::LeavingSoon {
+                series_title,
+                episode,
+                ..
+            } => write!(
+                f,
+                "{}: {} leaves the catalog on {}",
+                series_title,
+                episode_fmt(episode),
+                DateTime::from_timestamp(episode.availability_ends, 0)
+                    .unwrap_or_default()
+                    .format("%Y-%m-%d")
+            ),
+        }
+    }
+}
+
+impl Execute for Availability {
+    fn pre_check(&mut self) -> Result<()> {
+        if self.state.is_none() && self.interval.is_none() {
+            bail!("Either `--state` or `--interval` must be given, else there is nothing to compare the availability with")
+        }
+        if self.interval.is_some_and(|interval| interval.is_zero()) {
+            bail!("`--interval` must be greater than 0")
+        }
+        Ok(())
+    }
+
+    async fn execute(self, ctx: Context) -> Result<()> {
+        let mut snapshots: BTreeMap<String, SeriesSnapshot> = match &self.state {
+            Some(state) if state.exists() => match serde_json::from_slice(&fs::read(state)?) {
+                Ok(snapshots) => snapshots,
+                Err(e) => {
+                    // without the last availability every change is only detected on the next run
+                    quarantine(state, e)?;
+                    BTreeMap::new()
+                }
+            },
+            _ => BTreeMap::new(),
+        };
+
+        loop {
+            // a failed check is only fatal if it's the only one, else the next check is awaited
+            if let Err(e) = self.check(&ctx, &mut snapshots).await {
+                if self.interval.is_none() {
+                    return Err(e);
+                }
+                warn!("Failed to check availability: {}", e)
+            }
+            if let Some(state) = &self.state {
+                write_atomic(state, serde_json::to_vec(&snapshots)?)?
+            }
+
+            let Some(interval) = self.interval else {
+                break;
+            };
+            debug!("Next availability check in {}s", interval.num_seconds());
+            tokio::time::sleep(interval.to_std()?).await
+        }
+
+        Ok(())
+    }
+}
+
+impl Availability {
+    async fn check(
+        &self,
+        ctx: &Context,
+        snapshots: &mut BTreeMap<String, SeriesSnapshot>,
+    ) -> Result<()> {
+        let leaving_soon = Utc::now() + self.leaving_soon;
+
+        for url in &self.urls {
+            let (media_collection, _) = parse_url(&ctx.crunchy, url.clone(), false).await?;
+            let MediaCollection::Series(series) = media_collection else {
+                bail!("Only series urls can be watched ({})", url)
+            };
+
+            let snapshot = SeriesSnapshot::new(&series, leaving_soon).await?;
+            for event in diff(&series.id, snapshots.get(&series.id), &snapshot) {
+                if self.json {
+                    println!("{}", serde_json::to_string(&event)?)
+                } else {
+                    println!("{}", event)
+                }
+                notify(event.to_string())
+            }
+            snapshots.insert(series.id.clone(), snapshot);
+        }
+
+        Ok(())
+    }
+}
+
+/// Compare two snapshots of a series. If there is no previous snapshot, only episodes which are
+/// leaving soon are reported as everything else would be reported as added.
+fn diff(
+    series_id: &str,
+    old: Option<&SeriesSnapshot>,
+    new: &SeriesSnapshot,
+) -> Vec<AvailabilityEvent> {
+    let mut events = vec![];
+
+    if let Some(old) = old {
+        let old_audio_locales = old.audio_locales();
+        let new_audio_locales = new.audio_locales();
+        for audio in &new_audio_locales {
+            if !old_audio_locales.contains(audio) {
+                events.push(AvailabilityEvent::DubAdded {
+                    series_id: series_id.to_string(),
+                    series_title: new.title.clone(),
+                    audio: audio.clone(),
+                })
+            }
+        }
+        for audio in old_audio_locales {
+            if !new_audio_locales.contains(&audio) {
+                events.push(AvailabilityEvent::DubRemoved {
+                    series_id: series_id.to_string(),
+                    series_title: new.title.clone(),
+                    audio,
+                })
+            }
+        }
+
+        for (id, episode) in &new.episodes {
+            if !old.episodes.contains_key(id) {
+                events.push(AvailabilityEvent::EpisodeAdded {
+                    series_id: series_id.to_string(),
+                    series_title: new.title.clone(),
+                    episode_id: id.clone(),
+                    episode: episode.clone(),
+                })
+            }
+        }
+        for (id, episode) in &old.episodes {
+            if !new.episodes.contains_key(id) {
+                events.push(AvailabilityEvent::EpisodeRemoved {
+                    series_id: series_id.to_string(),
+                    series_title: new.title.clone(),
+                    episode_id: id.clone(),
+                    episode: episode.clone(),
+                })
+            }
+        }
+    }
+
+    for (id, episode) in &new.episodes {
+        // episodes are only reported once, when they are leaving soon for the first time
+        let already_reported = old
+            .and_then(|old| old.episodes.get(id))
+            .is_some_and(|old_episode| old_episode.leaving_soon);
+        if episode.leaving_soon && !already_reported {
+            events.push(AvailabilityEvent::LeavingSoon {
+                series_id: series_id.to_string(),
+                series_title: new.title.clone(),
+                episode_id: id.clone(),
+                episode: episode.clone(),
+            })
+        }
+    }
+
+    events
+}
@@ -0,0 +1,3 @@
+mod command;
+
+pub use command::Availability;
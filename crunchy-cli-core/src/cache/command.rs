@@ -0,0 +1,176 @@
+use crate::utils::download::SUBTITLE_CACHE;
+use crate::utils::fmt::format_file_size;
+use crate::utils::os::{cache_dir, sanitize};
+use anyhow::{bail, Result};
+use log::info;
+use std::fs;
+use std::path::PathBuf;
+
+/// Names of all cache directories, see [`cache_dir`].
+const CACHES: &[&str] = &["fonts", SUBTITLE_CACHE];
+
+#[derive(Debug, clap::Parser)]
+#[clap(about = "Manage the local cache of subtitles and fonts")]
+pub struct Cache {
+    #[command(subcommand)]
+    action: CacheAction,
+}
+
+#[derive(Debug, clap::Subcommand)]
+enum CacheAction {
+    #[clap(about = "Show the size of the caches")]
+    Info,
+    #[clap(about = "Copy all cached subtitles to a directory, sorted by language")]
+    Export {
+        #[arg(help = "Directory to copy the subtitles to")]
+        output: PathBuf,
+    },
+    #[clap(about = "Remove all cached subtitles and fonts")]
+    Clear,
+}
+
+impl Cache {
+    pub fn run(&self) -> Result<()> {
+        match &self.action {
+            CacheAction::Info => {
+                for name in CACHES {
+                    let dir = cache_dir(name)?;
+                    let (mut files, mut size) = (0, 0);
+                    for entry in fs::read_dir(&dir)? {
+                        files += 1;
+                        size += entry?.metadata()?.len()
+                    }
+                    info!(
+                        "{}: {} file(s), {} ({})",
+                        name,
+                        files,
+                        format_file_size(size),
+                        dir.to_string_lossy()
+                    )
+                }
+            }
+            CacheAction::Export { output } => {
+                if output.exists() && !output.is_dir() {
+                    bail!("{} is not a directory", output.to_string_lossy())
+                }
+                let dir = cache_dir(SUBTITLE_CACHE)?;
+                let mut exported = 0;
+                for entry in fs::read_dir(&dir)? {
+                    let path = entry?.path();
+                    let file_name = path.file_name().unwrap().to_string_lossy().to_string();
+                    let Some(cached) = CachedSubtitle::parse(&file_name) else {
+                        continue;
+                    };
+                    let name = fs::read_to_string(dir.join(format!("{}.name", cached.episode_id)))
+                        .map_or(cached.episode_id.to_string(), |name| {
+                            sanitize(name, true, false)
+                        });
+                    let locale_dir = output.join(cached.locale);
+                    fs::create_dir_all(&locale_dir)?;
+                    fs::copy(
+                        &path,
+                        locale_dir.join(format!(
+                            "{}{}.{}",
+                            name,
+                            if cached.cc { ".cc" } else { "" },
+                            cached.extension
+                        )),
+                    )?;
+                    exported += 1
+                }
+                info!(
+                    "Exported {} subtitle(s) to {}",
+                    exported,
+                    output.to_string_lossy()
+                )
+            }
+            CacheAction::Clear => {
+                for name in CACHES {
+                    fs::remove_dir_all(cache_dir(name)?)?
+                }
+                info!("Cleared cache")
+            }
+        }
+        Ok(())
+    }
+}
+
+/// A subtitle in the subtitle cache. Cached subtitles are named
+/// `<episode id>_<locale>[_cc].<format>` and exported as `<locale>/<name>[.cc].<format>`, with the
+/// name of the video which is stored as `<episode id>.name` (falls back to the episode id if it's
+/// missing).
+#[derive(Debug, Eq, PartialEq)]
+struct CachedSubtitle<'a> {
+    episode_id: &'a str,
+    locale: &'a str,
+    cc: bool,
+    extension: &'a str,
+}
+
+impl<'a> CachedSubtitle<'a> {
+    /// Parse the name of a file in the subtitle cache. Returns `None` for files which aren't
+    /// subtitles, like the stored video names or entries of older versions, which were named after
+    /// a hash of their url.
+    fn parse(file_name: &'a str) -> Option<Self> {
+        let (stem, extension) = file_name.rsplit_once('.')?;
+        if extension == "name" {
+            return None;
+        }
+        let mut parts = stem.split('_');
+        let (Some(episode_id), Some(locale)) = (parts.next(), parts.next()) else {
+            return None;
+        };
+        let cc = match parts.next() {
+            Some("cc") => true,
+            Some(_) => return None,
+            None => false,
+        };
+        if parts.next().is_some() {
+            return None;
+        }
+        Some(Self {
+            episode_id,
+            locale,
+            cc,
+            extension,
+        })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn parse_cached_subtitle() {
+        assert_eq!(
+            CachedSubtitle::parse("GRDV0019R_de-DE.ass"),
+            Some(CachedSubtitle {
+                episode_id: "GRDV0019R",
+                locale: "de-DE",
+                cc: false,
+                extension: "ass",
+            })
+        );
+        assert_eq!(
+            CachedSubtitle::parse("GRDV0019R_en-US_cc.vtt"),
+            Some(CachedSubtitle {
+                episode_id: "GRDV0019R",
+                locale: "en-US",
+                cc: true,
+                extension: "vtt",
+            })
+        );
+        assert_eq!(CachedSubtitle::parse("GRDV0019R.name"), None);
+        assert_eq!(CachedSubtitle::parse("GRDV0019R_en-US_x.ass"), None);
+        assert_eq!(CachedSubtitle::parse("GRDV0019R_en-US_cc_x.ass"), None);
+        assert_eq!(
+            CachedSubtitle::parse("5f4dcc3b5aa765d61d8327deb882cf99"),
+            None
+        );
+        assert_eq!(
+            CachedSubtitle::parse("5f4dcc3b5aa765d61d8327deb882cf99.ass"),
+            None
+        )
+    }
+}
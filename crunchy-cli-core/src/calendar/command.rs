@@ -0,0 +1,185 @@
+use crate::utils::context::Context;
+use crate::utils::i18n::tr;
+use crate::utils::log::progress;
+use crate::Execute;
+use anyhow::{bail, Result};
+use chrono::{DateTime, Datelike, Local, Utc};
+use crunchyroll_rs::common::StreamExt;
+use crunchyroll_rs::search::BrowseOptions;
+use crunchyroll_rs::{Locale, MediaCollection, Series};
+use log::debug;
+use serde::Serialize;
+
+#[derive(Debug, clap::Parser)]
+#[clap(about = "Show the weekly airing schedule of the current simulcasts")]
+pub struct Calendar {
+    #[arg(
+        help = "Simulcast season to show the schedule of, e.g. 'fall-2024'. Default is the current one"
+    )]
+    #[arg(long)]
+    season: Option<String>,
+    #[arg(help = "List all available simulcast seasons")]
+    #[arg(long, default_value_t = false)]
+    list_seasons: bool,
+
+    #[arg(help = "Only show what airs today")]
+    #[arg(long, default_value_t = false)]
+    today: bool,
+
+    #[arg(help = "Print the schedule as json lines")]
+    #[arg(long_help = "Print the schedule as json lines. \
+    Every line contains the series id and title, the episode number, the air time (as unix timestamp) of the latest episode and the available audio and subtitle languages")]
+    #[arg(long, default_value_t = false)]
+    json: bool,
+}
+
+#[derive(Debug, Serialize)]
+struct CalendarEntry {
+    series_id: String,
+    series_title: String,
+    episode_number: String,
+    air_date: i64,
+    audio: Vec<Locale>,
+    subtitles: Vec<Locale>,
+}
+
+impl Execute for Calendar {
+    async fn execute(self, ctx: Context) -> Result<()> {
+        let simulcast_seasons = ctx.crunchy.simulcast_seasons().await?;
+        if self.list_seasons {
+            for simulcast_season in simulcast_seasons {
+                println!(
+                    "{:<12}  {}",
+                    simulcast_season.id, simulcast_season.localization.title
+                )
+            }
+            return Ok(());
+        }
+
+        let simulcast_season = match &self.season {
+            Some(season) => simulcast_seasons.into_iter().find(|s| &s.id == season),
+            None => simulcast_seasons.into_iter().next(),
+        };
+        let Some(simulcast_season) = simulcast_season else {
+            bail!("Simulcast season not found, use `--list-seasons` to show all available seasons")
+        };
+
+        let progress_handler = progress!(
+            "{}",
+            tr!(
+                "Fetching schedule of {0}",
+                simulcast_season.localization.title
+            )
+        );
+        let mut entries = vec![];
+        let mut browse = ctx
+            .crunchy
+            .browse(BrowseOptions::default().simulcast(simulcast_season.id.clone()));
+        while let Some(media_collection) = browse.next().await {
+            let MediaCollection::Series(series) = media_collection? else {
+                continue;
+            };
+            match CalendarEntry::new(series).await? {
+                Some(entry) => entries.push(entry),
+                None => debug!("Series has no simulcast episodes, skipping"),
+            }
+        }
+        progress_handler.stop(tr!("Fetched schedule"));
+
+        // sort by weekday (starting with monday) and time of the day in local time
+        entries.sort_by_key(|e| {
+            let air_date = e.local_air_date();
+            (air_date.weekday().num_days_from_monday(), air_date.time())
+        });
+        let today = Local::now().weekday();
+        for entry in entries {
+            if self.today && entry.local_air_date().weekday() != today {
+                continue;
+            }
+            if self.json {
+                println!("{}", serde_json::to_string(&entry)?)
+            } else {
+                println!("{}", entry)
+            }
+        }
+
+        Ok(())
+    }
+}
+
+impl CalendarEntry {
+    /// Create an entry from the latest episode of the simulcast seasons of a series. Dubs are
+    /// separate seasons on Crunchyroll, their audio languages are merged into the entry.
+    async fn new(series: Series) -> Result<Option<Self>> {
+        let mut latest = None;
+        let mut audio = vec![];
+        let mut subtitles = vec![];
+        for season in series.seasons().await? {
+            if !season.is_simulcast {
+                continue;
+            }
+            for episode in season.episodes().await? {
+                if !audio.contains(&episode.audio_locale) {
+                    audio.push(episode.audio_locale.clone())
+                }
+                for locale in &episode.subtitle_locales {
+                    if !subtitles.contains(locale) {
+                        subtitles.push(locale.clone())
+                    }
+                }
+                // the original audio airs first, so the schedule follows the first published
+                // version of the newest episode
+                if latest
+                    .as_ref()
+                    .map_or(true, |(number, air_date): &(f32, DateTime<Utc>)| {
+                        episode.sequence_number > *number
+                            || (episode.sequence_number == *number
+                                && episode.episode_air_date < *air_date)
+                    })
+                {
+                    latest = Some((episode.sequence_number, episode.episode_air_date))
+                }
+            }
+        }
+
+        let Some((sequence_number, air_date)) = latest else {
+            return Ok(None);
+        };
+        Ok(Some(Self {
+            series_id: series.id,
+            series_title: series.title,
+            episode_number: sequence_number.to_string(),
+            air_date: air_date.timestamp(),
+            audio,
+            subtitles,
+        }))
+    }
+
+    fn local_air_date(&self) -> DateTime<Local> {
+        DateTime::from_timestamp(self.air_date, 0)
+            .unwrap_or_default()
+            .with_timezone(&Local)
+    }
+}
+
+impl std::fmt::Display for CalendarEntry {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(
+            f,
+            "{}  {} E{:0>2}  (audio: {}, subtitles: {})",
+            self.local_air_date().format("%a %H:%M"),
+            self.series_title,
+            self.episode_number,
+            self.audio
+                .iter()
+                .map(|l| l.to_string())
+                .collect::<Vec<String>>()
+                .join(", "),
+            self.subtitles
+                .iter()
+                .map(|l| l.to_string())
+                .collect::<Vec<String>>()
+                .join(", ")
+        )
+    }
+}
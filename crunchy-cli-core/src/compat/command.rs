@@ -0,0 +1,172 @@
+use crate::utils::context::Context;
+use crate::Execute;
+use anyhow::{bail, Result};
+use crunchyroll_rs::common::StreamExt;
+use crunchyroll_rs::error::Error;
+use crunchyroll_rs::{MediaCollection, Series};
+use log::{debug, info, warn};
+use serde::Serialize;
+use std::fs;
+use std::path::PathBuf;
+
+#[derive(Debug, clap::Parser)]
+#[clap(about = "Check if the responses of all used api endpoints can still be decoded")]
+pub struct Compat {
+    #[arg(help = "Series url which is used as sample for the checks")]
+    #[arg(
+        long,
+        default_value = "https://www.crunchyroll.com/series/GY8VEQ95Y/darling-in-the-franxx"
+    )]
+    series: String,
+
+    #[arg(help = "Write the compatibility report as json to a file")]
+    #[arg(long)]
+    report: Option<PathBuf>,
+}
+
+#[derive(Debug, Serialize)]
+#[serde(rename_all = "snake_case")]
+enum CompatStatus {
+    Ok,
+    /// The response could not be decoded, the api has changed.
+    Incompatible,
+    /// The request failed for another reason (e.g. network or premium restrictions).
+    Error,
+    /// The check could not run because a previous check failed.
+    Skipped,
+}
+
+#[derive(Debug, Serialize)]
+struct CompatCheck {
+    endpoint: &'static str,
+    status: CompatStatus,
+    message: Option<String>,
+}
+
+#[derive(Debug, Serialize)]
+struct CompatReport {
+    version: String,
+    premium: bool,
+    checks: Vec<CompatCheck>,
+}
+
+impl CompatReport {
+    fn push<T>(&mut self, endpoint: &'static str, result: Result<T, Error>) -> Option<T> {
+        let (status, message, value) = match result {
+            Ok(value) => (CompatStatus::Ok, None, Some(value)),
+            Err(e @ Error::Decode { .. }) => {
+                (CompatStatus::Incompatible, Some(e.to_string()), None)
+            }
+            Err(e) => (CompatStatus::Error, Some(e.to_string()), None),
+        };
+        self.checks.push(CompatCheck {
+            endpoint,
+            status,
+            message,
+        });
+        value
+    }
+
+    fn skip(&mut self, endpoint: &'static str) {
+        self.checks.push(CompatCheck {
+            endpoint,
+            status: CompatStatus::Skipped,
+            message: None,
+        })
+    }
+}
+
+impl Execute for Compat {
+    async fn execute(self, ctx: Context) -> Result<()> {
+        let premium = ctx.crunchy.premium().await;
+        if !premium {
+            warn!("Some checks may fail when using `compat` anonymously or with a non-premium account")
+        }
+
+        let mut report = CompatReport {
+            version: env!("CARGO_PKG_VERSION").to_string(),
+            premium,
+            checks: vec![],
+        };
+
+        let mut top_results = ctx.crunchy.query("a").top_results;
+        report.push("search", top_results.next().await.transpose());
+
+        let Some(crunchyroll_rs::parse::UrlType::Series(series_id)) =
+            crunchyroll_rs::parse_url(self.series.clone())
+        else {
+            bail!("'{}' is not a series url", self.series)
+        };
+        let series: Option<Series> = match report.push(
+            "series",
+            ctx.crunchy.media_collection_from_id(series_id).await,
+        ) {
+            Some(MediaCollection::Series(series)) => Some(series),
+            Some(_) => bail!("'{}' is not a series url", self.series),
+            None => None,
+        };
+
+        let season = match &series {
+            Some(series) => report
+                .push("seasons", series.seasons().await)
+                .and_then(|seasons| seasons.into_iter().next()),
+            None => {
+                report.skip("seasons");
+                None
+            }
+        };
+
+        let episode = match &season {
+            Some(season) => report
+                .push("episodes", season.episodes().await)
+                .and_then(|episodes| episodes.into_iter().next()),
+            None => {
+                report.skip("episodes");
+                None
+            }
+        };
+
+        if let Some(episode) = &episode {
+            report.push("skip events", episode.skip_events().await);
+            match report.push("stream", episode.stream_maybe_without_drm().await) {
+                Some(stream) => {
+                    report.push("stream data", stream.stream_data(None).await);
+                    if let Err(e) = stream.invalidate().await {
+                        debug!("Failed to invalidate stream: {}", e)
+                    }
+                }
+                None => report.skip("stream data"),
+            }
+        } else {
+            report.skip("skip events");
+            report.skip("stream");
+            report.skip("stream data");
+        }
+
+        for check in &report.checks {
+            match &check.message {
+                Some(message) => info!("{:<12} {:?}: {}", check.endpoint, check.status, message),
+                None => info!("{:<12} {:?}", check.endpoint, check.status),
+            }
+        }
+
+        if let Some(path) = &self.report {
+            fs::write(path, serde_json::to_string_pretty(&report)?)?;
+            info!("Wrote compatibility report to {}", path.to_string_lossy())
+        }
+
+        let incompatible = report
+            .checks
+            .iter()
+            .filter(|c| matches!(c.status, CompatStatus::Incompatible))
+            .count();
+        if incompatible > 0 {
+            bail!(
+                "{} endpoint(s) returned responses which could not be decoded",
+                incompatible
+            )
+        }
+
+        Ok(())
+    }
+}
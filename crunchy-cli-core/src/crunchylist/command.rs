@@ -0,0 +1,161 @@
+use crate::utils::context::Context;
+use crate::utils::parse::parse_url;
+use crate::Execute;
+use anyhow::{bail, Result};
+use crunchyroll_rs::crunchyroll::SessionToken;
+use crunchyroll_rs::list::{CrunchylistPreview, Crunchylists};
+use crunchyroll_rs::{Crunchyroll, MediaCollection};
+use log::info;
+
+#[derive(Debug, clap::Parser)]
+#[clap(about = "Manage the custom lists (Crunchylists) of your account")]
+pub struct Crunchylist {
+    #[command(subcommand)]
+    action: CrunchylistAction,
+}
+
+#[derive(Debug, clap::Subcommand)]
+enum CrunchylistAction {
+    #[clap(about = "Show all lists, or the entries of a list")]
+    List {
+        #[arg(help = "Name of the list whose entries should be shown")]
+        name: Option<String>,
+    },
+    #[clap(about = "Create a new list")]
+    Create {
+        #[arg(help = "Name of the new list")]
+        name: String,
+    },
+    #[clap(about = "Delete a list")]
+    Delete {
+        #[arg(help = "Name of the list")]
+        name: String,
+    },
+    #[clap(about = "Rename a list")]
+    Rename {
+        #[arg(help = "Name of the list")]
+        name: String,
+        #[arg(help = "New name of the list")]
+        new_name: String,
+    },
+    #[clap(about = "Add a series or movie listing to a list")]
+    Add {
+        #[arg(help = "Name of the list")]
+        name: String,
+        #[arg(help = "Url of the series or movie listing")]
+        url: String,
+    },
+    #[clap(about = "Remove a series or movie listing from a list")]
+    Remove {
+        #[arg(help = "Name of the list")]
+        name: String,
+        #[arg(help = "Url of the series or movie listing")]
+        url: String,
+    },
+}
+
+impl Execute for Crunchylist {
+    async fn execute(self, ctx: Context) -> Result<()> {
+        if matches!(ctx.crunchy.session_token().await, SessionToken::Anonymous) {
+            bail!("Crunchylists cannot be used when logging in anonymously")
+        }
+
+        let crunchylists = ctx.crunchy.crunchylists().await?;
+        match self.action {
+            CrunchylistAction::List { name: None } => {
+                for list in &crunchylists.items {
+                    println!("{} ({} entries)", list.title, list.total)
+                }
+            }
+            CrunchylistAction::List { name: Some(name) } => {
+                let list = find_list(&crunchylists, &name)?.crunchylist().await?;
+                for entry in list.items {
+                    println!("{}", media_collection_title(&entry.panel))
+                }
+            }
+            CrunchylistAction::Create { name } => {
+                if crunchylists.items.iter().any(|l| l.title == name) {
+                    bail!("A list named '{}' already exists", name)
+                }
+                crunchylists.create(&name).await?;
+                info!("Created list '{}'", name)
+            }
+            CrunchylistAction::Delete { name } => {
+                find_list(&crunchylists, &name)?.delete().await?;
+                info!("Deleted list '{}'", name)
+            }
+            CrunchylistAction::Rename { name, new_name } => {
+                find_list(&crunchylists, &name)?.rename(&new_name).await?;
+                info!("Renamed list '{}' to '{}'", name, new_name)
+            }
+            CrunchylistAction::Add { name, url } => {
+                let list = find_list(&crunchylists, &name)?.crunchylist().await?;
+                let media_collection = series_or_movie_listing(&ctx.crunchy, url).await?;
+                let title = media_collection_title(&media_collection);
+                list.add(media_collection).await?;
+                info!("Added {} to '{}'", title, name)
+            }
+            CrunchylistAction::Remove { name, url } => {
+                let list = find_list(&crunchylists, &name)?.crunchylist().await?;
+                let media_collection = series_or_movie_listing(&ctx.crunchy, url).await?;
+                let id = media_collection_id(&media_collection);
+                let Some(entry) = list
+                    .items
+                    .into_iter()
+                    .find(|e| media_collection_id(&e.panel) == id)
+                else {
+                    bail!(
+                        "{} is not in '{}'",
+                        media_collection_title(&media_collection),
+                        name
+                    )
+                };
+                entry.delete().await?;
+                info!(
+                    "Removed {} from '{}'",
+                    media_collection_title(&media_collection),
+                    name
+                )
+            }
+        }
+
+        Ok(())
+    }
+}
+
+fn find_list<'a>(crunchylists: &'a Crunchylists, name: &str) -> Result<&'a CrunchylistPreview> {
+    match crunchylists.items.iter().find(|l| l.title == name) {
+        Some(list) => Ok(list),
+        None => bail!("No list named '{}' found", name),
+    }
+}
+
+/// Crunchylists can only contain series and movie listings, so the url is resolved to one of them.
+async fn series_or_movie_listing(crunchy: &Crunchyroll, url: String) -> Result<MediaCollection> {
+    let (media_collection, _) = parse_url(crunchy, url, false).await?;
+    Ok(match media_collection {
+        MediaCollection::Series(_) | MediaCollection::MovieListing(_) => media_collection,
+        MediaCollection::Season(season) => MediaCollection::Series(season.series().await?),
+        MediaCollection::Episode(episode) => MediaCollection::Series(episode.series().await?),
+        MediaCollection::Movie(movie) => {
+            MediaCollection::MovieListing(movie.movie_listing().await?)
+        }
+        _ => bail!("Only series and movie listings can be added to a list"),
+    })
+}
+
+fn media_collection_id(media_collection: &MediaCollection) -> &str {
+    match media_collection {
+        MediaCollection::Series(series) => &series.id,
+        MediaCollection::MovieListing(movie_listing) => &movie_listing.id,
+        _ => "",
+    }
+}
+
+fn media_collection_title(media_collection: &MediaCollection) -> String {
+    match media_collection {
+        MediaCollection::Series(series) => series.title.clone(),
+        MediaCollection::MovieListing(movie_listing) => movie_listing.title.clone(),
+        _ => "unknown".to_string(),
+    }
+}
@@ -0,0 +1,250 @@
+use crate::utils::context::Context;
+use crate::utils::os::has_ffmpeg;
+use anyhow::Result;
+use base64::engine::general_purpose::URL_SAFE_NO_PAD;
+use base64::Engine;
+use chrono::{DateTime, Utc};
+use crunchyroll_rs::crunchyroll::SessionToken;
+use log::info;
+use reqwest::{Client, StatusCode};
+use serde::Serialize;
+use std::fs;
+use std::path::PathBuf;
+use std::process::Command;
+use std::time::Instant;
+
+/// Hosts which must be reachable to use crunchy-cli. The api (including login) runs on
+/// `www.crunchyroll.com`, streams are requested from the play service and subtitles are served via
+/// `static.crunchyroll.com`.
+const HOSTS: [&str; 3] = [
+    "www.crunchyroll.com",
+    "cr-play-service.prd.crunchyrollsvc.com",
+    "static.crunchyroll.com",
+];
+
+#[derive(Clone, Debug, clap::Parser)]
+#[clap(
+    about = "Show a health report of the login, network and ffmpeg, e.g. to attach it to bug reports"
+)]
+pub struct Diagnose {
+    #[arg(help = "Write the diagnose report as json to a file")]
+    #[arg(long)]
+    report: Option<PathBuf>,
+}
+
+#[derive(Debug, Serialize)]
+#[serde(rename_all = "snake_case")]
+enum DiagnoseStatus {
+    Ok,
+    /// Something works, but not as expected (e.g. no premium account or rate limited).
+    Warning,
+    Error,
+    /// The check could not run because a previous check failed.
+    Skipped,
+}
+
+#[derive(Debug, Serialize)]
+struct DiagnoseCheck {
+    name: String,
+    status: DiagnoseStatus,
+    message: Option<String>,
+}
+
+#[derive(Debug, Serialize)]
+struct DiagnoseReport {
+    version: String,
+    os: String,
+    checks: Vec<DiagnoseCheck>,
+}
+
+impl DiagnoseReport {
+    fn push<S: Into<String>>(&mut self, name: S, status: DiagnoseStatus, message: Option<String>) {
+        self.checks.push(DiagnoseCheck {
+            name: name.into(),
+            status,
+            message,
+        })
+    }
+}
+
+impl Diagnose {
+    /// Unlike other commands, this one gets the result of the login instead of a context, as a
+    /// failing login is one of the things which should be diagnosed.
+    pub async fn run(self, client: Client, ctx: Result<Context>) -> Result<()> {
+        let mut report = DiagnoseReport {
+            version: crate::version(),
+            os: format!("{} {}", std::env::consts::OS, std::env::consts::ARCH),
+            checks: vec![],
+        };
+
+        match ffmpeg_version() {
+            Some(version) => report.push("ffmpeg", DiagnoseStatus::Ok, Some(version)),
+            None => report.push(
+                "ffmpeg",
+                DiagnoseStatus::Error,
+                Some("ffmpeg not found in PATH".to_string()),
+            ),
+        }
+
+        for host in HOSTS {
+            let start = Instant::now();
+            let (status, message) = match client.get(format!("https://{}/", host)).send().await {
+                Ok(res) => match res.status() {
+                    StatusCode::TOO_MANY_REQUESTS => (
+                        DiagnoseStatus::Warning,
+                        format!(
+                            "rate limited{}",
+                            res.headers()
+                                .get("retry-after")
+                                .and_then(|v| v.to_str().ok())
+                                .map_or("".to_string(), |v| format!(", retry after {}s", v))
+                        ),
+                    ),
+                    StatusCode::FORBIDDEN if host == HOSTS[0] => (
+                        DiagnoseStatus::Warning,
+                        "blocked by bot protection, try a vpn or proxy".to_string(),
+                    ),
+                    status => (
+                        DiagnoseStatus::Ok,
+                        format!("{} in {}ms", status, start.elapsed().as_millis()),
+                    ),
+                },
+                Err(e) => (DiagnoseStatus::Error, e.to_string()),
+            };
+            report.push(format!("reachability {}", host), status, Some(message))
+        }
+
+        match country(&client).await {
+            Ok(Some(country)) => report.push("country", DiagnoseStatus::Ok, Some(country)),
+            Ok(None) => report.push(
+                "country",
+                DiagnoseStatus::Warning,
+                Some("could not be detected".to_string()),
+            ),
+            Err(e) => report.push("country", DiagnoseStatus::Error, Some(e.to_string())),
+        }
+
+        match &ctx {
+            Ok(ctx) => {
+                let session = match ctx.crunchy.session_token().await {
+                    SessionToken::RefreshToken(_) => "refresh token",
+                    SessionToken::EtpRt(_) => "etp_rt",
+                    SessionToken::Anonymous => "anonymous",
+                };
+                report.push(
+                    "login",
+                    DiagnoseStatus::Ok,
+                    Some(format!("logged in via {}", session)),
+                );
+
+                let access_token = ctx.crunchy.access_token().await;
+                match token_expiry(&access_token) {
+                    Some(expiry) if expiry > Utc::now() => report.push(
+                        "token",
+                        DiagnoseStatus::Ok,
+                        Some(format!(
+                            "valid until {} (refreshed automatically)",
+                            expiry.format("%Y-%m-%d %H:%M:%S UTC")
+                        )),
+                    ),
+                    Some(expiry) => report.push(
+                        "token",
+                        DiagnoseStatus::Error,
+                        Some(format!(
+                            "expired at {}",
+                            expiry.format("%Y-%m-%d %H:%M:%S UTC")
+                        )),
+                    ),
+                    None => report.push(
+                        "token",
+                        DiagnoseStatus::Warning,
+                        Some("expiry could not be read".to_string()),
+                    ),
+                }
+
+                if ctx.crunchy.premium().await {
+                    report.push("premium", DiagnoseStatus::Ok, None)
+                } else {
+                    report.push(
+                        "premium",
+                        DiagnoseStatus::Warning,
+                        Some("no premium account, premium content is not accessible".to_string()),
+                    )
+                }
+
+                if ctx.rate_limiter.is_some() {
+                    report.push(
+                        "speed limit",
+                        DiagnoseStatus::Ok,
+                        Some("enabled via `--speed-limit`".to_string()),
+                    )
+                }
+            }
+            Err(e) => {
+                report.push("login", DiagnoseStatus::Error, Some(e.to_string()));
+                report.push("token", DiagnoseStatus::Skipped, None);
+                report.push("premium", DiagnoseStatus::Skipped, None);
+            }
+        }
+
+        info!("crunchy-cli {} ({})", report.version, report.os);
+        for check in &report.checks {
+            match &check.message {
+                Some(message) => info!("{:<52} {:?}: {}", check.name, check.status, message),
+                None => info!("{:<52} {:?}", check.name, check.status),
+            }
+        }
+
+        if let Some(path) = &self.report {
+            fs::write(path, serde_json::to_string_pretty(&report)?)?;
+            info!("Wrote diagnose report to {}", path.to_string_lossy())
+        }
+
+        Ok(())
+    }
+}
+
+/// First line of `ffmpeg -version`, e.g. `ffmpeg version 6.1.1 Copyright (c) ...`.
+fn ffmpeg_version() -> Option<String> {
+    if !has_ffmpeg() {
+        return None;
+    }
+    let output = Command::new("ffmpeg").arg("-version").output().ok()?;
+    String::from_utf8_lossy(&output.stdout)
+        .lines()
+        .next()
+        .map(|l| l.to_string())
+}
+
+/// Crunchyroll is served via cloudflare, which reports the country it detected for a request in
+/// its trace endpoint. This is the country which decides which content is available. Cloudflare
+/// reports `XX` if the country is unknown and `T1` for tor exit nodes, both aren't a country.
+async fn country(client: &Client) -> Result<Option<String>> {
+    let trace = client
+        .get("https://www.crunchyroll.com/cdn-cgi/trace")
+        .send()
+        .await?
+        .error_for_status()?
+        .text()
+        .await?;
+    Ok(trace
+        .lines()
+        .find_map(|l| l.strip_prefix("loc="))
+        .filter(|c| is_country_code(c))
+        .map(|c| c.to_string()))
+}
+
+/// Check if `code` is an ISO 3166-1 alpha-2 country code. The code is only checked for its format
+/// and not against a list of all countries, so new countries don't require an update.
+fn is_country_code(code: &str) -> bool {
+    code.len() == 2 && code.chars().all(|c| c.is_ascii_uppercase()) && code != "XX"
+}
+
+/// Read the expiry (`exp` claim) of a jwt access token. The signature isn't verified as the token
+/// is only inspected and not trusted.
+fn token_expiry(token: &str) -> Option<DateTime<Utc>> {
+    let payload = token.split('.').nth(1)?;
+    let claims: serde_json::Value =
+        serde_json::from_slice(&URL_SAFE_NO_PAD.decode(payload).ok()?).ok()?;
+    DateTime::from_timestamp(claims.get("exp")?.as_i64()?, 0)
+}
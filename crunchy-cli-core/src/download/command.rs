@@ -1,21 +1,38 @@
 use crate::download::filter::DownloadFilter;
+use crate::download::overrides::SeriesOverrides;
+use crate::download::rules::{is_included, WatchlistRule};
+use crate::utils::availability::sort_by_leaving_at;
+use crate::utils::completion::{ffmpeg_preset_candidates, locale_candidates, series_candidates};
 use crate::utils::context::Context;
-use crate::utils::download::{DownloadBuilder, DownloadFormat, DownloadFormatMetadata};
-use crate::utils::ffmpeg::{FFmpegPreset, SOFTSUB_CONTAINERS};
+use crate::utils::download::{
+    cache_subtitle_name, export_subtitles, DownloadBuilder, DownloadFormat, DownloadFormatMetadata,
+    SegmentTimeouts,
+};
+use crate::utils::ffmpeg::{FFmpegPreset, MuxOption, SOFTSUB_CONTAINERS};
 use crate::utils::filter::Filter;
 use crate::utils::format::{Format, SingleFormat};
+use crate::utils::history::{add_to_history, monthly_cap_reached, History};
+use crate::utils::hook::PostProcessHook;
+use crate::utils::i18n::tr;
 use crate::utils::locale::{resolve_locales, LanguageTagging};
 use crate::utils::log::progress;
-use crate::utils::os::{free_file, has_ffmpeg, is_special_file};
-use crate::utils::parse::parse_url;
-use crate::utils::video::stream_data_from_stream;
+use crate::utils::notify::notify;
+use crate::utils::os::{free_file, has_ffmpeg, is_special_file, OutputLock};
+use crate::utils::parse::{parse_url, UrlFilter};
+use crate::utils::signal::{stop_requested, Job};
+use crate::utils::video::{is_drm, stream_data_from_stream};
+use crate::utils::write::WriteOptions;
 use crate::Execute;
 use anyhow::bail;
 use anyhow::Result;
-use crunchyroll_rs::media::Resolution;
-use crunchyroll_rs::Locale;
-use log::{debug, warn};
-use std::collections::HashMap;
+use chrono::Duration;
+use clap_complete::engine::ArgValueCandidates;
+use crunchyroll_rs::crunchyroll::SessionToken;
+use crunchyroll_rs::list::WatchlistOptions;
+use crunchyroll_rs::media::{Resolution, Subtitle};
+use crunchyroll_rs::{Episode, Locale, MediaCollection};
+use log::{debug, info, warn};
+use std::collections::{HashMap, HashSet, VecDeque};
 use std::path::Path;
 
 #[derive(Clone, Debug, clap::Parser)]
@@ -27,6 +44,7 @@ pub struct Download {
     #[arg(long_help = format!("Audio language. Can only be used if the provided url(s) point to a series. \
     Available languages are:\n  {}\nIETF tagged language codes for the shown available locales can be used too", Locale::all().into_iter().map(|l| format!("{:<6} → {}", l.to_string(), l.to_human_readable())).collect::<Vec<String>>().join("\n  ")))]
     #[arg(short, long, default_value_t = crate::utils::locale::system_locale())]
+    #[arg(add = ArgValueCandidates::new(locale_candidates))]
     pub(crate) audio: Locale,
     #[arg(skip)]
     output_audio_locale: String,
@@ -34,6 +52,7 @@ pub struct Download {
     #[arg(long_help = format!("Subtitle language. If set, the subtitle will be burned into the video and cannot be disabled. \
     Available languages are: {}\nIETF tagged language codes for the shown available locales can be used too", Locale::all().into_iter().map(|l| l.to_string()).collect::<Vec<String>>().join(", ")))]
     #[arg(short, long)]
+    #[arg(add = ArgValueCandidates::new(locale_candidates))]
     pub(crate) subtitle: Option<Locale>,
     #[arg(skip)]
     output_subtitle_locale: String,
@@ -80,6 +99,15 @@ pub struct Download {
     #[arg(short, long, default_value = "best")]
     #[arg(value_parser = crate::utils::clap::clap_parse_resolution)]
     pub(crate) resolution: Resolution,
+    #[arg(
+        help = "Use the closest available resolution if the one given via `-r` / `--resolution` isn't available"
+    )]
+    #[arg(
+        long_help = "Use the closest available resolution if the one given via `-r` / `--resolution` isn't available. \
+    The next lower resolution is preferred, only if every available resolution is higher than the requested one, the lowest available is used"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) closest_resolution: bool,
 
     #[arg(
         long,
@@ -100,6 +128,7 @@ pub struct Download {
     Available presets: \n  {}", FFmpegPreset::available_matches_human_readable().join("\n  ")))]
     #[arg(long)]
     #[arg(value_parser = FFmpegPreset::parse)]
+    #[arg(add = ArgValueCandidates::new(ffmpeg_preset_candidates))]
     pub(crate) ffmpeg_preset: Option<FFmpegPreset>,
     #[arg(
         help = "The number of threads used by ffmpeg to generate the output file. Does not work with every codec/preset"
@@ -111,13 +140,76 @@ pub struct Download {
     )]
     #[arg(long)]
     pub(crate) ffmpeg_threads: Option<usize>,
+    #[arg(help = "Key to decrypt DRM protected videos. Must be 32 hex characters")]
+    #[arg(
+        long_help = "Key to decrypt DRM protected videos. Must be 32 hex characters. \
+    The key is used for all tracks, a key id (<kid>:<key>) isn't supported. \
+    crunchy-cli doesn't obtain keys itself, you have to supply them. \
+    Without a key, DRM protected videos are skipped with a warning instead of producing unplayable output"
+    )]
+    #[arg(long, value_parser = crate::utils::clap::clap_parse_decryption_key)]
+    pub(crate) decryption_key: Option<String>,
+
+    #[arg(
+        help = "Options how the output file should be muxed. Valid options are 'faststart', 'fragmented' and 'cues-front'. Can be used multiple times"
+    )]
+    #[arg(
+        long_help = "Options how the output file should be muxed. Can be used multiple times. \
+    Valid options are 'faststart' (moves the index of mp4/mov files to the front, so they can be played before being fully loaded), \
+    'fragmented' (writes fragmented mp4/mov files which can be streamed while they're still being written) and \
+    'cues-front' (moves the seeking index of mkv files to the front; requires ffmpeg 6.0 or newer). \
+    'faststart' and 'fragmented' cannot be used together"
+    )]
+    #[arg(long)]
+    #[arg(value_parser = MuxOption::parse)]
+    pub(crate) mux_option: Vec<MuxOption>,
+
+    #[arg(help = "Add a custom metadata tag to the output file. Can be used multiple times")]
+    #[arg(
+        long_help = "Add a custom metadata tag to the output file, e.g. `--metadata source=crunchyroll`. Can be used multiple times. \
+    Must be in format of <key>=<value>. Note that not every container supports arbitrary tags"
+    )]
+    #[arg(long = "metadata", value_name = "KEY=VALUE")]
+    #[arg(value_parser = crate::utils::clap::clap_parse_metadata_tag)]
+    pub(crate) metadata_tags: Vec<(String, String)>,
 
     #[arg(help = "Skip files which are already existing by their name")]
     #[arg(long, default_value_t = false)]
     pub(crate) skip_existing: bool,
+    #[arg(help = "Skip videos which are already in the download history")]
+    #[arg(
+        long_help = "Skip videos which are already in the download history (with at least the requested audio), regardless of where they were saved. \
+    Use `--history` to share the history between multiple instances of crunchy-cli, so that every video is only downloaded once"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) skip_downloaded: bool,
+    #[arg(help = "Check if all videos can be downloaded before starting the first download")]
+    #[arg(
+        long_help = "Check if all videos can be downloaded before starting the first download. \
+    Videos which can't be downloaded (e.g. because of missing premium, region restrictions or the stream limit of your account) are reported and skipped"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) preflight: bool,
     #[arg(help = "Skip special episodes")]
     #[arg(long, default_value_t = false)]
     pub(crate) skip_specials: bool,
+    #[arg(
+        help = "Download the videos which leave the Crunchyroll catalog first before all others"
+    )]
+    #[arg(
+        long_help = "Download the videos which leave the Crunchyroll catalog first before all others. \
+    The urls are sorted by the earliest announced removal date of their episodes, urls without announced removal are downloaded last. \
+    Requires to fetch all episodes of every url before the first download starts"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) leaving_first: bool,
+    #[arg(help = "Only download episodes which are not (fully) watched yet")]
+    #[arg(
+        long_help = "Only download episodes which are not (fully) watched yet. \
+    Episodes which are partially watched are downloaded too. Requires to be logged in with an account"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) unwatched: bool,
 
     #[arg(help = "Includes chapters (e.g. intro, credits, ...)")]
     #[arg(long_help = "Includes chapters (e.g. intro, credits, ...). \
@@ -134,80 +226,144 @@ pub struct Download {
     #[arg(help = "Force subtitles to be always burnt-in")]
     #[arg(long, default_value_t = false)]
     pub(crate) force_hardsub: bool,
+    #[arg(
+        help = "Burn the subtitles into the video locally instead of using the hardsub video Crunchyroll provides"
+    )]
+    #[arg(
+        long_help = "Burn the subtitles into the video locally instead of using the hardsub video Crunchyroll provides. \
+    Only has an effect if subtitles are burned-in, either because `--force-hardsub` is set or because the output container doesn't support softsubs. \
+    This takes longer as the video has to be re-encoded, but works for every subtitle language, even if Crunchyroll does not provide a hardsub video for it"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) local_hardsub: bool,
+    #[arg(
+        help = "The constant rate factor (quality) used to encode the video when burning subtitles into it locally"
+    )]
+    #[arg(
+        long_help = "The constant rate factor (quality) used to encode the video when burning subtitles into it locally. \
+    Lower values result in a better quality but bigger files. Only has an effect in combination with `--local-hardsub`"
+    )]
+    #[arg(long)]
+    pub(crate) hardsub_crf: Option<u8>,
+    #[arg(
+        help = "The video bitrate used to encode the video when burning subtitles into it locally. Must be in format of <number>[k|M]"
+    )]
+    #[arg(
+        long_help = "The video bitrate used to encode the video when burning subtitles into it locally. \
+    Must be in format of <number>[k|M] (e.g. 4000k or 4M). Only has an effect in combination with `--local-hardsub`"
+    )]
+    #[arg(long, value_parser = crate::utils::clap::clap_parse_bitrate)]
+    pub(crate) hardsub_bitrate: Option<String>,
+    #[arg(help = "Only download the subtitles of all available languages instead of the video")]
+    #[arg(
+        long_help = "Only download the subtitles of all available languages instead of the video. \
+    The subtitles are written next to the output file with the subtitle language and format as extension, e.g. `-o '{series_name}.S{season_number}E{episode_number}.mkv'` results in files like 'Darling in the FranXX.S1E5.de-DE.ass'. \
+    Subtitle languages for which only closed captions are available get the closed captions instead"
+    )]
+    #[arg(long, default_value_t = false)]
+    #[arg(conflicts_with_all = ["subtitle", "force_hardsub", "local_hardsub"])]
+    pub(crate) subtitles_only: bool,
+
+    #[clap(flatten)]
+    pub(crate) post_process: PostProcessHook,
+
+    #[arg(help = "Download the next unwatched episodes of your watchlist")]
+    #[arg(
+        long_help = "Download the next unwatched episodes of your watchlist instead of the given url(s). \
+    Without `--max-duration` / `--max-size` only the next episode of every watchlist entry is downloaded, otherwise the next episodes of all entries are downloaded alternately until the budget is reached. \
+    Requires to be logged in with an account"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) watchlist: bool,
+    #[arg(help = "Maximal total watch time of all downloaded videos, e.g. 2h30m")]
+    #[arg(long, value_parser = crate::utils::clap::clap_parse_duration)]
+    pub(crate) max_duration: Option<Duration>,
+    #[arg(
+        help = "Maximal total (estimated) size of all downloaded videos. Must be in format of <number>[B|KB|MB|GB]"
+    )]
+    #[arg(
+        long_help = "Maximal total size of all downloaded videos. Must be in format of <number>[B|KB|MB|GB] (e.g. 4GB). \
+    The size of a video is estimated before it gets downloaded, so the real total size may differ a little bit"
+    )]
+    #[arg(long, value_parser = crate::utils::clap::clap_parse_file_size)]
+    pub(crate) max_size: Option<u64>,
+    #[arg(help = "Json file with settings which differ per series")]
+    #[arg(
+        long_help = "Json file with settings which differ per series, e.g. another output template or audio language for a single series of your watchlist. \
+    The json object maps series (or movie listing) ids to an object which may contain 'output', 'output_specials', 'audio', 'subtitle', 'resolution' and 'skip_specials'. \
+    Settings which are not given are taken from the command line arguments"
+    )]
+    #[arg(long, value_parser = SeriesOverrides::load)]
+    pub(crate) series_overrides: Option<SeriesOverrides>,
+    #[arg(
+        help = "Only download watchlist series which match this rule. Must be in format of <id|slug|category|audio>:<value>. Can be used multiple times"
+    )]
+    #[arg(
+        long_help = "Only download watchlist series which match this rule. Must be in format of <id|slug|category|audio>:<value>. \
+    'id' matches the series id, 'slug' a regex pattern against the name of the series in its url, 'category' a category (e.g. action) and 'audio' an available audio language. \
+    Can be used multiple times, a series is downloaded if it matches at least one rule"
+    )]
+    #[arg(long, requires = "watchlist", value_parser = WatchlistRule::parse)]
+    pub(crate) watchlist_include: Vec<WatchlistRule>,
+    #[arg(
+        help = "Skip watchlist series which match this rule. Must be in format of <id|slug|category|audio>:<value>. Can be used multiple times"
+    )]
+    #[arg(long, requires = "watchlist", value_parser = WatchlistRule::parse)]
+    pub(crate) watchlist_exclude: Vec<WatchlistRule>,
 
     #[arg(help = "The number of threads used to download")]
     #[arg(short, long, default_value_t = num_cpus::get())]
     pub(crate) threads: usize,
+    #[clap(flatten)]
+    pub(crate) segment_timeouts: SegmentTimeouts,
+    #[clap(flatten)]
+    pub(crate) write_options: WriteOptions,
+    #[arg(help = "Reduce the memory usage, e.g. on a Raspberry Pi or router-class devices")]
+    #[arg(
+        long_help = "Reduce the memory usage, e.g. on a Raspberry Pi or router-class devices. \
+    Segments are downloaded one after another (so no segments must be buffered until the previous ones are downloaded), the write buffer is reduced and ffmpeg uses only one thread. \
+    This overwrites `--threads` and `--ffmpeg-threads`"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) low_memory: bool,
 
     #[arg(help = "Url(s) to Crunchyroll episodes or series")]
-    #[arg(required = true)]
+    #[arg(required_unless_present = "watchlist")]
+    #[arg(add = ArgValueCandidates::new(series_candidates))]
     pub(crate) urls: Vec<String>,
 }
 
 impl Execute for Download {
     fn pre_check(&mut self) -> Result<()> {
+        self.post_process.check()?;
+        self.segment_timeouts.check()?;
+        self.write_options.check()?;
+        if self.low_memory {
+            self.threads = 1;
+            self.ffmpeg_threads = Some(1);
+            self.write_options.low_memory();
+        } else if cfg!(target_pointer_width = "32") {
+            info!("Running on a 32-bit system. Use `--low-memory` if the download fails because of missing memory")
+        }
+
         if !has_ffmpeg() {
             bail!("FFmpeg is needed to run this command")
-        } else if Path::new(&self.output)
-            .extension()
-            .unwrap_or_default()
-            .is_empty()
-            && !is_special_file(&self.output)
-            && self.output != "-"
-        {
-            bail!("No file extension found. Please specify a file extension (via `-o`) for the output file")
         }
 
-        if self.subtitle.is_some() {
-            if let Some(ext) = Path::new(&self.output).extension() {
-                if self.force_hardsub {
-                    warn!("Hardsubs are forced. Adding subtitles may take a while")
-                } else if !["mkv", "mov", "mp4"].contains(&ext.to_string_lossy().as_ref()) {
-                    warn!("Detected a container which does not support softsubs. Adding subtitles may take a while")
-                }
-            }
-        }
+        MuxOption::check_conflicts(&self.mux_option).map_err(anyhow::Error::msg)?;
+        self.check_outputs()?;
 
-        if let Some(special_output) = &self.output_specials {
-            if Path::new(special_output)
-                .extension()
-                .unwrap_or_default()
-                .is_empty()
-                && !is_special_file(special_output)
-                && special_output != "-"
-            {
-                bail!("No file extension found. Please specify a file extension (via `--output-specials`) for the output file")
-            }
-            if let Some(ext) = Path::new(special_output).extension() {
-                if self.force_hardsub {
-                    warn!("Hardsubs are forced for special episodes. Adding subtitles may take a while")
-                } else if !["mkv", "mov", "mp4"].contains(&ext.to_string_lossy().as_ref()) {
-                    warn!("Detected a container which does not support softsubs. Adding subtitles for special episodes may take a while")
-                }
-            }
+        if (self.hardsub_crf.is_some() || self.hardsub_bitrate.is_some()) && !self.local_hardsub {
+            warn!("`--hardsub-crf` and `--hardsub-bitrate` have no effect if `--local-hardsub` is not set")
         }
-
-        if let Some(language_tagging) = &self.language_tagging {
-            self.audio = resolve_locales(&[self.audio.clone()]).remove(0);
-            self.subtitle = self
-                .subtitle
-                .as_ref()
-                .map(|s| resolve_locales(&[s.clone()]).remove(0));
-            self.output_audio_locale = language_tagging.for_locale(&self.audio);
-            self.output_subtitle_locale = self
-                .subtitle
-                .as_ref()
-                .map(|s| language_tagging.for_locale(s))
-                .unwrap_or_default()
-        } else {
-            self.output_audio_locale = self.audio.to_string();
-            self.output_subtitle_locale = self
-                .subtitle
-                .as_ref()
-                .map(|s| s.to_string())
-                .unwrap_or_default();
+        if self.local_hardsub && self.subtitle.is_none() {
+            warn!("`--local-hardsub` has no effect if no subtitle is specified via `-s` / `--subtitle`")
         }
 
+        self.warn_subtitle_containers();
+
+        self.resolve_output_locales();
+
         Ok(())
     }
 
@@ -215,89 +371,263 @@ impl Execute for Download {
         if !ctx.crunchy.premium().await {
             warn!("You may not be able to download all requested videos when logging in anonymously or using a non-premium account")
         }
+        if self.unwatched && matches!(ctx.crunchy.session_token().await, SessionToken::Anonymous) {
+            bail!("`--unwatched` cannot be used when logging in anonymously")
+        }
+        if self.watchlist && matches!(ctx.crunchy.session_token().await, SessionToken::Anonymous) {
+            bail!("`--watchlist` cannot be used when logging in anonymously")
+        }
 
         let mut parsed_urls = vec![];
 
-        let output_supports_softsubs = SOFTSUB_CONTAINERS.contains(
-            &Path::new(&self.output)
-                .extension()
-                .unwrap_or_default()
-                .to_string_lossy()
-                .as_ref(),
-        );
-        let special_output_supports_softsubs = if let Some(so) = &self.output_specials {
-            SOFTSUB_CONTAINERS.contains(
-                &Path::new(so)
-                    .extension()
-                    .unwrap_or_default()
-                    .to_string_lossy()
-                    .as_ref(),
+        if self.watchlist {
+            let progress_handler = progress!("{}", tr!("Fetching watchlist"));
+            let episodes = watchlist_episodes(&ctx, &self).await?;
+            progress_handler.stop(tr!(
+                "Selected {0} episode(s) from the watchlist",
+                episodes.len()
+            ));
+            parsed_urls.extend(
+                episodes
+                    .into_iter()
+                    .map(|e| (e.into(), UrlFilter::default())),
             )
-        } else {
-            output_supports_softsubs
-        };
+        }
 
         for (i, url) in self.urls.clone().into_iter().enumerate() {
-            let progress_handler = progress!("Parsing url {}", i + 1);
+            let progress_handler = progress!("{}", tr!("Parsing url {0}", i + 1));
             match parse_url(&ctx.crunchy, url.clone(), true).await {
                 Ok((media_collection, url_filter)) => {
-                    progress_handler.stop(format!("Parsed url {}", i + 1));
+                    progress_handler.stop(tr!("Parsed url {0}", i + 1));
                     parsed_urls.push((media_collection, url_filter))
                 }
                 Err(e) => bail!("url {} could not be parsed: {}", url, e),
             };
         }
 
-        for (i, (media_collection, url_filter)) in parsed_urls.into_iter().enumerate() {
-            let progress_handler = progress!("Fetching series details");
-            let single_format_collection = DownloadFilter::new(
+        if self.leaving_first {
+            let progress_handler =
+                progress!("{}", tr!("Checking when the videos leave the catalog"));
+            parsed_urls = sort_by_leaving_at(parsed_urls).await?;
+            progress_handler.stop(tr!("Sorted urls by their removal date"))
+        }
+
+        let mut downloaded = 0;
+        // identifiers of all videos which were already processed. the same video might be included
+        // in multiple urls (e.g. a series url and an episode url of it) or the watchlist
+        let mut processed = HashSet::new();
+        // read once instead of for every video, entries of other instances are added via refresh
+        let mut history = if self.skip_downloaded {
+            History::load()?
+        } else {
+            History::default()
+        };
+        let mut total_duration = Duration::zero();
+        let mut total_size = 0;
+
+        'urls: for (i, (media_collection, url_filter)) in parsed_urls.into_iter().enumerate() {
+            let download = match &self.series_overrides {
+                Some(series_overrides) => series_overrides.apply(&self, &media_collection)?,
+                None => self.clone(),
+            };
+            let output_supports_softsubs = SOFTSUB_CONTAINERS.contains(
+                &Path::new(&download.output)
+                    .extension()
+                    .unwrap_or_default()
+                    .to_string_lossy()
+                    .as_ref(),
+            );
+            let special_output_supports_softsubs = if let Some(so) = &download.output_specials {
+                SOFTSUB_CONTAINERS.contains(
+                    &Path::new(so)
+                        .extension()
+                        .unwrap_or_default()
+                        .to_string_lossy()
+                        .as_ref(),
+                )
+            } else {
+                output_supports_softsubs
+            };
+
+            let progress_handler = progress!("{}", tr!("Fetching series details"));
+            let mut single_format_collection = DownloadFilter::new(
                 url_filter,
-                self.clone(),
-                !self.yes,
-                self.skip_specials,
+                download.clone(),
+                !download.yes,
+                download.skip_specials,
                 ctx.crunchy.premium().await,
             )
             .visit(media_collection)
             .await?;
 
             if single_format_collection.is_empty() {
-                progress_handler.stop(format!("Skipping url {} (no matching videos found)", i + 1));
+                progress_handler.stop(tr!("Skipping url {0} (no matching videos found)", i + 1));
                 continue;
             }
-            progress_handler.stop(format!("Loaded series information for url {}", i + 1));
+            progress_handler.stop(tr!("Loaded series information for url {0}", i + 1));
+
+            if download.preflight {
+                let progress_handler =
+                    progress!("{}", tr!("Checking if all videos can be downloaded"));
+                let failed = single_format_collection.preflight().await;
+                if failed.is_empty() {
+                    progress_handler.stop(tr!("All videos can be downloaded"))
+                } else {
+                    progress_handler.stop(tr!("{0} video(s) can't be downloaded", failed.len()));
+                    for (video, reason) in failed {
+                        warn!("Skipping {}: {}", video.display_name(), reason)
+                    }
+                }
+                if single_format_collection.is_empty() {
+                    continue;
+                }
+            }
 
             single_format_collection.full_visual_output();
 
             let download_builder =
                 DownloadBuilder::new(ctx.client.clone(), ctx.rate_limiter.clone())
-                    .default_subtitle(self.subtitle.clone())
-                    .force_hardsub(self.force_hardsub)
-                    .output_format(if is_special_file(&self.output) || self.output == "-" {
-                        Some("mpegts".to_string())
-                    } else {
-                        None
-                    })
-                    .ffmpeg_preset(self.ffmpeg_preset.clone().unwrap_or_default())
-                    .ffmpeg_threads(self.ffmpeg_threads)
-                    .threads(self.threads)
+                    .default_subtitle(download.subtitle.clone())
+                    .force_hardsub(download.force_hardsub)
+                    .hardsub_crf(
+                        download
+                            .local_hardsub
+                            .then_some(download.hardsub_crf)
+                            .flatten(),
+                    )
+                    .hardsub_bitrate(
+                        download
+                            .local_hardsub
+                            .then(|| download.hardsub_bitrate.clone())
+                            .flatten(),
+                    )
+                    .output_format(
+                        if is_special_file(&download.output) || download.output == "-" {
+                            Some("mpegts".to_string())
+                        } else {
+                            None
+                        },
+                    )
+                    .ffmpeg_preset(download.ffmpeg_preset.clone().unwrap_or_default())
+                    .ffmpeg_threads(download.ffmpeg_threads)
+                    .decryption_key(download.decryption_key.clone())
+                    .mux_options(download.mux_option.clone())
+                    .threads(download.threads)
+                    .segment_timeouts(download.segment_timeouts.clone())
+                    .write_options(download.write_options.clone())
                     .audio_locale_output_map(HashMap::from([(
-                        self.audio.clone(),
-                        self.output_audio_locale.clone(),
+                        download.audio.clone(),
+                        download.output_audio_locale.clone(),
                     )]))
                     .subtitle_locale_output_map(
-                        self.subtitle.as_ref().map_or(HashMap::new(), |s| {
-                            HashMap::from([(s.clone(), self.output_subtitle_locale.clone())])
+                        download.subtitle.as_ref().map_or(HashMap::new(), |s| {
+                            HashMap::from([(s.clone(), download.output_subtitle_locale.clone())])
                         }),
-                    );
+                    )
+                    .metadata_tags(download.metadata_tags.clone());
 
             for mut single_formats in single_format_collection.into_iter() {
+                if stop_requested() {
+                    info!("Stopped before downloading all videos. Run the same command again with `--skip-existing` to continue");
+                    break 'urls;
+                }
+
                 // the vec contains always only one item
                 let single_format = single_formats.remove(0);
 
-                let (download_format, format) = get_format(
-                    &self,
+                if !processed.insert(single_format.identifier.clone()) {
+                    debug!(
+                        "Skipping {} ({}) as it was already processed",
+                        single_format.title, single_format.identifier
+                    );
+                    continue;
+                }
+                if download.skip_downloaded
+                    && history.contains(&single_format.identifier, &[download.audio.clone()])
+                {
+                    debug!(
+                        "Skipping {} ({}) as it is already in the download history",
+                        single_format.title, single_format.identifier
+                    );
+                    continue;
+                }
+
+                if let Some(max_duration) = download.max_duration {
+                    if total_duration + single_format.duration > max_duration {
+                        info!("Reached the maximal duration (`--max-duration`), skipping all remaining videos");
+                        break 'urls;
+                    }
+                }
+
+                if download.subtitles_only {
+                    let path = if single_format.is_special() {
+                        download
+                            .output_specials
+                            .as_ref()
+                            .unwrap_or(&download.output)
+                    } else {
+                        &download.output
+                    };
+                    let stream = single_format.stream().await?;
+                    let format = Format::from_single_formats(vec![(
+                        single_format.clone(),
+                        stream_data_from_stream(
+                            &stream,
+                            &download.resolution,
+                            download.closest_resolution,
+                            None,
+                        )
+                        .await?
+                        .map(|(video, _, _)| video)
+                        .ok_or(anyhow::anyhow!(
+                            "Resolution ({}) is not available for {}",
+                            download.resolution,
+                            single_format.title
+                        ))?,
+                        vec![],
+                    )]);
+                    let mut subtitles: Vec<(Subtitle, bool)> = stream
+                        .subtitles
+                        .values()
+                        .map(|s| (s.clone(), false))
+                        .collect();
+                    // use closed captions as fallback if no actual subtitles are found
+                    for (locale, caption) in &stream.captions {
+                        if !stream.subtitles.contains_key(locale) {
+                            subtitles.push((caption.clone(), true))
+                        }
+                    }
+                    stream.invalidate().await?;
+                    cache_subtitle_name(&single_format);
+
+                    let dst = format.format_path(
+                        path.into(),
+                        download.universal_output,
+                        download.language_tagging.as_ref(),
+                    );
+                    for written in export_subtitles(
+                        &single_format.episode_id,
+                        subtitles,
+                        &dst,
+                        !download.skip_existing,
+                    )
+                    .await?
+                    {
+                        info!("Downloaded subtitle to '{}'", written.to_string_lossy())
+                    }
+                    total_duration = total_duration + single_format.duration;
+                    downloaded += 1;
+                    continue;
+                }
+
+                let Some((download_format, format)) = get_format(
+                    &download,
                     &single_format,
-                    if self.force_hardsub {
+                    if download.local_hardsub {
+                        // the subtitles are burned into the video locally, so the hardsub video
+                        // of crunchyroll must not be used
+                        false
+                    } else if download.force_hardsub {
                         true
                     } else if single_format.is_special() {
                         !special_output_supports_softsubs
@@ -305,29 +635,41 @@ impl Execute for Download {
                         !output_supports_softsubs
                     },
                 )
-                .await?;
+                .await?
+                else {
+                    continue;
+                };
+
+                let estimated_size = download_format.estimated_size(single_format.duration);
+                if let Some(max_size) = download.max_size {
+                    if total_size + estimated_size > max_size {
+                        info!("Reached the maximal size (`--max-size`), skipping all remaining videos");
+                        break 'urls;
+                    }
+                }
 
                 let mut downloader = download_builder.clone().build();
                 downloader.add_format(download_format);
 
                 let formatted_path = if format.is_special() {
                     format.format_path(
-                        self.output_specials
+                        download
+                            .output_specials
                             .as_ref()
-                            .map_or((&self.output).into(), |so| so.into()),
-                        self.universal_output,
-                        self.language_tagging.as_ref(),
+                            .map_or((&download.output).into(), |so| so.into()),
+                        download.universal_output,
+                        download.language_tagging.as_ref(),
                     )
                 } else {
                     format.format_path(
-                        (&self.output).into(),
-                        self.universal_output,
-                        self.language_tagging.as_ref(),
+                        (&download.output).into(),
+                        download.universal_output,
+                        download.language_tagging.as_ref(),
                     )
                 };
                 let (path, changed) = free_file(formatted_path.clone());
 
-                if changed && self.skip_existing {
+                if changed && download.skip_existing {
                     debug!(
                         "Skipping already existing file '{}'",
                         formatted_path.to_string_lossy()
@@ -335,25 +677,244 @@ impl Execute for Download {
                     continue;
                 }
 
+                // the output of stdout or special files can't be locked
+                let _lock = if is_special_file(&path) || path.to_string_lossy() == "-" {
+                    None
+                } else {
+                    match OutputLock::acquire(&path)? {
+                        Some(lock) => Some(lock),
+                        None => {
+                            warn!(
+                                "Skipping '{}' as it is currently written by another process",
+                                path.to_string_lossy()
+                            );
+                            continue;
+                        }
+                    }
+                };
+
+                // another instance might have finished the video while this instance was preparing it
+                if download.skip_downloaded {
+                    history.refresh()?
+                }
+                if download.skip_downloaded
+                    && history.contains(&single_format.identifier, &[download.audio.clone()])
+                {
+                    debug!(
+                        "Skipping {} ({}) as it was downloaded by another instance",
+                        single_format.title, single_format.identifier
+                    );
+                    continue;
+                }
+
+                if monthly_cap_reached(estimated_size)? {
+                    info!(
+                        "Reached the monthly cap (`--monthly-cap`), skipping all remaining videos"
+                    );
+                    break 'urls;
+                }
+
                 format.visual_output(&path);
 
-                downloader.download(&path).await?
+                // only videos which are actually downloaded count towards the budget, not the ones
+                // which were skipped above
+                total_size += estimated_size;
+                total_duration = total_duration + single_format.duration;
+
+                let job = Job::start();
+                downloader.download(&path).await?;
+                add_to_history(&format, &path);
+                download.post_process.run(&path).await?;
+                drop(job);
+                downloaded += 1
+            }
+        }
+
+        notify(tr!("Downloaded {0} video(s)", downloaded));
+
+        Ok(())
+    }
+}
+
+impl Download {
+    /// Checks which depend on the output paths. Series overrides can change the output paths, so
+    /// these checks are run for every override too.
+    pub(crate) fn check_outputs(&self) -> Result<()> {
+        if Path::new(&self.output)
+            .extension()
+            .unwrap_or_default()
+            .is_empty()
+            && !is_special_file(&self.output)
+            && self.output != "-"
+        {
+            bail!("No file extension found. Please specify a file extension (via `-o`) for the output file")
+        }
+        if let Some(special_output) = &self.output_specials {
+            if Path::new(special_output)
+                .extension()
+                .unwrap_or_default()
+                .is_empty()
+                && !is_special_file(special_output)
+                && special_output != "-"
+            {
+                bail!("No file extension found. Please specify a file extension (via `--output-specials`) for the output file")
+            }
+        }
+
+        for output in [Some(&self.output), self.output_specials.as_ref()]
+            .into_iter()
+            .flatten()
+        {
+            let ext = Path::new(output)
+                .extension()
+                .unwrap_or_default()
+                .to_string_lossy()
+                .to_string();
+            for mux_option in &self.mux_option {
+                if !mux_option.supports_extension(&ext) && !is_special_file(output) && output != "-"
+                {
+                    bail!(
+                        "Mux option '{}' cannot be used with '.{}' files",
+                        mux_option,
+                        ext
+                    )
+                }
             }
         }
 
+        if self.subtitles_only && (self.output == "-" || is_special_file(&self.output)) {
+            bail!("`--subtitles-only` cannot be used if the output is stdout or a special file")
+        }
+        if self.post_process.post_process.is_some() && self.output == "-" {
+            bail!("`--post-process` cannot be used if the output is stdout")
+        }
+
         Ok(())
     }
+
+    /// Warn if adding the subtitles takes longer because they have to be burned into the video.
+    pub(crate) fn warn_subtitle_containers(&self) {
+        if self.subtitle.is_some() {
+            if let Some(ext) = Path::new(&self.output).extension() {
+                if self.force_hardsub {
+                    warn!("Hardsubs are forced. Adding subtitles may take a while")
+                } else if !["mkv", "mov", "mp4"].contains(&ext.to_string_lossy().as_ref()) {
+                    warn!("Detected a container which does not support softsubs. Adding subtitles may take a while")
+                }
+            }
+        }
+
+        if let Some(special_output) = &self.output_specials {
+            if let Some(ext) = Path::new(special_output).extension() {
+                if self.force_hardsub {
+                    warn!("Hardsubs are forced for special episodes. Adding subtitles may take a while")
+                } else if !["mkv", "mov", "mp4"].contains(&ext.to_string_lossy().as_ref()) {
+                    warn!("Detected a container which does not support softsubs. Adding subtitles for special episodes may take a while")
+                }
+            }
+        }
+    }
+
+    /// Set the locales which are written into the output (file name and metadata), depending on
+    /// `--language-tagging`.
+    pub(crate) fn resolve_output_locales(&mut self) {
+        if let Some(language_tagging) = &self.language_tagging {
+            self.audio = resolve_locales(&[self.audio.clone()]).remove(0);
+            self.subtitle = self
+                .subtitle
+                .as_ref()
+                .map(|s| resolve_locales(&[s.clone()]).remove(0));
+            self.output_audio_locale = language_tagging.for_locale(&self.audio);
+            self.output_subtitle_locale = self
+                .subtitle
+                .as_ref()
+                .map(|s| language_tagging.for_locale(s))
+                .unwrap_or_default()
+        } else {
+            self.output_audio_locale = self.audio.to_string();
+            self.output_subtitle_locale = self
+                .subtitle
+                .as_ref()
+                .map(|s| s.to_string())
+                .unwrap_or_default();
+        }
+    }
 }
 
+/// Select the next unwatched episodes of the watchlist. If a duration or size budget is set, the
+/// episodes of all watchlist entries are selected alternately so that the budget isn't used up by
+/// a single series.
+async fn watchlist_episodes(ctx: &Context, download: &Download) -> Result<Vec<Episode>> {
+    let mut queues = vec![];
+    for entry in ctx.crunchy.watchlist(WatchlistOptions::default()).await? {
+        if entry.fully_watched {
+            continue;
+        }
+        let MediaCollection::Episode(next_episode) = entry.panel else {
+            continue;
+        };
+        if !download.watchlist_include.is_empty() || !download.watchlist_exclude.is_empty() {
+            let series = next_episode.series().await?;
+            if !is_included(
+                &download.watchlist_include,
+                &download.watchlist_exclude,
+                &series,
+            ) {
+                debug!("Skipping watchlist series {} ({})", series.title, series.id);
+                continue;
+            }
+        }
+
+        if download.max_duration.is_none() && download.max_size.is_none() {
+            queues.push(VecDeque::from([next_episode]));
+            continue;
+        }
+
+        let mut season_episodes = next_episode.season().await?.episodes().await?;
+        season_episodes.retain(|e| e.sequence_number >= next_episode.sequence_number);
+        queues.push(VecDeque::from(season_episodes))
+    }
+
+    let mut episodes = vec![];
+    let mut duration = Duration::zero();
+    while queues.iter().any(|q| !q.is_empty()) {
+        for queue in queues.iter_mut() {
+            let Some(episode) = queue.pop_front() else {
+                continue;
+            };
+            // the size budget can only be checked when the stream is known, so the episodes are
+            // only limited by the duration budget here
+            if let Some(max_duration) = download.max_duration {
+                if duration + episode.duration > max_duration {
+                    return Ok(episodes);
+                }
+            }
+            duration = duration + episode.duration;
+            episodes.push(episode)
+        }
+    }
+
+    Ok(episodes)
+}
+
+/// Returns [`None`] if the video is DRM protected and no key to decrypt it is given.
 async fn get_format(
     download: &Download,
     single_format: &SingleFormat,
     try_peer_hardsubs: bool,
-) -> Result<(DownloadFormat, Format)> {
+) -> Result<Option<(DownloadFormat, Format)>> {
     let stream = single_format.stream().await?;
+    if is_drm(&stream) && download.decryption_key.is_none() {
+        warn!(
+            "Skipping {}: it is DRM protected and can only be downloaded with `--decryption-key`",
+            single_format.display_name()
+        );
+        return Ok(None);
+    }
     let Some((video, audio, contains_hardsub)) = stream_data_from_stream(
         &stream,
         &download.resolution,
+        download.closest_resolution,
         if try_peer_hardsubs {
             download.subtitle.clone()
         } else {
@@ -409,6 +970,7 @@ async fn get_format(
             vec![(
                 s,
                 single_format.audio != Locale::ja_JP && stream.subtitles.len() == 1,
+                single_format.episode_id.clone(),
             )]
         }),
         metadata: DownloadFormatMetadata {
@@ -426,6 +988,7 @@ async fn get_format(
             vec![(
                 s,
                 single_format.audio != Locale::ja_JP && stream.subtitles.len() == 1,
+                single_format.episode_id.clone(),
             )]
         }),
     )]);
@@ -433,8 +996,11 @@ async fn get_format(
         let (_, subs) = format.locales.get_mut(0).unwrap();
         subs.push(download.subtitle.clone().unwrap())
     }
+    if !download_format.subtitles.is_empty() {
+        cache_subtitle_name(single_format)
+    }
 
     stream.invalidate().await?;
 
-    Ok((download_format, format))
+    Ok(Some((download_format, format)))
 }
@@ -1,5 +1,5 @@
 use crate::download::Download;
-use crate::utils::filter::Filter;
+use crate::utils::filter::{is_watched, Filter};
 use crate::utils::format::{Format, SingleFormat, SingleFormatCollection};
 use crate::utils::interactive_select::{check_for_duplicated_seasons, get_duplicated_seasons};
 use crate::utils::parse::{fract, UrlFilter};
@@ -148,6 +148,11 @@ impl Filter for DownloadFilter {
             return Ok(None);
         }
 
+        // skip the episode if it was already watched
+        if self.download.unwatched && is_watched(&episode).await? {
+            return Ok(None);
+        }
+
         // check if the audio locale is correct.
         // should only be incorrect if the console input was a episode url. otherwise
         // `DownloadFilter::visit_season` returns the correct episodes with matching audio
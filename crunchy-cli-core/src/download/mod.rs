@@ -1,4 +1,6 @@
 mod command;
 mod filter;
+mod overrides;
+mod rules;
 
 pub use command::Download;
@@ -0,0 +1,108 @@
+use crate::download::Download;
+use crate::utils::os::is_special_file;
+use crate::utils::parse::parse_resolution;
+use anyhow::{anyhow, Result};
+use crunchyroll_rs::{Locale, MediaCollection};
+use serde::Deserialize;
+use std::collections::HashMap;
+use std::fs;
+use std::path::Path;
+
+/// Settings which differ from the command line arguments for a single series.
+#[derive(Clone, Debug, Default, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct SeriesOverride {
+    pub output: Option<String>,
+    pub output_specials: Option<String>,
+    pub audio: Option<Locale>,
+    pub subtitle: Option<Locale>,
+    pub resolution: Option<String>,
+    pub skip_specials: Option<bool>,
+}
+
+/// Per-series overrides, mapped by the id of the series (or movie listing).
+#[derive(Clone, Debug, Default)]
+pub struct SeriesOverrides(HashMap<String, SeriesOverride>);
+
+impl SeriesOverrides {
+    pub fn load(path: &str) -> Result<Self, String> {
+        let raw = fs::read_to_string(Path::new(path)).map_err(|e| e.to_string())?;
+        let overrides: HashMap<String, SeriesOverride> = serde_json::from_str(&raw)
+            .map_err(|e| format!("invalid series override file '{}': {}", path, e))?;
+        for (id, series_override) in &overrides {
+            for (name, output) in [
+                ("output", &series_override.output),
+                ("output_specials", &series_override.output_specials),
+            ] {
+                if let Some(output) = output {
+                    if Path::new(output).extension().unwrap_or_default().is_empty()
+                        && !is_special_file(output)
+                        && output != "-"
+                    {
+                        return Err(format!(
+                            "no file extension found in the {} of series {}",
+                            name, id
+                        ));
+                    }
+                }
+            }
+            if let Some(resolution) = &series_override.resolution {
+                parse_resolution(resolution.clone())
+                    .map_err(|e| format!("invalid resolution of series {}: {}", id, e))?;
+            }
+        }
+        Ok(Self(overrides))
+    }
+
+    /// Apply the override of the series `media_collection` belongs to, if one exists. The checks
+    /// and warnings of the outputs which are also done for the command line arguments are run
+    /// again, as they depend on other arguments (e.g. `--mux-option`).
+    pub fn apply(
+        &self,
+        download: &Download,
+        media_collection: &MediaCollection,
+    ) -> Result<Download> {
+        let mut download = download.clone();
+        let Some(series_override) = series_id(media_collection).and_then(|id| self.0.get(&id))
+        else {
+            return Ok(download);
+        };
+
+        if let Some(output) = &series_override.output {
+            download.output = output.clone()
+        }
+        if let Some(output_specials) = &series_override.output_specials {
+            download.output_specials = Some(output_specials.clone())
+        }
+        if let Some(audio) = &series_override.audio {
+            download.audio = audio.clone()
+        }
+        if let Some(subtitle) = &series_override.subtitle {
+            download.subtitle = Some(subtitle.clone())
+        }
+        if let Some(resolution) = &series_override.resolution {
+            download.resolution = parse_resolution(resolution.clone())?
+        }
+        if let Some(skip_specials) = series_override.skip_specials {
+            download.skip_specials = skip_specials
+        }
+        download
+            .check_outputs()
+            .map_err(|e| anyhow!("Invalid series override: {}", e))?;
+        download.warn_subtitle_containers();
+        download.resolve_output_locales();
+
+        Ok(download)
+    }
+}
+
+fn series_id(media_collection: &MediaCollection) -> Option<String> {
+    match media_collection {
+        MediaCollection::Series(series) => Some(series.id.clone()),
+        MediaCollection::Season(season) => Some(season.series_id.clone()),
+        MediaCollection::Episode(episode) => Some(episode.series_id.clone()),
+        MediaCollection::MovieListing(movie_listing) => Some(movie_listing.id.clone()),
+        MediaCollection::Movie(movie) => Some(movie.movie_listing_id.clone()),
+        _ => None,
+    }
+}
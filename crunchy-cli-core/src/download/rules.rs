@@ -0,0 +1,82 @@
+use crunchyroll_rs::{Locale, Series};
+use regex::Regex;
+
+/// Rule which decides if a series of the watchlist is downloaded.
+#[derive(Clone, Debug)]
+pub enum WatchlistRule {
+    /// Id of the series.
+    Id(String),
+    /// Pattern which matches the slug of the series (the name in the url).
+    Slug(Regex),
+    /// Category (genre) of the series, e.g. `action`. Compared case-insensitive.
+    Category(String),
+    /// The series is available with this audio language.
+    Audio(Locale),
+}
+
+impl WatchlistRule {
+    pub fn parse(s: &str) -> Result<Self, String> {
+        let Some((kind, value)) = s.split_once(':') else {
+            return Err(format!(
+                "'{}' is not a valid rule. Must be in format of <id|slug|category|audio>:<value>",
+                s
+            ));
+        };
+        match kind {
+            "id" => Ok(Self::Id(value.to_string())),
+            "slug" => Ok(Self::Slug(
+                Regex::new(value).map_err(|e| format!("invalid slug pattern: {}", e))?,
+            )),
+            "category" => Ok(Self::Category(value.to_lowercase())),
+            "audio" => Ok(Self::Audio(Locale::from(value.to_string()))),
+            _ => Err(format!(
+                "'{}' is not a valid rule type. Valid types are 'id', 'slug', 'category' and 'audio'",
+                kind
+            )),
+        }
+    }
+
+    pub fn matches(&self, series: &Series) -> bool {
+        match self {
+            WatchlistRule::Id(id) => &series.id == id,
+            WatchlistRule::Slug(pattern) => pattern.is_match(&series.slug_title),
+            WatchlistRule::Category(category) => series
+                .categories
+                .iter()
+                .any(|c| c.to_string().to_lowercase() == *category),
+            WatchlistRule::Audio(locale) => series.audio_locales.contains(locale),
+        }
+    }
+}
+
+/// Check if `series` should be downloaded. If include rules are given, at least one of them must
+/// match. Exclude rules take precedence over include rules.
+pub fn is_included(include: &[WatchlistRule], exclude: &[WatchlistRule], series: &Series) -> bool {
+    (include.is_empty() || include.iter().any(|r| r.matches(series)))
+        && !exclude.iter().any(|r| r.matches(series))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn parse() {
+        assert!(
+            matches!(WatchlistRule::parse("id:GRDV0019R"), Ok(WatchlistRule::Id(id)) if id == "GRDV0019R")
+        );
+        assert!(
+            matches!(WatchlistRule::parse("slug:^one-.*"), Ok(WatchlistRule::Slug(p)) if p.is_match("one-piece"))
+        );
+        assert!(
+            matches!(WatchlistRule::parse("category:Action"), Ok(WatchlistRule::Category(c)) if c == "action")
+        );
+        assert!(matches!(
+            WatchlistRule::parse("audio:ja-JP"),
+            Ok(WatchlistRule::Audio(Locale::ja_JP))
+        ));
+        assert!(WatchlistRule::parse("GRDV0019R").is_err());
+        assert!(WatchlistRule::parse("name:one piece").is_err());
+        assert!(WatchlistRule::parse("slug:(").is_err());
+    }
+}
@@ -1,28 +1,76 @@
+use crate::utils::completion::locale_candidates;
 use crate::utils::context::Context;
+use crate::utils::deprecation::{debug_deprecation_summary, warn_deprecated, Deprecation};
 use crate::utils::locale::system_locale;
-use crate::utils::log::{progress, CliLogger};
+use crate::utils::log::{event, progress, CliLogger, JsonLogFile};
 use anyhow::bail;
 use anyhow::Result;
-use clap::{Parser, Subcommand};
-use crunchyroll_rs::crunchyroll::CrunchyrollBuilder;
+use clap::{CommandFactory, Parser, Subcommand};
+use clap_complete::engine::ArgValueCandidates;
+use clap_complete::CompleteEnv;
+use crunchyroll_rs::crunchyroll::{CrunchyrollBuilder, SessionToken};
 use crunchyroll_rs::error::Error;
 use crunchyroll_rs::{Crunchyroll, Locale};
-use log::{debug, error, warn, LevelFilter};
-use reqwest::{Client, Proxy};
+use log::{debug, error, info, log_enabled, warn, Level, LevelFilter};
+use reqwest::{Client, Proxy, StatusCode};
+use std::net::IpAddr;
+use std::path::PathBuf;
 use std::{env, fs};
 
 mod archive;
+mod availability;
+mod cache;
+mod calendar;
+mod compat;
+mod crunchylist;
+mod diagnose;
 mod download;
 mod login;
+mod playhead;
+mod profile;
+mod rating;
 mod search;
+mod stats;
+mod update;
 mod utils;
-
-use crate::utils::rate_limit::RateLimiterService;
+mod watch_history;
+
+use crate::utils::cache::{set_cache_options, CacheOptions};
+use crate::utils::dns::DnsOptions;
+use crate::utils::endpoint_override::{EndpointOverrideService, EndpointOverrides};
+use crate::utils::experimental::{
+    experimental_features, is_experimental_enabled, set_experimental_features, ExperimentalFeature,
+};
+use crate::utils::fixture::FixtureRecorderService;
+use crate::utils::history::{set_history_file_path, set_monthly_cap};
+use crate::utils::i18n::{set_ui_locale, tr, ui_locales};
+use crate::utils::notify::{enable_notifications, notify};
+use crate::utils::os::{set_filename_normalization, write_private, FilenameNormalization};
+use crate::utils::progress::{set_progress_output, ProgressOutput};
+use crate::utils::rate_limit::{RateLimiterService, RequestLimiter, RequestLimits};
+use crate::utils::report::{
+    report_error, set_error_reporter, CommandErrorReporter, ErrorReport, WebhookErrorReporter,
+};
+use crate::utils::retry::RequestPolicy;
+use crate::utils::signal::set_signal_handler;
+use crate::utils::theme::{set_color, ColorMode, Theme};
+use crate::utils::update::check_update;
 pub use archive::Archive;
-use dialoguer::console::Term;
+pub use availability::Availability;
+pub use cache::Cache;
+pub use calendar::Calendar;
+pub use compat::Compat;
+pub use crunchylist::Crunchylist;
+pub use diagnose::Diagnose;
 pub use download::Download;
 pub use login::Login;
+pub use playhead::Playhead;
+pub use profile::Profile;
+pub use rating::Rating;
 pub use search::Search;
+pub use stats::Stats;
+pub use update::Update;
+pub use watch_history::WatchHistory;
 
 trait Execute {
     fn pre_check(&mut self) -> Result<()> {
@@ -42,7 +90,17 @@ pub struct Cli {
         help = "Overwrite the language in which results are returned. Default is your system language"
     )]
     #[arg(global = true, long)]
+    #[arg(add = ArgValueCandidates::new(locale_candidates))]
     lang: Option<Locale>,
+    #[arg(help = "Language of the messages shown by crunchy-cli. Default is en-US")]
+    #[arg(
+        long_help = "Language of the messages shown by crunchy-cli (e.g. progress labels and errors). \
+            Independent of `--lang`, which sets the language of the results returned by Crunchyroll. \
+            Default is en-US, messages are only translated if this flag is set"
+    )]
+    #[arg(global = true, long)]
+    #[arg(add = ArgValueCandidates::new(locale_candidates))]
+    ui_lang: Option<Locale>,
 
     #[arg(
         help = "Enable experimental fixes which may resolve some unexpected errors. Generally not recommended as this flag may crash the program completely"
@@ -55,6 +113,28 @@ pub struct Cli {
     )]
     #[arg(global = true, long, default_value_t = false)]
     experimental_fixes: bool,
+    #[arg(
+        help = "Enable single experimental features. Valid options are 'stabilize-locales' and 'stabilize-season-number'"
+    )]
+    #[arg(
+        long_help = "Enable single experimental features instead of all at once like `--experimental-fixes` does. \
+            Valid options are 'stabilize-locales' (fix mislabeled audio locales) and 'stabilize-season-number' (fix wrong season numbers). \
+            Multiple features can be separated by a comma. \
+            Features can also be enabled via the comma separated `CRUNCHY_CLI_EXPERIMENTAL` environment variable"
+    )]
+    #[arg(global = true, long, value_delimiter = ',')]
+    #[arg(value_parser = ExperimentalFeature::parse)]
+    experimental: Vec<ExperimentalFeature>,
+
+    #[arg(help = "Normalize characters in output filenames. Valid options are 'none' and 'width'")]
+    #[arg(
+        long_help = "Normalize characters in output filenames. Valid options are 'none' and 'width'. \
+            'width' converts full-width latin characters, digits and punctuation (common in japanese and chinese titles) to their ascii equivalent, so that filenames sort as expected. \
+            Invisible bidi and zero-width characters (common in arabic titles) are always removed"
+    )]
+    #[arg(global = true, long, default_value_t = FilenameNormalization::default())]
+    #[arg(value_parser = FilenameNormalization::parse)]
+    filename_normalization: FilenameNormalization,
 
     #[clap(flatten)]
     login_method: login::LoginMethod,
@@ -66,6 +146,20 @@ pub struct Cli {
     #[arg(global = true, long, value_parser = crate::utils::clap::clap_parse_proxies)]
     proxy: Option<(Option<Proxy>, Option<Proxy>)>,
 
+    #[arg(help = "Resolve hostnames via a DNS-over-HTTPS server")]
+    #[arg(
+        long_help = "Resolve hostnames via a DNS-over-HTTPS server instead of the system resolver. \
+            Useful if your ISP blocks or throttles Crunchyroll by manipulating dns responses. \
+            The server must support the json api (e.g. https://cloudflare-dns.com/dns-query or https://dns.google/resolve)"
+    )]
+    #[arg(global = true, long)]
+    doh: Option<String>,
+    #[arg(
+        help = "Resolve a hostname to a fixed ip address. Must be in format of <host>=<ip>. Can be used multiple times"
+    )]
+    #[arg(global = true, long, value_parser = crate::utils::clap::clap_parse_host_override)]
+    resolve: Vec<(String, IpAddr)>,
+
     #[arg(help = "Use custom user agent")]
     #[arg(global = true, long)]
     user_agent: Option<String>,
@@ -78,6 +172,126 @@ pub struct Cli {
     )]
     #[arg(global = true, long, value_parser = crate::utils::clap::clap_parse_speed_limit)]
     speed_limit: Option<u32>,
+    #[arg(help = "Maximal number of api requests. Must be in format of <number>[/s|/m]")]
+    #[arg(
+        long_help = "Maximal number of api requests. Must be in format of <number>[/s|/m] (e.g. 5/s or 100/m). \
+            Requests which exceed the limit are delayed. Useful for big batch operations (e.g. archiving a complete catalog) which could trip the rate limit of Crunchyroll. \
+            Doesn't apply to requests to the cms endpoints (series, seasons, episodes, ...) if `--cms-request-limit` is set"
+    )]
+    #[arg(global = true, long, value_parser = crate::utils::clap::clap_parse_request_rate)]
+    request_limit: Option<f64>,
+    #[arg(
+        help = "Maximal number of requests to the cms endpoints (series, seasons, episodes, ...). Must be in format of <number>[/s|/m]"
+    )]
+    #[arg(global = true, long, value_parser = crate::utils::clap::clap_parse_request_rate)]
+    cms_request_limit: Option<f64>,
+    #[arg(
+        help = "Maximal time an api request may take before it's aborted. Must be in format of <hours>h<minutes>m<seconds>s"
+    )]
+    #[arg(
+        long_help = "Maximal time an api request may take before it's aborted. Must be in format of <hours>h<minutes>m<seconds>s. \
+            Doesn't apply to the download of video segments, use `--segment-timeout` for them"
+    )]
+    #[arg(global = true, long, value_parser = crate::utils::clap::clap_parse_duration)]
+    api_timeout: Option<chrono::Duration>,
+    #[arg(help = "How often failed api requests are retried")]
+    #[arg(long_help = "How often failed api requests are retried. \
+            Only requests which fail with a server error or without a response (e.g. because of `--api-timeout`) are retried, with an increasing delay between the retries. \
+            Requests which change something (like playhead updates or watchlist changes) are never retried, as they might have been applied anyway. \
+            Doesn't apply to the download of video segments, use `--segment-retries` for them")]
+    #[arg(global = true, long, default_value_t = 0)]
+    api_retries: u32,
+
+    #[arg(help = "Json file or url with endpoint and header overrides for api requests")]
+    #[arg(
+        long_help = "Json file or url with endpoint and header overrides for api requests. \
+            Can be used to apply hotfixes if Crunchyroll changes something, without having to wait for a new release. \
+            The json object may contain an 'endpoints' object, which maps url prefixes to their replacement, and a 'headers' object, which contains headers that are set on every api request"
+    )]
+    #[arg(global = true, long)]
+    endpoint_overrides: Option<String>,
+    #[arg(help = "Header which is set on every api request. Must be in format of <name>:<value>")]
+    #[arg(
+        long_help = "Header which is set on every api request, e.g. for tracing. Must be in format of <name>:<value>. \
+            Can be used multiple times. Takes precedence over headers of `--endpoint-overrides`"
+    )]
+    #[arg(global = true, long = "header", value_parser = crate::utils::clap::clap_parse_header)]
+    headers: Vec<(String, String)>,
+    #[arg(help = "Ask Crunchyroll to not track you")]
+    #[arg(
+        long_help = "Ask Crunchyroll to not track you by sending the 'DNT' (do not track) and 'Sec-GPC' (global privacy control) headers on every api request. \
+            crunchy-cli itself never calls analytics or beacon endpoints, this only signals the preference for the requests it has to send. \
+            Only the headers are added, urls are not changed and non-api requests (e.g. video segments) are sent without them. \
+            Headers of `--header` take precedence"
+    )]
+    #[arg(global = true, long, default_value_t = false)]
+    do_not_track: bool,
+    #[arg(help = "Log every api request as json line into a file")]
+    #[arg(
+        long_help = "Log the method, url, status and duration (in milliseconds) of every api request as json line into a file, e.g. to collect metrics. \
+            Tokens and other account specific values in the url are redacted"
+    )]
+    #[arg(global = true, long)]
+    request_log: Option<PathBuf>,
+
+    #[arg(help = "Record all api requests and responses into a directory")]
+    #[arg(
+        long_help = "Record all api requests and responses as json files into a directory. \
+            Tokens, credentials and other account specific values are redacted, so the files can be attached to bug reports to reproduce api related issues"
+    )]
+    #[arg(global = true, long)]
+    record_fixtures: Option<PathBuf>,
+
+    #[arg(help = "Path of the download history file")]
+    #[arg(long_help = "Path of the download history file. \
+            If multiple instances of crunchy-cli (e.g. on different machines) should share their download history, they must use the same file, e.g. on a network share")]
+    #[arg(global = true, long)]
+    history: Option<PathBuf>,
+    #[arg(
+        help = "Maximal size of all videos downloaded per month. Must be in format of <number>[B|KB|MB|GB]"
+    )]
+    #[arg(
+        long_help = "Maximal size of all videos downloaded per month. Must be in format of <number>[B|KB|MB|GB] (e.g. 100GB). \
+            The downloaded size is calculated from the download history (see `--history`). A warning is shown if 90% of the cap are used, no further videos are downloaded if the (estimated) size of the next video would exceed the cap. \
+            Downloads to stdout (`-`) or special files aren't recorded in the history and therefore don't count towards the cap"
+    )]
+    #[arg(global = true, long, value_parser = crate::utils::clap::clap_parse_file_size)]
+    monthly_cap: Option<u64>,
+
+    #[arg(help = "Directory in which downloaded subtitles and fonts are cached")]
+    #[arg(
+        long_help = "Directory in which downloaded subtitles and fonts are cached. \
+            Defaults to the temp directory. If multiple instances of crunchy-cli (e.g. on different machines) should share their cache, they must use the same directory, e.g. on a network share"
+    )]
+    #[arg(global = true, long)]
+    cache_dir: Option<PathBuf>,
+    #[arg(
+        help = "Time after which cached subtitles are fetched again. Must be in format of <hours>h<minutes>m<seconds>s"
+    )]
+    #[arg(global = true, long, value_parser = crate::utils::clap::clap_parse_duration)]
+    cache_ttl: Option<chrono::Duration>,
+    #[arg(help = "Cache downloaded subtitles only in memory instead of on disk")]
+    #[arg(global = true, long, default_value_t = false)]
+    memory_cache: bool,
+
+    #[arg(help = "Show a desktop notification when a command finished or failed")]
+    #[arg(global = true, long, default_value_t = false)]
+    notify: bool,
+
+    #[arg(help = "Check if a new version of crunchy-cli is available")]
+    #[arg(
+        long_help = "Check if a new version of crunchy-cli is available and show a warning if so. \
+            This sends a request to the GitHub api every time crunchy-cli is started"
+    )]
+    #[arg(global = true, long, default_value_t = false)]
+    check_update: bool,
+
+    #[arg(help = "Report unexpected errors to an url or command")]
+    #[arg(long_help = "Report unexpected errors to an url or command. \
+            If the value is a http(s) url, a json object describing the error is sent via a post request to it, otherwise the value is executed as command and the json object is passed via stdin. \
+            Useful if you run crunchy-cli unattended and want to get notified when something breaks")]
+    #[arg(global = true, long)]
+    error_report: Option<String>,
 
     #[clap(subcommand)]
     command: Command,
@@ -98,16 +312,44 @@ fn version() -> String {
 #[derive(Debug, Subcommand)]
 enum Command {
     Archive(Archive),
+    Availability(Availability),
+    Cache(Cache),
+    Calendar(Calendar),
+    Compat(Compat),
+    Crunchylist(Crunchylist),
+    Diagnose(Diagnose),
     Download(Download),
     Login(Login),
+    Playhead(Playhead),
+    Profile(Profile),
+    Rating(Rating),
     Search(Search),
+    Stats(Stats),
+    Update(Update),
+    WatchHistory(WatchHistory),
+}
+
+impl Command {
+    /// If the command works without an account. These commands log in anonymously if no login
+    /// method is given and no login is stored, so they can be used in scripts without storing
+    /// credentials first.
+    fn works_anonymously(&self) -> bool {
+        matches!(
+            self,
+            Command::Availability(_) | Command::Calendar(_) | Command::Search(_)
+        )
+    }
 }
 
 #[derive(Debug, Parser)]
 struct Verbosity {
-    #[arg(help = "Verbose output")]
-    #[arg(global = true, short, long)]
-    verbose: bool,
+    #[arg(help = "Verbose output. Use twice (`-vv`) to also show raw http traces")]
+    #[arg(
+        long_help = "Verbose output. Use twice (`-vv`) to also show the raw http requests and responses of api calls. \
+            Tokens, credentials and other account specific values are redacted from the traces, so they can be attached to bug reports"
+    )]
+    #[arg(global = true, short, long, action = clap::ArgAction::Count)]
+    verbose: u8,
 
     #[arg(help = "Quiet output. Does not print anything unless it's a error")]
     #[arg(
@@ -115,26 +357,194 @@ struct Verbosity {
     )]
     #[arg(global = true, short, long)]
     quiet: bool,
+
+    #[arg(
+        help = "How the progress of downloads is shown. Valid options are 'bar', 'compact', 'json' and 'off'"
+    )]
+    #[arg(
+        long_help = "How the progress of downloads is shown. Valid options are 'bar', 'compact', 'json' and 'off'. \
+            'compact' prints a single line every 10 percent instead of redrawing a progress bar, which is suitable for logs (e.g. of ci jobs). \
+            'json' prints a json object to stderr at most every second, which contains the downloaded and estimated total size, the downloaded segments, the speed (per second) and the estimated remaining time (in seconds). It's meant to be parsed by GUIs and scripts which render their own progress. \
+            Defaults to 'bar' if the output is a terminal and 'compact' otherwise"
+    )]
+    #[arg(global = true, long, value_parser = crate::utils::progress::ProgressOutput::parse)]
+    progress: Option<ProgressOutput>,
+
+    #[arg(help = "When to use colors. Valid options are 'auto', 'always' and 'never'")]
+    #[arg(
+        long_help = "When to use colors. Valid options are 'auto', 'always' and 'never'. \
+            'auto' uses colors if the output is a terminal and the `NO_COLOR` environment variable is not set"
+    )]
+    #[arg(global = true, long, default_value_t = ColorMode::default())]
+    #[arg(value_parser = ColorMode::parse)]
+    color: ColorMode,
+    #[arg(help = "Color theme. Valid options are 'dark' and 'light'")]
+    #[arg(long_help = "Color theme. Valid options are 'dark' and 'light'. \
+            'light' uses darker colors which are better readable on terminals with a light background")]
+    #[arg(global = true, long, default_value_t = Theme::default())]
+    #[arg(value_parser = Theme::parse)]
+    theme: Theme,
+
+    #[arg(help = "Additionally write the log output as json lines to the given file")]
+    #[arg(
+        long_help = "Additionally write the log output as json lines to the given file. \
+            Every line is a json object with a timestamp, the log level and the message. \
+            The file gets rotated if it exceeds the size given via `--log-file-max-size`"
+    )]
+    #[arg(global = true, long)]
+    log_file: Option<PathBuf>,
+    #[arg(
+        help = "Maximal size of the log file before it gets rotated. Must be in format of <number>[B|KB|MB|GB]"
+    )]
+    #[arg(global = true, long, default_value = "10MB")]
+    #[arg(value_parser = crate::utils::clap::clap_parse_file_size)]
+    log_file_max_size: u64,
+    #[arg(help = "Number of rotated log files to keep")]
+    #[arg(global = true, long, default_value_t = 5)]
+    log_file_count: usize,
 }
 
 pub async fn main(args: &[String]) {
+    // if the `COMPLETE` environment variable is set, the shell requests completions instead of
+    // running a command (e.g. via `source <(COMPLETE=bash crunchy-cli)`)
+    match CompleteEnv::with_factory(Cli::command)
+        .try_complete(args, env::current_dir().ok().as_deref())
+    {
+        Ok(true) => std::process::exit(0),
+        Ok(false) => (),
+        Err(e) => {
+            eprintln!("Failed to generate completions: {}", e);
+            std::process::exit(1)
+        }
+    }
+
     let mut cli: Cli = Cli::parse_from(args);
 
-    if cli.verbosity.verbose || cli.verbosity.quiet {
-        if cli.verbosity.verbose && cli.verbosity.quiet {
+    let log_file = if let Some(log_file_path) = &cli.verbosity.log_file {
+        match JsonLogFile::new(
+            log_file_path.clone(),
+            cli.verbosity.log_file_max_size,
+            cli.verbosity.log_file_count,
+        ) {
+            Ok(log_file) => Some(log_file),
+            Err(e) => {
+                eprintln!(
+                    "Failed to open log file {}: {}",
+                    log_file_path.to_string_lossy(),
+                    e
+                );
+                std::process::exit(1)
+            }
+        }
+    } else {
+        None
+    };
+
+    set_color(cli.verbosity.color, cli.verbosity.theme);
+
+    // the exported session is written to stdout, which is also where all non-error log output goes
+    if matches!(&cli.command, Command::Login(login) if login.export.as_deref() == Some("-")) {
+        if cli.verbosity.verbose > 0 {
+            eprintln!("Output cannot be verbose ('-v') if the login is exported to stdout");
+            std::process::exit(1)
+        }
+        cli.verbosity.quiet = true
+    }
+
+    if cli.verbosity.verbose > 0 || cli.verbosity.quiet {
+        if cli.verbosity.verbose > 0 && cli.verbosity.quiet {
             eprintln!("Output cannot be verbose ('-v') and quiet ('-q') at the same time");
             std::process::exit(1)
-        } else if cli.verbosity.verbose {
-            CliLogger::init(LevelFilter::Debug).unwrap()
+        } else if cli.verbosity.verbose > 1 {
+            CliLogger::init(LevelFilter::Trace, log_file).unwrap()
+        } else if cli.verbosity.verbose > 0 {
+            CliLogger::init(LevelFilter::Debug, log_file).unwrap()
         } else if cli.verbosity.quiet {
-            CliLogger::init(LevelFilter::Error).unwrap()
+            CliLogger::init(LevelFilter::Error, log_file).unwrap()
         }
     } else {
-        CliLogger::init(LevelFilter::Info).unwrap()
+        CliLogger::init(LevelFilter::Info, log_file).unwrap()
     }
 
     debug!("cli input: {:?}", cli);
 
+    if let Some(error_report) = &cli.error_report {
+        if error_report.starts_with("http://") || error_report.starts_with("https://") {
+            set_error_reporter(Box::new(WebhookErrorReporter::new(
+                error_report.clone(),
+                reqwest_client(
+                    cli.proxy.as_ref().and_then(|p| p.0.clone()),
+                    cli.user_agent.clone(),
+                    &DnsOptions::new(cli.doh.clone(), cli.resolve.clone()),
+                ),
+            )))
+        } else {
+            match CommandErrorReporter::new(error_report) {
+                Ok(reporter) => set_error_reporter(Box::new(reporter)),
+                Err(e) => {
+                    error!("{}", e);
+                    std::process::exit(1)
+                }
+            }
+        }
+    }
+
+    set_filename_normalization(cli.filename_normalization);
+    if let Some(progress) = cli.verbosity.progress {
+        set_progress_output(progress)
+    }
+    if cli.notify {
+        enable_notifications()
+    }
+    if let Some(history) = &cli.history {
+        set_history_file_path(history.clone())
+    }
+    if let Some(monthly_cap) = cli.monthly_cap {
+        set_monthly_cap(monthly_cap)
+    }
+    set_cache_options(CacheOptions {
+        dir: cli.cache_dir.clone(),
+        ttl: cli.cache_ttl.and_then(|ttl| ttl.to_std().ok()),
+        memory: cli.memory_cache,
+    });
+    if let Some(ui_lang) = &cli.ui_lang {
+        if !ui_locales().contains(ui_lang) {
+            error!(
+                "Via `--ui-lang` specified language is not supported. Supported languages: {}",
+                ui_locales()
+                    .iter()
+                    .map(|l| format!("`{}` ({})", l, l.to_human_readable()))
+                    .collect::<Vec<String>>()
+                    .join(", ")
+            );
+            std::process::exit(1)
+        }
+        set_ui_locale(ui_lang.clone())
+    }
+    set_experimental_features(if cli.experimental_fixes {
+        ExperimentalFeature::all()
+    } else {
+        cli.experimental.clone()
+    });
+    if !experimental_features().is_empty() {
+        debug!(
+            "Enabled experimental features: {}",
+            experimental_features()
+                .iter()
+                .map(|f| f.to_string())
+                .collect::<Vec<String>>()
+                .join(", ")
+        )
+    }
+    if cli.check_update && !matches!(cli.command, Command::Update(_)) {
+        check_update(&reqwest_client(
+            cli.proxy.as_ref().and_then(|p| p.1.clone()),
+            cli.user_agent.clone(),
+            &DnsOptions::new(cli.doh.clone(), cli.resolve.clone()),
+        ))
+        .await
+    }
+
     match &mut cli.command {
         Command::Archive(archive) => {
             // prevent interactive select to be shown when output should be quiet
@@ -156,11 +566,79 @@ pub async fn main(args: &[String]) {
                     let _ = fs::remove_file(session_file);
                 }
                 return;
+            } else if let Some(import) = &login.import {
+                // the imported session is used to login, which then gets saved like every other
+                // login
+                match login::ExportedSession::read(import) {
+                    Ok(session) => cli.login_method.refresh_token = Some(session.refresh_token),
+                    Err(e) => {
+                        error!("Failed to read exported session: {}", e);
+                        std::process::exit(1)
+                    }
+                }
+                pre_check_executor(login).await
             } else {
                 pre_check_executor(login).await
             }
         }
+        Command::Compat(compat) => pre_check_executor(compat).await,
+        Command::Diagnose(_) => {
+            // the login is part of the diagnosis, so it must not abort the command if it fails
+            let client = reqwest_client(
+                cli.proxy.as_ref().and_then(|p| p.0.clone()),
+                cli.user_agent.clone(),
+                &DnsOptions::new(cli.doh.clone(), cli.resolve.clone()),
+            );
+            let ctx = create_ctx(&mut cli).await;
+            let Command::Diagnose(diagnose) = cli.command else {
+                unreachable!()
+            };
+            if let Err(e) = diagnose.run(client, ctx).await {
+                error!("{}", e);
+                std::process::exit(1)
+            }
+            return;
+        }
+        Command::Availability(availability) => pre_check_executor(availability).await,
+        Command::Calendar(calendar) => pre_check_executor(calendar).await,
+        Command::Crunchylist(crunchylist) => pre_check_executor(crunchylist).await,
+        Command::Playhead(playhead) => pre_check_executor(playhead).await,
+        Command::Profile(profile) => pre_check_executor(profile).await,
+        Command::Rating(rating) => pre_check_executor(rating).await,
         Command::Search(search) => pre_check_executor(search).await,
+        Command::WatchHistory(watch_history) => pre_check_executor(watch_history).await,
+        Command::Cache(cache) => {
+            // the cache is stored locally, so no session is required
+            if let Err(e) = cache.run() {
+                error!("{}", e);
+                std::process::exit(1)
+            }
+            return;
+        }
+        Command::Stats(stats) => {
+            // stats are created from the local download history, so no session is required
+            if let Err(e) = stats.run() {
+                error!("{}", e);
+                std::process::exit(1)
+            }
+            return;
+        }
+        Command::Update(_) => {
+            // releases are fetched from github, so no session is required
+            let client = reqwest_client(
+                cli.proxy.as_ref().and_then(|p| p.1.clone()),
+                cli.user_agent.clone(),
+                &DnsOptions::new(cli.doh.clone(), cli.resolve.clone()),
+            );
+            let Command::Update(update) = cli.command else {
+                unreachable!()
+            };
+            if let Err(e) = update.run(client).await {
+                error!("{}", e);
+                std::process::exit(1)
+            }
+            return;
+        }
     };
 
     let ctx = match create_ctx(&mut cli).await {
@@ -172,58 +650,28 @@ pub async fn main(args: &[String]) {
     };
     debug!("Created context");
 
-    ctrlc::set_handler(move || {
-        debug!("Ctrl-c detected");
-        if let Ok(dir) = fs::read_dir(env::temp_dir()) {
-            for file in dir.flatten() {
-                if file
-                    .path()
-                    .file_name()
-                    .unwrap_or_default()
-                    .to_str()
-                    .unwrap_or_default()
-                    .starts_with(".crunchy-cli_")
-                {
-                    if file.file_type().map_or(true, |ft| ft.is_file()) {
-                        let result = fs::remove_file(file.path());
-                        debug!(
-                            "Ctrl-c removed temporary file {} {}",
-                            file.path().to_string_lossy(),
-                            if result.is_ok() {
-                                "successfully"
-                            } else {
-                                "not successfully"
-                            }
-                        )
-                    } else {
-                        let result = fs::remove_dir_all(file.path());
-                        debug!(
-                            "Ctrl-c removed temporary directory {} {}",
-                            file.path().to_string_lossy(),
-                            if result.is_ok() {
-                                "successfully"
-                            } else {
-                                "not successfully"
-                            }
-                        )
-                    }
-                }
-            }
-        }
-        // when pressing ctrl-c while interactively choosing seasons the cursor stays hidden, this
-        // line shows it again
-        let _ = Term::stdout().show_cursor();
-        std::process::exit(1)
-    })
-    .unwrap();
+    set_signal_handler();
     debug!("Created ctrl-c handler");
 
     match cli.command {
         Command::Archive(archive) => execute_executor(archive, ctx).await,
         Command::Download(download) => execute_executor(download, ctx).await,
         Command::Login(login) => execute_executor(login, ctx).await,
+        Command::Compat(compat) => execute_executor(compat, ctx).await,
+        Command::Availability(availability) => execute_executor(availability, ctx).await,
+        Command::Calendar(calendar) => execute_executor(calendar, ctx).await,
+        Command::Crunchylist(crunchylist) => execute_executor(crunchylist, ctx).await,
+        Command::Playhead(playhead) => execute_executor(playhead, ctx).await,
+        Command::Profile(profile) => execute_executor(profile, ctx).await,
+        Command::Rating(rating) => execute_executor(rating, ctx).await,
         Command::Search(search) => execute_executor(search, ctx).await,
+        Command::WatchHistory(watch_history) => execute_executor(watch_history, ctx).await,
+        Command::Cache(_) | Command::Diagnose(_) | Command::Stats(_) | Command::Update(_) => {
+            unreachable!()
+        }
     };
+
+    debug_deprecation_summary()
 }
 
 async fn pre_check_executor(executor: &mut impl Execute) {
@@ -235,14 +683,25 @@ async fn pre_check_executor(executor: &mut impl Execute) {
 
 async fn execute_executor(executor: impl Execute, ctx: Context) {
     if let Err(mut err) = executor.execute(ctx).await {
+        report_error(ErrorReport::from_error(&err));
+        notify(tr!("An error occurred: {0}", err));
+
         if let Some(crunchy_error) = err.downcast_mut::<Error>() {
             if let Error::Block { message, .. } = crunchy_error {
                 *message = "Triggered Cloudflare bot protection. Try again later or use a VPN or proxy to spoof your location".to_string()
+            } else if let Error::Request {
+                status: Some(StatusCode::SERVICE_UNAVAILABLE),
+                message,
+                ..
+            } = crunchy_error
+            {
+                *message = "Crunchyroll is temporarily unavailable (maintenance). Try again later"
+                    .to_string()
             }
 
-            error!("An error occurred: {}", crunchy_error)
+            error!("{}", tr!("An error occurred: {0}", crunchy_error))
         } else {
-            error!("An error occurred: {}", err)
+            error!("{}", tr!("An error occurred: {0}", err))
         }
 
         std::process::exit(1)
@@ -250,13 +709,16 @@ async fn execute_executor(executor: impl Execute, ctx: Context) {
 }
 
 async fn create_ctx(cli: &mut Cli) -> Result<Context> {
+    let dns_options = DnsOptions::new(cli.doh.clone(), cli.resolve.clone());
     let crunchy_client = reqwest_client(
         cli.proxy.as_ref().and_then(|p| p.0.clone()),
         cli.user_agent.clone(),
+        &dns_options,
     );
     let internal_client = reqwest_client(
         cli.proxy.as_ref().and_then(|p| p.1.clone()),
         cli.user_agent.clone(),
+        &dns_options,
     );
 
     let crunchy = crunchyroll_session(
@@ -317,28 +779,127 @@ async fn crunchyroll_session(
     let mut builder = Crunchyroll::builder()
         .locale(locale)
         .client(client.clone())
-        .stabilization_locales(cli.experimental_fixes)
-        .stabilization_season_number(cli.experimental_fixes);
+        .stabilization_locales(is_experimental_enabled(
+            ExperimentalFeature::StabilizeLocales,
+        ))
+        .stabilization_season_number(is_experimental_enabled(
+            ExperimentalFeature::StabilizeSeasonNumber,
+        ));
     if let Command::Download(download) = &cli.command {
         builder = builder.preferred_audio_locale(download.audio.clone())
     }
-    if let Some(rate_limiter) = rate_limiter {
+
+    let mut endpoint_overrides = EndpointOverrides::embedded()?;
+    if let Some(source) = &cli.endpoint_overrides {
+        endpoint_overrides.merge(EndpointOverrides::load(source, &client).await?)
+    }
+    if cli.do_not_track {
+        endpoint_overrides.headers.extend([
+            ("DNT".to_string(), "1".to_string()),
+            ("Sec-GPC".to_string(), "1".to_string()),
+        ])
+    }
+    endpoint_overrides.headers.extend(cli.headers.clone());
+    let request_log = match &cli.request_log {
+        Some(path) => Some(
+            fs::OpenOptions::new()
+                .create(true)
+                .append(true)
+                .open(path)?,
+        ),
+        None => None,
+    };
+    let other_limiter = cli.request_limit.map(RequestLimiter::new);
+    let request_limits = RequestLimits {
+        // without a separate cms limit, cms and other requests share one budget
+        cms: match cli.cms_request_limit {
+            Some(limit) => Some(RequestLimiter::new(limit)),
+            None => other_limiter.clone(),
+        },
+        other: other_limiter,
+    };
+    let request_policy = RequestPolicy {
+        timeout: cli.api_timeout.and_then(|timeout| timeout.to_std().ok()),
+        retries: cli.api_retries,
+    };
+    if !endpoint_overrides.is_empty() {
+        debug!(
+            "Using {} endpoint and {} header overrides",
+            endpoint_overrides.endpoints.len(),
+            endpoint_overrides.headers.len()
+        );
+    }
+    if let Some(dir) = &cli.record_fixtures {
+        fs::create_dir_all(dir)?;
+        info!(
+            "Recording api requests to {}. Please check the files for personal data before sharing them",
+            dir.to_string_lossy()
+        );
+        builder = builder.middleware(FixtureRecorderService::new(
+            dir.clone(),
+            EndpointOverrideService::new(endpoint_overrides, client.clone(), rate_limiter)
+                .request_log(request_log)
+                .request_limits(request_limits)
+                .request_policy(request_policy),
+        ))
+    } else if !endpoint_overrides.is_empty()
+        || request_log.is_some()
+        || !request_limits.is_empty()
+        || !request_policy.is_default()
+        || log_enabled!(Level::Debug)
+    {
+        // the endpoint override service also traces, logs, limits and retries all requests
+        builder = builder.middleware(
+            EndpointOverrideService::new(endpoint_overrides, client.clone(), rate_limiter)
+                .request_log(request_log)
+                .request_limits(request_limits)
+                .request_policy(request_policy),
+        )
+    } else if let Some(rate_limiter) = rate_limiter {
         builder = builder.middleware(rate_limiter)
     }
 
-    let root_login_methods_count =
-        cli.login_method.credentials.is_some() as u8 + cli.login_method.anonymous as u8;
-
-    let progress_handler = progress!("Logging in");
+    let root_login_methods_count = cli.login_method.credentials.is_some() as u8
+        + cli.login_method.refresh_token.is_some() as u8
+        + cli.login_method.device_code as u8
+        + cli.login_method.anonymous as u8;
+
+    let progress_handler = progress!("{}", tr!("Logging in"));
+    if root_login_methods_count == 0
+        && cli.login_method.prefer_anonymous
+        && cli.command.works_anonymously()
+    {
+        debug!("Command only needs public data, logging in anonymously");
+        let crunchy = builder.login_anonymously().await?;
+        progress_handler.stop(tr!("Logged in"));
+        return Ok(crunchy);
+    }
     if root_login_methods_count == 0 {
         if let Some(login_file_path) = login::session_file_path() {
             if login_file_path.exists() {
-                let session = fs::read_to_string(login_file_path)?;
+                let session = fs::read_to_string(&login_file_path)?;
                 if let Some((token_type, token)) = session.split_once(':') {
                     match token_type {
                         "refresh_token" => {
                             return match builder.login_with_refresh_token(token).await {
-                                Ok(crunchy) => Ok(crunchy),
+                                Ok(crunchy) => {
+                                    // the refresh token may be rotated on login. storing the
+                                    // current one prevents that the stored login expires
+                                    if let SessionToken::RefreshToken(refresh_token) =
+                                        crunchy.session_token().await
+                                    {
+                                        if refresh_token != token {
+                                            event!("token_refresh", "Stored login was refreshed");
+                                            if let Err(e) = write_private(
+                                                &login_file_path,
+                                                format!("refresh_token:{}", refresh_token),
+                                            ) {
+                                                debug!("Failed to update stored login: {}", e)
+                                            }
+                                        }
+                                    }
+                                    Ok(crunchy)
+                                }
                                 Err(e) => {
                                     if let Error::Request { message, .. } = &e {
                                         if message.starts_with("invalid_grant") {
@@ -347,18 +908,27 @@ async fn crunchyroll_session(
                                     }
                                     Err(e.into())
                                 }
-                            }
+                            };
+                        }
+                        "etp_rt" => {
+                            warn_deprecated(Deprecation::EtpRtSession);
+                            bail!("The stored login cannot be used anymore")
                         }
-                        "etp_rt" => bail!("The stored login method (etp-rt) isn't supported anymore. Please login again using your credentials"),
                         _ => (),
                     }
                 }
                 bail!("Could not read stored session ('{}')", session)
             }
         }
-        bail!("Please use a login method ('--credentials' or '--anonymous')")
+        if cli.command.works_anonymously() {
+            debug!("No login method given, logging in anonymously");
+            let crunchy = builder.login_anonymously().await?;
+            progress_handler.stop(tr!("Logged in"));
+            return Ok(crunchy);
+        }
+        bail!("Please use a login method ('--credentials', '--refresh-token', '--device-code' or '--anonymous')")
     } else if root_login_methods_count > 1 {
-        bail!("Please use only one login method ('--credentials' or '--anonymous')")
+        bail!("Please use only one login method ('--credentials', '--refresh-token', '--device-code' or '--anonymous')")
     }
 
     let crunchy = if let Some(credentials) = &cli.login_method.credentials {
@@ -367,19 +937,50 @@ async fn crunchyroll_session(
         } else {
             bail!("Invalid credentials format. Please provide your credentials as email:password")
         }
+    } else if let Some(refresh_token) = &cli.login_method.refresh_token {
+        match builder.login_with_refresh_token(refresh_token).await {
+            Ok(crunchy) => crunchy,
+            Err(Error::Request { message, .. }) if message.starts_with("invalid_grant") => {
+                bail!("The refresh token is invalid or expired")
+            }
+            Err(e) => return Err(e.into()),
+        }
+    } else if cli.login_method.device_code {
+        let device_code = Crunchyroll::request_device_code(&client).await?;
+        info!(
+            "To login, open https://www.crunchyroll.com/activate?code={} in your browser or enter the code {} there. The code expires in {} minutes",
+            device_code.user_code,
+            device_code.user_code,
+            device_code.expires_in / 60
+        );
+        // the api has no dedicated error for expired codes, so the expiry time is checked instead
+        let expires_at = std::time::Instant::now()
+            + std::time::Duration::from_secs(device_code.expires_in as u64);
+        // waits until the code is confirmed in the browser
+        match builder.login_with_device_code(device_code).await {
+            Ok(crunchy) => crunchy,
+            Err(Error::Request { .. }) if std::time::Instant::now() >= expires_at => {
+                bail!("The code expired before it was confirmed, please try again")
+            }
+            Err(e) => return Err(e.into()),
+        }
     } else if cli.login_method.anonymous {
         builder.login_anonymously().await?
     } else {
         bail!("should never happen")
     };
 
-    progress_handler.stop("Logged in");
+    progress_handler.stop(tr!("Logged in"));
 
     Ok(crunchy)
 }
 
-fn reqwest_client(proxy: Option<Proxy>, user_agent: Option<String>) -> Client {
-    let mut builder = CrunchyrollBuilder::predefined_client_builder();
+fn reqwest_client(
+    proxy: Option<Proxy>,
+    user_agent: Option<String>,
+    dns_options: &DnsOptions,
+) -> Client {
+    let mut builder = dns_options.apply(CrunchyrollBuilder::predefined_client_builder());
     if let Some(p) = proxy {
         builder = builder.proxy(p)
     }
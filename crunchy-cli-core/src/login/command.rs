@@ -1,11 +1,15 @@
 use crate::utils::context::Context;
+use crate::utils::os::write_private;
 use crate::Execute;
 use anyhow::bail;
 use anyhow::Result;
+use chrono::Utc;
 use clap::Parser;
 use crunchyroll_rs::crunchyroll::SessionToken;
 use log::info;
+use serde::{Deserialize, Serialize};
 use std::fs;
+use std::io::Read;
 use std::path::PathBuf;
 
 #[derive(Debug, clap::Parser)]
@@ -14,16 +18,71 @@ pub struct Login {
     #[arg(help = "Remove your stored credentials (instead of saving them)")]
     #[arg(long)]
     pub remove: bool,
+
+    #[arg(help = "Export the session as json to a file (`-` for stdout) instead of saving it")]
+    #[arg(
+        long_help = "Export the session as json to a file (`-` for stdout) instead of saving it. \
+    The exported session can be restored with `--import`, e.g. on another device or in a container. \
+    It contains a refresh token which grants access to your account, so keep it secret (the file is only readable by you). \
+    If exported to stdout, all other output except errors is suppressed"
+    )]
+    #[arg(long, conflicts_with_all = ["remove", "import"])]
+    pub export: Option<String>,
+    #[arg(
+        help = "Restore a session exported with `--export` from a file (`-` for stdin) and save it"
+    )]
+    #[arg(long, conflicts_with = "remove")]
+    pub import: Option<String>,
+}
+
+#[derive(Debug, Deserialize, Serialize)]
+pub struct ExportedSession {
+    pub refresh_token: String,
+    pub account_id: String,
+    /// Unix timestamp when the session was exported.
+    pub exported_at: i64,
+}
+
+impl ExportedSession {
+    pub fn read(path: &str) -> Result<Self> {
+        let content = if path == "-" {
+            let mut content = String::new();
+            std::io::stdin().read_to_string(&mut content)?;
+            content
+        } else {
+            fs::read_to_string(path)?
+        };
+        Ok(serde_json::from_str(&content)?)
+    }
 }
 
 impl Execute for Login {
     async fn execute(self, ctx: Context) -> Result<()> {
+        if let Some(export) = &self.export {
+            let SessionToken::RefreshToken(refresh_token) = ctx.crunchy.session_token().await
+            else {
+                bail!("Only logins with credentials or a refresh token can be exported")
+            };
+            let session = serde_json::to_string_pretty(&ExportedSession {
+                refresh_token,
+                account_id: ctx.crunchy.account().await?.account_id,
+                exported_at: Utc::now().timestamp(),
+            })?;
+            if export == "-" {
+                println!("{}", session)
+            } else {
+                write_private(export, session)?;
+                info!("Exported login to {}", export)
+            }
+            return Ok(());
+        }
+
         if let Some(login_file_path) = session_file_path() {
             fs::create_dir_all(login_file_path.parent().unwrap())?;
 
             match ctx.crunchy.session_token().await {
                 SessionToken::RefreshToken(refresh_token) => {
-                    fs::write(login_file_path, format!("refresh_token:{}", refresh_token))?
+                    write_private(login_file_path, format!("refresh_token:{}", refresh_token))?
                 }
                 SessionToken::EtpRt(_) => bail!("Login with etp_rt isn't supported anymore. Please use your credentials to login"),
                 SessionToken::Anonymous => bail!("Anonymous login cannot be saved"),
@@ -45,9 +104,34 @@ pub struct LoginMethod {
     )]
     #[arg(global = true, long)]
     pub credentials: Option<String>,
+    #[arg(help = "Login with a refresh token")]
+    #[arg(
+        long_help = "Login with a refresh token, e.g. one which was stored by `crunchy-cli login` on another device. \
+    Refresh tokens from the browser cookie `etp_rt` are not supported"
+    )]
+    #[arg(global = true, long)]
+    pub refresh_token: Option<String>,
+    #[arg(help = "Login by confirming a code in the browser")]
+    #[arg(
+        long_help = "Login by confirming a code in the browser, where you're already logged in to Crunchyroll. \
+    This way no password has to be typed into the terminal, and accounts which are logged in via single sign-on can be used"
+    )]
+    #[arg(global = true, long, default_value_t = false)]
+    pub device_code: bool,
     #[arg(help = "Login anonymously / without an account")]
     #[arg(global = true, long, default_value_t = false)]
     pub anonymous: bool,
+    #[arg(
+        help = "Login anonymously for commands which only need public data, even if a login is stored"
+    )]
+    #[arg(
+        long_help = "Login anonymously for commands which only need public data (`search`, `calendar` and `availability`), even if a login is stored. \
+    This keeps the requests of metadata tools and scripts away from your account, e.g. so that they can't trip rate limits which would also affect your downloads. \
+    Account specific output, like the `account` keywords of `search` or premium only streams, isn't available then. \
+    Has no effect if another login method is given"
+    )]
+    #[arg(global = true, long, default_value_t = false)]
+    pub prefer_anonymous: bool,
 }
 
 pub fn session_file_path() -> Option<PathBuf> {
@@ -0,0 +1,87 @@
+use crate::utils::context::Context;
+use crate::utils::parse::parse_url;
+use crate::Execute;
+use anyhow::{bail, Result};
+use crunchyroll_rs::crunchyroll::SessionToken;
+use crunchyroll_rs::MediaCollection;
+use log::info;
+
+#[derive(Debug, clap::Parser)]
+#[clap(about = "Show or set the watch progress (playhead) of an episode or movie")]
+pub struct Playhead {
+    #[arg(help = "Set the playhead to the given position, in seconds or as `<minutes>:<seconds>`")]
+    #[arg(
+        long_help = "Set the playhead to the given position, in seconds or as `<minutes>:<seconds>`. \
+    This updates the 'continue watching' row of the official Crunchyroll apps, e.g. when watching with an external player"
+    )]
+    #[arg(long, value_parser = parse_position)]
+    set: Option<u32>,
+
+    #[arg(help = "Url of the episode or movie")]
+    url: String,
+}
+
+impl Execute for Playhead {
+    async fn execute(self, ctx: Context) -> Result<()> {
+        if matches!(ctx.crunchy.session_token().await, SessionToken::Anonymous) {
+            bail!("The playhead cannot be used when logging in anonymously")
+        }
+
+        let (media_collection, _) = parse_url(&ctx.crunchy, self.url.clone(), false).await?;
+        let (title, duration, playhead) = match &media_collection {
+            MediaCollection::Episode(episode) => {
+                if let Some(position) = self.set {
+                    episode.set_playhead(position).await?
+                }
+                (
+                    format!(
+                        "{} S{:02}E{:0>2} - {}",
+                        episode.series_title, episode.season_number, episode.episode, episode.title
+                    ),
+                    episode.duration,
+                    episode.playhead().await?,
+                )
+            }
+            MediaCollection::Movie(movie) => {
+                if let Some(position) = self.set {
+                    movie.set_playhead(position).await?
+                }
+                (movie.title.clone(), movie.duration, movie.playhead().await?)
+            }
+            _ => bail!("The playhead is only available for episodes and movies"),
+        };
+
+        let Some(playhead) = playhead else {
+            info!("{} wasn't watched yet", title);
+            return Ok(());
+        };
+        if playhead.fully_watched {
+            info!("{} was fully watched", title)
+        } else {
+            info!(
+                "{} was watched until {} of {}",
+                title,
+                format_position(playhead.playhead),
+                format_position(duration.num_seconds() as u32)
+            )
+        }
+
+        Ok(())
+    }
+}
+
+fn parse_position(s: &str) -> Result<u32, String> {
+    let position = match s.split_once(':') {
+        Some((minutes, seconds)) => minutes
+            .parse::<u32>()
+            .ok()
+            .zip(seconds.parse::<u32>().ok().filter(|s| *s < 60))
+            .map(|(minutes, seconds)| minutes * 60 + seconds),
+        None => s.parse().ok(),
+    };
+    position.ok_or(format!("invalid position '{}'", s))
+}
+
+fn format_position(seconds: u32) -> String {
+    format!("{:02}:{:02}", seconds / 60, seconds % 60)
+}
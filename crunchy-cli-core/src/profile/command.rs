@@ -0,0 +1,86 @@
+use crate::utils::completion::locale_candidates;
+use crate::utils::context::Context;
+use crate::Execute;
+use anyhow::{bail, Result};
+use clap_complete::engine::ArgValueCandidates;
+use crunchyroll_rs::account::UpdatePreferences;
+use crunchyroll_rs::crunchyroll::SessionToken;
+use crunchyroll_rs::Locale;
+use log::info;
+use std::env;
+
+#[derive(Debug, clap::Parser)]
+#[clap(about = "Show or change the profile and preferences of your account")]
+pub struct Profile {
+    #[arg(help = "Set the preferred audio language")]
+    #[arg(long)]
+    #[arg(add = ArgValueCandidates::new(locale_candidates))]
+    audio: Option<Locale>,
+    #[arg(help = "Set the preferred subtitle language")]
+    #[arg(long)]
+    #[arg(add = ArgValueCandidates::new(locale_candidates))]
+    subtitle: Option<Locale>,
+    #[arg(help = "Set the profile name")]
+    #[arg(long)]
+    profile_name: Option<String>,
+    #[arg(help = "Set the email address. Requires the current password")]
+    #[arg(
+        long_help = "Set the email address. Crunchyroll requires the current password to change it, \
+    which must be set via the `CRUNCHY_CLI_PASSWORD` environment variable (so it doesn't show up in the shell history)"
+    )]
+    #[arg(long)]
+    email: Option<String>,
+}
+
+impl Execute for Profile {
+    async fn execute(self, ctx: Context) -> Result<()> {
+        if matches!(ctx.crunchy.session_token().await, SessionToken::Anonymous) {
+            bail!("The profile cannot be used when logging in anonymously")
+        }
+
+        let mut account = ctx.crunchy.account().await?;
+
+        if self.audio.is_some() || self.subtitle.is_some() {
+            account
+                .update_preferences(UpdatePreferences {
+                    preferred_content_audio_language: self.audio.clone(),
+                    preferred_content_subtitle_language: self.subtitle.clone(),
+                    ..Default::default()
+                })
+                .await?;
+            info!("Updated preferences")
+        }
+        if let Some(profile_name) = &self.profile_name {
+            account.change_profile_name(profile_name.clone()).await?;
+            info!("Changed profile name to {}", profile_name)
+        }
+        if let Some(email) = &self.email {
+            let Ok(password) = env::var("CRUNCHY_CLI_PASSWORD") else {
+                bail!("Changing the email requires the current password in the `CRUNCHY_CLI_PASSWORD` environment variable")
+            };
+            account.change_email(password, email.clone()).await?;
+            info!("Changed email to {}", email)
+        }
+
+        // the account may be changed, so fetch it again to show the current state
+        let account = ctx.crunchy.account().await?;
+        let format_locale =
+            |locale: &Locale| format!("{} ({})", locale, locale.to_human_readable());
+        println!("{:<20} {}", "Profile name", account.profile_name);
+        println!("{:<20} {}", "Username", account.username);
+        println!("{:<20} {}", "Email", account.email);
+        println!(
+            "{:<20} {}",
+            "Audio language",
+            format_locale(&account.preferred_content_audio_language)
+        );
+        println!(
+            "{:<20} {}",
+            "Subtitle language",
+            format_locale(&account.preferred_content_subtitle_language)
+        );
+        println!("{:<20} {}", "Maturity rating", account.maturity_rating);
+
+        Ok(())
+    }
+}
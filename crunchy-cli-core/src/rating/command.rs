@@ -0,0 +1,131 @@
+use crate::utils::context::Context;
+use crate::utils::parse::parse_url;
+use crate::Execute;
+use anyhow::{bail, Result};
+use crunchyroll_rs::crunchyroll::SessionToken;
+use crunchyroll_rs::rating::{EpisodeRatingType, RatingStar, RatingStarDetails};
+use crunchyroll_rs::MediaCollection;
+use log::info;
+
+#[derive(Debug, clap::Parser)]
+#[clap(about = "Show or submit the rating of a series, movie listing or episode")]
+pub struct Rating {
+    #[arg(help = "Rate a series or movie listing with 1 to 5 stars")]
+    #[arg(long, value_parser = clap::value_parser!(u8).range(1..=5))]
+    stars: Option<u8>,
+    #[arg(help = "Like an episode")]
+    #[arg(long, default_value_t = false, conflicts_with_all = ["stars", "dislike"])]
+    like: bool,
+    #[arg(help = "Dislike an episode")]
+    #[arg(long, default_value_t = false, conflicts_with = "stars")]
+    dislike: bool,
+
+    #[arg(help = "Url of the series, movie listing or episode")]
+    url: String,
+}
+
+impl Execute for Rating {
+    async fn execute(self, ctx: Context) -> Result<()> {
+        let rates = self.stars.is_some() || self.like || self.dislike;
+        if rates && matches!(ctx.crunchy.session_token().await, SessionToken::Anonymous) {
+            bail!("Ratings cannot be submitted when logging in anonymously")
+        }
+
+        let (media_collection, _) = parse_url(&ctx.crunchy, self.url.clone(), false).await?;
+        match media_collection {
+            MediaCollection::Series(series) => {
+                if self.like || self.dislike {
+                    bail!("Series can only be rated with `--stars`")
+                }
+                let rating = match self.stars {
+                    Some(stars) => series.rate(rating_star(stars)).await?,
+                    None => series.rating().await?,
+                };
+                info!("Rating of {}", series.title);
+                print_star_rating(&rating);
+            }
+            MediaCollection::MovieListing(movie_listing) => {
+                if self.like || self.dislike {
+                    bail!("Movie listings can only be rated with `--stars`")
+                }
+                let rating = match self.stars {
+                    Some(stars) => movie_listing.rate(rating_star(stars)).await?,
+                    None => movie_listing.rating().await?,
+                };
+                info!("Rating of {}", movie_listing.title);
+                print_star_rating(&rating);
+            }
+            MediaCollection::Episode(episode) => {
+                if self.stars.is_some() {
+                    bail!("Episodes can only be rated with `--like` or `--dislike`")
+                }
+                let rating = if self.like {
+                    episode.rate(EpisodeRatingType::Like).await?
+                } else if self.dislike {
+                    episode.rate(EpisodeRatingType::Dislike).await?
+                } else {
+                    episode.rating().await?
+                };
+                info!(
+                    "Rating of {} S{:02}E{:0>2} - {}",
+                    episode.series_title, episode.season_number, episode.episode, episode.title
+                );
+                print_details("Likes", &rating.likes);
+                print_details("Dislikes", &rating.dislikes);
+                println!("{:<10} {}", "Total", rating.total);
+                if let Some(own) = rating.rating {
+                    println!(
+                        "{:<10} {}",
+                        "Yours",
+                        match own {
+                            EpisodeRatingType::Like => "like",
+                            EpisodeRatingType::Dislike => "dislike",
+                        }
+                    )
+                }
+            }
+            _ => bail!("Only series, movie listings and episodes can be rated"),
+        }
+
+        Ok(())
+    }
+}
+
+fn rating_star(stars: u8) -> RatingStar {
+    match stars {
+        1 => RatingStar::OneStar,
+        2 => RatingStar::TwoStars,
+        3 => RatingStar::ThreeStars,
+        4 => RatingStar::FourStars,
+        _ => RatingStar::FiveStars,
+    }
+}
+
+fn print_star_rating(rating: &crunchyroll_rs::rating::Rating) {
+    print_details("5 stars", &rating.five_stars);
+    print_details("4 stars", &rating.four_stars);
+    print_details("3 stars", &rating.three_stars);
+    print_details("2 stars", &rating.two_stars);
+    print_details("1 star", &rating.one_star);
+    println!(
+        "{:<10} {} ({} ratings)",
+        "Average", rating.average, rating.total
+    );
+    if let Some(own) = &rating.rating {
+        let stars = match own {
+            RatingStar::OneStar => 1,
+            RatingStar::TwoStars => 2,
+            RatingStar::ThreeStars => 3,
+            RatingStar::FourStars => 4,
+            RatingStar::FiveStars => 5,
+        };
+        println!("{:<10} {} stars", "Yours", stars)
+    }
+}
+
+fn print_details(label: &str, details: &RatingStarDetails) {
+    println!(
+        "{:<10} {:>3}%  {}{}",
+        label, details.percentage, details.displayed, details.unit
+    )
+}
@@ -1,15 +1,27 @@
 use crate::search::filter::FilterOptions;
 use crate::search::format::Format;
+use crate::search::non_video::{NonVideoKind, SkippedNonVideo};
+use crate::search::table::{render_table, Column};
+use crate::utils::completion::{locale_candidates, series_candidates};
 use crate::utils::context::Context;
+use crate::utils::os::write_atomic;
 use crate::utils::parse::{parse_url, UrlFilter};
 use crate::Execute;
 use anyhow::{bail, Result};
-use crunchyroll_rs::common::StreamExt;
-use crunchyroll_rs::search::QueryResults;
+use clap_complete::engine::ArgValueCandidates;
+use crunchyroll_rs::categories::Category;
+use crunchyroll_rs::search::{BrowseOptions, BrowseSortType, QueryResults};
 use crunchyroll_rs::{Episode, Locale, MediaCollection, MovieListing, MusicVideo, Series};
-use log::warn;
+use futures_util::{stream, StreamExt};
+use log::{debug, warn};
+use reqwest::Client;
+use std::fs;
+use std::path::PathBuf;
 use std::sync::Arc;
 
+/// Maximal number of images which are downloaded at the same time via `--prefetch-images`.
+const MAX_CONCURRENT_IMAGE_DOWNLOADS: usize = 8;
+
 #[derive(Debug, clap::Parser)]
 #[clap(about = "Search in videos")]
 #[command(arg_required_else_help(true))]
@@ -19,23 +31,78 @@ pub struct Search {
     #[arg(long_help = format!("Audio languages to include. \
     Available languages are:\n  {}", Locale::all().into_iter().map(|l| format!("{:<6} → {}", l.to_string(), l.to_human_readable())).collect::<Vec<String>>().join("\n  ")))]
     #[arg(long, default_values_t = vec![crate::utils::locale::system_locale()])]
+    #[arg(add = ArgValueCandidates::new(locale_candidates))]
     audio: Vec<Locale>,
 
     #[arg(help = "Limit of search top search results")]
-    #[arg(long, default_value_t = 5)]
+    #[arg(long, default_value_t = 5, value_parser = crate::utils::clap::clap_parse_limit)]
     search_top_results_limit: u32,
     #[arg(help = "Limit of search series results")]
-    #[arg(long, default_value_t = 0)]
+    #[arg(long, default_value_t = 0, value_parser = crate::utils::clap::clap_parse_limit)]
     search_series_limit: u32,
     #[arg(help = "Limit of search movie listing results")]
-    #[arg(long, default_value_t = 0)]
+    #[arg(long, default_value_t = 0, value_parser = crate::utils::clap::clap_parse_limit)]
     search_movie_listing_limit: u32,
     #[arg(help = "Limit of search episode results")]
-    #[arg(long, default_value_t = 0)]
+    #[arg(long, default_value_t = 0, value_parser = crate::utils::clap::clap_parse_limit)]
     search_episode_limit: u32,
     #[arg(help = "Limit of search music results")]
-    #[arg(long, default_value_t = 0)]
+    #[arg(long, default_value_t = 0, value_parser = crate::utils::clap::clap_parse_limit)]
     search_music_limit: u32,
+    #[arg(help = "Fail if the search returns non-video results (like news or games)")]
+    #[arg(
+        long_help = "Fail if the search returns non-video results (like news or game promotions). \
+    By default, these results are skipped and only a warning is shown"
+    )]
+    #[arg(long, default_value_t = false)]
+    fail_on_non_video: bool,
+    #[arg(help = "Number of results which are requested at once")]
+    #[arg(long_help = "Number of results which are requested at once. \
+    All results up to the limits are fetched, bigger pages only need fewer requests to do so")]
+    #[arg(long)]
+    page_size: Option<u32>,
+
+    #[arg(
+        help = "Go through the whole catalog instead of searching. Valid options are 'all', 'series' and 'movies'"
+    )]
+    #[arg(long, value_parser = BrowseType::parse)]
+    browse: Option<BrowseType>,
+    #[arg(help = "Limit of results when using `--browse`. `all` for no limit")]
+    #[arg(long, default_value = "all", value_parser = crate::utils::clap::clap_parse_limit)]
+    browse_limit: u32,
+    #[arg(help = format!("Only browse titles of this category. Can be used multiple times. \
+    Available categories are: {}", Category::all().into_iter().map(|c| c.to_string()).collect::<Vec<String>>().join(", ")))]
+    #[arg(long_help = format!("Only browse titles of this category. \
+    Can be used multiple times to pass multiple categories. \
+    Available categories are: {}", Category::all().into_iter().map(|c| c.to_string()).collect::<Vec<String>>().join(", ")))]
+    #[arg(long, requires = "browse")]
+    browse_category: Vec<Category>,
+    #[arg(
+        help = "Only browse titles of this simulcast season, e.g. 'fall-2024'. See `calendar --list-seasons` for all seasons"
+    )]
+    #[arg(long, requires = "browse")]
+    browse_simulcast: Option<String>,
+    #[arg(help = "Only browse titles which are dubbed")]
+    #[arg(long, requires = "browse", default_value_t = false)]
+    browse_dubbed: bool,
+    #[arg(help = "Only browse titles which are subbed")]
+    #[arg(long, requires = "browse", default_value_t = false)]
+    browse_subbed: bool,
+    #[arg(
+        help = "Order of the browse results. Valid options are 'popularity', 'newly-added' and 'alphabetical'"
+    )]
+    #[arg(long, requires = "browse", value_parser = parse_browse_sort)]
+    browse_sort: Option<BrowseSortType>,
+    #[arg(
+        help = "Only browse titles which have all of the given audio languages. Can be used multiple times"
+    )]
+    #[arg(
+        long_help = "Only browse titles which have all of the given audio languages. Can be used multiple times. \
+    The api doesn't support this filter, so the results are filtered locally. Titles without audio information are kept"
+    )]
+    #[arg(long, requires = "browse")]
+    #[arg(add = ArgValueCandidates::new(locale_candidates))]
+    browse_audio: Vec<Locale>,
 
     /// Format of the output text.
     ///
@@ -48,12 +115,17 @@ pub struct Search {
     ///     series.title              → Series title
     ///     series.description        → Series description
     ///     series.release_year       → Series release year
+    ///     series.image              → Series poster url (or path, see `--prefetch-images`)
+    ///     series.complete           → If all seasons of the series are complete (no new episodes are expected)
+    ///     series.next_air_date      → Air date of the next announced episode as unix timestamp, 0 if none is announced
+    ///     series.leaving_at         → Date at which the first episode leaves the catalog as unix timestamp, 0 if no removal is announced
     ///
     ///     season.id                 → Season id
     ///     season.title              → Season title
     ///     season.description        → Season description
     ///     season.number             → Season number
     ///     season.episodes           → Number of episodes the season has
+    ///     season.complete           → If the season is complete (no new episodes are expected)
     ///
     ///     episode.id                → Episode id
     ///     episode.title             → Episode title
@@ -64,10 +136,15 @@ pub struct Search {
     ///     episode.duration          → Episode duration in milliseconds
     ///     episode.air_date          → Episode air date as unix timestamp
     ///     episode.premium_only      → If the episode is only available with Crunchyroll premium
+    ///     episode.image             → Episode thumbnail url (or path, see `--prefetch-images`)
+    ///     episode.availability_ends → Date at which the episode leaves the catalog as unix timestamp, 0 if no removal is announced
+    ///     episode.versions          → Comma separated audio versions (dubs) of the episode in format of `<audio>:<episode id>`
+    ///     episode.intro_start       → Start of the intro in milliseconds, 0 if the episode has no intro. Also available: `intro_end`, `credits_start`, `credits_end`, `recap_start`, `recap_end`, `preview_start` and `preview_end`
     ///
     ///     movie_listing.id          → Movie listing id
     ///     movie_listing.title       → Movie listing title
     ///     movie_listing.description → Movie listing description
+    ///     movie_listing.image       → Movie listing poster url (or path, see `--prefetch-images`)
     ///
     ///     movie.id                  → Movie id
     ///     movie.title               → Movie title
@@ -80,16 +157,21 @@ pub struct Search {
     ///     music_video.description   → Music video description
     ///     music_video.duration      → Music video duration in milliseconds
     ///     music_video.premium_only  → If the music video is only available with Crunchyroll premium
+    ///     music_video.artist_id     → Id of the artist of the music video
+    ///     music_video.artist_name   → Name of the artist of the music video
     ///
     ///     concert.id                → Concert id
     ///     concert.title             → Concert title
     ///     concert.description       → Concert description
     ///     concert.duration          → Concert duration in milliseconds
     ///     concert.premium_only      → If the concert is only available with Crunchyroll premium
+    ///     concert.artist_id         → Id of the artist of the concert
+    ///     concert.artist_name       → Name of the artist of the concert
     ///
     ///     stream.locale             → Stream locale/language
     ///     stream.dash_url           → Stream url in DASH format. You need to set the `Authorization` header to `Bearer <account.token>` when requesting this url
     ///     stream.is_drm             → If `stream.dash_url` is DRM encrypted
+    ///     stream.hardsubs           → Comma separated subtitle languages which are available burned into the video. Use them with `download -s <language> --force-hardsub`
     ///
     ///     subtitle.locale           → Subtitle locale/language
     ///     subtitle.url              → Url to the subtitle
@@ -102,7 +184,80 @@ pub struct Search {
     #[arg(default_value = "S{{season.number}}E{{episode.number}} - {{episode.title}}")]
     output: String,
 
-    input: String,
+    #[arg(help = "Show the results as table instead of using the output template")]
+    #[arg(
+        long_help = "Show the results as table with aligned columns instead of using the output template. \
+    By default, the columns are title, type, year, episodes and audio"
+    )]
+    #[arg(long, default_value_t = false)]
+    table: bool,
+    #[arg(help = "Show all available columns in the table. Implies `--table`")]
+    #[arg(long, default_value_t = false)]
+    wide: bool,
+    #[arg(
+        help = "Comma separated columns to show in the table. Implies `--table`. Valid columns are 'id', 'title', 'type', 'year', 'episodes', 'audio' and 'subtitles'"
+    )]
+    #[arg(long, value_delimiter = ',', value_parser = Column::parse)]
+    columns: Vec<Column>,
+
+    #[arg(help = "Download the images of all results into a directory")]
+    #[arg(long_help = "Download the images of all results into a directory. \
+    The `*.image` keywords are replaced with the path to the downloaded image instead of its url. \
+    The images are downloaded before the output is printed, so e.g. interactive pickers can show them right away")]
+    #[arg(long)]
+    prefetch_images: Option<PathBuf>,
+
+    #[arg(required_unless_present = "browse", conflicts_with = "browse")]
+    #[arg(add = ArgValueCandidates::new(series_candidates))]
+    input: Option<String>,
+}
+
+fn parse_browse_sort(s: &str) -> Result<BrowseSortType, String> {
+    match s.to_lowercase().as_str() {
+        "popularity" => Ok(BrowseSortType::Popularity),
+        "newly-added" => Ok(BrowseSortType::NewlyAdded),
+        "alphabetical" => Ok(BrowseSortType::Alphabetical),
+        _ => Err(format!("'{}' is not a valid browse order", s)),
+    }
+}
+
+/// If a browse result has all of the given audio locales. Results whose audio locales are unknown
+/// (the api doesn't always populate them) are kept.
+fn has_audio(media_collection: &MediaCollection, audio: &[Locale]) -> bool {
+    let available = match media_collection {
+        MediaCollection::Series(series) => series.audio_locales.clone(),
+        MediaCollection::MovieListing(movie_listing) => {
+            movie_listing.audio_locale.clone().into_iter().collect()
+        }
+        _ => vec![],
+    };
+    available.is_empty() || audio.iter().all(|a| available.contains(a))
+}
+
+#[derive(Clone, Debug)]
+enum BrowseType {
+    All,
+    Series,
+    Movies,
+}
+
+impl BrowseType {
+    fn parse(s: &str) -> Result<Self, String> {
+        match s.to_lowercase().as_str() {
+            "all" => Ok(Self::All),
+            "series" => Ok(Self::Series),
+            "movies" => Ok(Self::Movies),
+            _ => Err(format!("'{}' is not a valid browse type", s)),
+        }
+    }
+
+    fn matches(&self, media_collection: &MediaCollection) -> bool {
+        match self {
+            BrowseType::All => true,
+            BrowseType::Series => matches!(media_collection, MediaCollection::Series(_)),
+            BrowseType::Movies => matches!(media_collection, MediaCollection::MovieListing(_)),
+        }
+    }
 }
 
 impl Execute for Search {
@@ -111,15 +266,53 @@ impl Execute for Search {
             warn!("Using `search` anonymously or with a non-premium account may return incomplete results")
         }
 
-        let input = if crunchyroll_rs::parse::parse_url(&self.input).is_some() {
-            match parse_url(&ctx.crunchy, self.input.clone(), true).await {
+        let query = self.input.clone().unwrap_or_default();
+        let input = if let Some(browse_type) = &self.browse {
+            let mut output = vec![];
+            let mut browse_options = BrowseOptions::default();
+            if !self.browse_category.is_empty() {
+                browse_options = browse_options.categories(self.browse_category.clone())
+            }
+            if let Some(simulcast) = &self.browse_simulcast {
+                browse_options = browse_options.simulcast(simulcast.clone())
+            }
+            // the filters are only sent if they're set, `false` would only return titles which
+            // are not dubbed / subbed
+            if self.browse_dubbed {
+                browse_options = browse_options.is_dubbed(true)
+            }
+            if self.browse_subbed {
+                browse_options = browse_options.is_subbed(true)
+            }
+            if let Some(sort) = &self.browse_sort {
+                browse_options = browse_options.sort(sort.clone())
+            }
+            let mut browse = ctx.crunchy.browse(browse_options);
+            if let Some(page_size) = self.page_size {
+                browse.page_size(page_size)
+            }
+            while let Some(media_collection) = browse.next().await {
+                let media_collection = media_collection?;
+                if !browse_type.matches(&media_collection)
+                    || !has_audio(&media_collection, &self.browse_audio)
+                {
+                    continue;
+                }
+                output.push((media_collection, UrlFilter::default()));
+                if output.len() >= self.browse_limit as usize {
+                    break;
+                }
+            }
+            output
+        } else if crunchyroll_rs::parse::parse_url(&query).is_some() {
+            match parse_url(&ctx.crunchy, query.clone(), true).await {
                 Ok(ok) => vec![ok],
-                Err(e) => bail!("url {} could not be parsed: {}", self.input, e),
+                Err(e) => bail!("url {} could not be parsed: {}", query, e),
             }
         } else {
             let mut output = vec![];
 
-            let query = resolve_query(&self, ctx.crunchy.query(&self.input)).await?;
+            let query = resolve_query(&self, ctx.crunchy.query(&query)).await?;
             output.extend(query.0.into_iter().map(|m| (m, UrlFilter::default())));
             output.extend(
                 query
@@ -149,27 +342,100 @@ impl Execute for Search {
             output
         };
 
+        if self.table || self.wide || !self.columns.is_empty() {
+            let columns = if !self.columns.is_empty() {
+                self.columns.clone()
+            } else if self.wide {
+                Column::wide_columns()
+            } else {
+                Column::default_columns()
+            };
+            let media: Vec<MediaCollection> = input.into_iter().map(|(m, _)| m).collect();
+            println!("{}", render_table(&columns, &media));
+            return Ok(());
+        }
+
         let crunchy_arc = Arc::new(ctx.crunchy);
+        let mut output = vec![];
+        let mut images = vec![];
         for (media_collection, url_filter) in input {
             let filter_options = FilterOptions {
                 audio: self.audio.clone(),
                 url_filter,
             };
 
-            let format = Format::new(self.output.clone(), filter_options, crunchy_arc.clone())?;
-            println!("{}", format.parse(media_collection).await?);
+            let format = Format::new(self.output.clone(), filter_options, crunchy_arc.clone())?
+                .image_dir(self.prefetch_images.clone());
+            output.push(format.parse(media_collection).await?);
+            images.extend(format.take_images())
+        }
+
+        if self.prefetch_images.is_some() {
+            prefetch_images(&ctx.client, images).await?
+        }
+        for o in output {
+            println!("{}", o)
         }
 
         Ok(())
     }
 }
 
+/// Download images concurrently. Images which already exist are not downloaded again.
+async fn prefetch_images(client: &Client, mut images: Vec<(String, PathBuf)>) -> Result<()> {
+    images.sort();
+    images.dedup();
+    images.retain(|(_, path)| !path.exists());
+    if let Some(parent) = images.first().and_then(|(_, path)| path.parent()) {
+        fs::create_dir_all(parent)?
+    }
+
+    let mut downloads = stream::iter(images)
+        .map(|(url, path)| async move {
+            let bytes = client
+                .get(&url)
+                .send()
+                .await?
+                .error_for_status()?
+                .bytes()
+                .await?;
+            // an interrupted write must not leave a broken image behind, as existing images
+            // aren't downloaded again
+            write_atomic(&path, bytes)?;
+            debug!("Downloaded image {} to {}", url, path.to_string_lossy());
+            Ok::<(), anyhow::Error>(())
+        })
+        .buffer_unordered(MAX_CONCURRENT_IMAGE_DOWNLOADS);
+    while let Some(result) = downloads.next().await {
+        if let Err(e) = result {
+            warn!("Failed to download image: {}", e)
+        }
+    }
+
+    Ok(())
+}
+
 macro_rules! resolve_query {
-    ($limit:expr, $vec:expr, $item:expr) => {
+    ($search:expr, $skipped:expr, $limit:expr, $vec:expr, $item:expr) => {
         if $limit > 0 {
             let mut item_results = $item;
+            if let Some(page_size) = $search.page_size {
+                item_results.page_size(page_size)
+            }
             while let Some(item) = item_results.next().await {
-                $vec.push(item?);
+                let item = match item {
+                    Ok(item) => item,
+                    Err(e) => match NonVideoKind::classify(&e) {
+                        Some(kind) if !$search.fail_on_non_video => {
+                            debug!("Skipping non-video search result ({})", kind);
+                            $skipped.add(kind);
+                            continue;
+                        }
+                        Some(kind) => bail!("The search returned a non-video result ({})", kind),
+                        None => return Err(e.into()),
+                    },
+                };
+                $vec.push(item);
                 if $vec.len() >= $limit as usize {
                     break;
                 }
@@ -193,20 +459,47 @@ async fn resolve_query(
     let mut movie_listing = vec![];
     let mut episode = vec![];
     let mut music_video = vec![];
+    let mut skipped = SkippedNonVideo::default();
 
     resolve_query!(
+        search,
+        skipped,
         search.search_top_results_limit,
         media_collection,
         query_results.top_results
     );
-    resolve_query!(search.search_series_limit, series, query_results.series);
     resolve_query!(
+        search,
+        skipped,
+        search.search_series_limit,
+        series,
+        query_results.series
+    );
+    resolve_query!(
+        search,
+        skipped,
         search.search_movie_listing_limit,
         movie_listing,
         query_results.movie_listing
     );
-    resolve_query!(search.search_episode_limit, episode, query_results.episode);
-    resolve_query!(search.search_music_limit, music_video, query_results.music);
+    resolve_query!(
+        search,
+        skipped,
+        search.search_episode_limit,
+        episode,
+        query_results.episode
+    );
+    resolve_query!(
+        search,
+        skipped,
+        search.search_music_limit,
+        music_video,
+        query_results.music
+    );
+
+    if !skipped.is_empty() {
+        warn!("Skipped non-video search results: {}", skipped)
+    }
 
     Ok((
         media_collection,
@@ -1,6 +1,10 @@
 use crate::search::filter::FilterOptions;
+use crate::utils::availability::{is_removal_announced, leaving_at};
+use crate::utils::os::sanitize;
 use anyhow::{bail, Result};
-use crunchyroll_rs::media::{Stream, Subtitle};
+use chrono::{DateTime, Utc};
+use crunchyroll_rs::common::Image;
+use crunchyroll_rs::media::{SkipEvents, SkipEventsEvent, Stream, Subtitle};
 use crunchyroll_rs::{
     Concert, Crunchyroll, Episode, Locale, MediaCollection, Movie, MovieListing, MusicVideo,
     Season, Series,
@@ -10,7 +14,31 @@ use serde::Serialize;
 use serde_json::{Map, Value};
 use std::collections::HashMap;
 use std::ops::Range;
-use std::sync::Arc;
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+
+fn image_file_name(url: &str) -> String {
+    // crunchyroll image urls are ending with an unique file name
+    url.rsplit('/')
+        .next()
+        .filter(|name| !name.is_empty())
+        .map_or_else(
+            || sanitize(url, true, true),
+            |name| sanitize(name, true, true),
+        )
+}
+
+/// Get the smallest image which is at least 240 pixels wide. Search output is mostly used for
+/// pickers or lists where the full size images are unnecessary large.
+fn small_image(images: &[Image]) -> String {
+    let mut images: Vec<&Image> = images.iter().collect();
+    images.sort_by_key(|i| i.width);
+    images
+        .iter()
+        .find(|i| i.width >= 240)
+        .or(images.last())
+        .map_or("".to_string(), |i| i.source.clone())
+}
 
 #[derive(Default, Serialize)]
 struct FormatSeries {
@@ -18,6 +46,13 @@ struct FormatSeries {
     pub title: String,
     pub description: String,
     pub release_year: u32,
+    pub image: String,
+    /// Only set if used in the output, see [`Format::series_status`].
+    pub complete: bool,
+    /// Only set if used in the output, see [`Format::series_status`].
+    pub next_air_date: i64,
+    /// Only set if used in the output, see [`leaving_at`].
+    pub leaving_at: i64,
 }
 
 impl From<&Series> for FormatSeries {
@@ -27,6 +62,10 @@ impl From<&Series> for FormatSeries {
             title: value.title.clone(),
             description: value.description.clone(),
             release_year: value.series_launch_year.unwrap_or_default(),
+            image: small_image(&value.images.poster_tall),
+            complete: false,
+            next_air_date: 0,
+            leaving_at: 0,
         }
     }
 }
@@ -38,6 +77,7 @@ struct FormatSeason {
     pub description: String,
     pub number: u32,
     pub episodes: u32,
+    pub complete: bool,
 }
 
 impl From<&Season> for FormatSeason {
@@ -48,6 +88,7 @@ impl From<&Season> for FormatSeason {
             description: value.description.clone(),
             number: value.season_number,
             episodes: value.number_of_episodes,
+            complete: value.is_complete,
         }
     }
 }
@@ -63,6 +104,47 @@ struct FormatEpisode {
     pub duration: i64,
     pub air_date: i64,
     pub premium_only: bool,
+    pub image: String,
+    pub availability_ends: i64,
+    /// Comma separated `<audio locale>:<episode id>` of all audio versions (dubs) of the episode.
+    pub versions: String,
+    /// Only set if used in the output, see [`FormatEpisode::set_skip_events`].
+    pub intro_start: i64,
+    pub intro_end: i64,
+    pub credits_start: i64,
+    pub credits_end: i64,
+    pub recap_start: i64,
+    pub recap_end: i64,
+    pub preview_start: i64,
+    pub preview_end: i64,
+}
+
+impl FormatEpisode {
+    /// Fields which require to request the skip events of an episode.
+    const SKIP_EVENT_FIELDS: [&'static str; 8] = [
+        "intro_start",
+        "intro_end",
+        "credits_start",
+        "credits_end",
+        "recap_start",
+        "recap_end",
+        "preview_start",
+        "preview_end",
+    ];
+
+    /// Set the start and end of the skip events in milliseconds. Events which the episode doesn't
+    /// have stay 0.
+    fn set_skip_events(&mut self, skip_events: &SkipEvents) {
+        let millis = |event: &Option<SkipEventsEvent>| {
+            event.as_ref().map_or((0, 0), |e| {
+                ((e.start * 1000.0) as i64, (e.end * 1000.0) as i64)
+            })
+        };
+        (self.intro_start, self.intro_end) = millis(&skip_events.intro);
+        (self.credits_start, self.credits_end) = millis(&skip_events.credits);
+        (self.recap_start, self.recap_end) = millis(&skip_events.recap);
+        (self.preview_start, self.preview_end) = millis(&skip_events.preview);
+    }
 }
 
 impl From<&Episode> for FormatEpisode {
@@ -77,6 +159,23 @@ impl From<&Episode> for FormatEpisode {
             duration: value.duration.num_milliseconds(),
             air_date: value.episode_air_date.timestamp(),
             premium_only: value.is_premium_only,
+            image: small_image(&value.images.thumbnail),
+            availability_ends: if is_removal_announced(&value.availability_ends) {
+                value.availability_ends.timestamp()
+            } else {
+                0
+            },
+            versions: value.versions.as_ref().map_or_else(
+                || format!("{}:{}", value.audio_locale, value.id),
+                |versions| {
+                    versions
+                        .iter()
+                        .map(|v| format!("{}:{}", v.audio_locale, v.id))
+                        .collect::<Vec<String>>()
+                        .join(",")
+                },
+            ),
+            ..Default::default()
         }
     }
 }
@@ -86,6 +185,7 @@ struct FormatMovieListing {
     pub id: String,
     pub title: String,
     pub description: String,
+    pub image: String,
 }
 
 impl From<&MovieListing> for FormatMovieListing {
@@ -94,6 +194,7 @@ impl From<&MovieListing> for FormatMovieListing {
             id: value.id.clone(),
             title: value.title.clone(),
             description: value.description.clone(),
+            image: small_image(&value.images.poster_tall),
         }
     }
 }
@@ -126,6 +227,8 @@ struct FormatMusicVideo {
     pub description: String,
     pub duration: i64,
     pub premium_only: bool,
+    pub artist_id: String,
+    pub artist_name: String,
 }
 
 impl From<&MusicVideo> for FormatMusicVideo {
@@ -136,6 +239,8 @@ impl From<&MusicVideo> for FormatMusicVideo {
             description: value.description.clone(),
             duration: value.duration.num_milliseconds(),
             premium_only: value.is_premium_only,
+            artist_id: value.artist.id.clone(),
+            artist_name: value.artist.name.clone(),
         }
     }
 }
@@ -147,6 +252,8 @@ struct FormatConcert {
     pub description: String,
     pub duration: i64,
     pub premium_only: bool,
+    pub artist_id: String,
+    pub artist_name: String,
 }
 
 impl From<&Concert> for FormatConcert {
@@ -157,6 +264,8 @@ impl From<&Concert> for FormatConcert {
             description: value.description.clone(),
             duration: value.duration.num_milliseconds(),
             premium_only: value.is_premium_only,
+            artist_id: value.artist.id.clone(),
+            artist_name: value.artist.name.clone(),
         }
     }
 }
@@ -166,14 +275,19 @@ struct FormatStream {
     pub locale: Locale,
     pub dash_url: String,
     pub is_drm: bool,
+    /// Comma separated subtitle locales which are available burned into the video.
+    pub hardsubs: String,
 }
 
 impl From<&Stream> for FormatStream {
     fn from(value: &Stream) -> Self {
+        let mut hardsubs: Vec<String> = value.hard_subs.keys().map(|l| l.to_string()).collect();
+        hardsubs.sort();
         Self {
             locale: value.audio_locale.clone(),
             dash_url: value.url.clone(),
             is_drm: value.session.uses_stream_limits,
+            hardsubs: hardsubs.join(","),
         }
     }
 }
@@ -255,6 +369,10 @@ pub struct Format {
     input: String,
     filter_options: FilterOptions,
     crunchyroll: Arc<Crunchyroll>,
+    /// If set, `*.image` keywords are replaced with the path of the image in this directory
+    /// instead of its url. The images must be downloaded afterwards, see [`Format::take_images`].
+    image_dir: Option<PathBuf>,
+    images: Mutex<Vec<(String, PathBuf)>>,
 }
 
 impl Format {
@@ -334,9 +452,21 @@ impl Format {
             input,
             filter_options,
             crunchyroll,
+            image_dir: None,
+            images: Mutex::new(vec![]),
         })
     }
 
+    pub fn image_dir(mut self, image_dir: Option<PathBuf>) -> Self {
+        self.image_dir = image_dir;
+        self
+    }
+
+    /// Urls and destination paths of all images which were referenced in the output so far.
+    pub fn take_images(&self) -> Vec<(String, PathBuf)> {
+        std::mem::take(&mut *self.images.lock().unwrap())
+    }
+
     pub async fn parse(&self, media_collection: MediaCollection) -> Result<String> {
         match &media_collection {
             MediaCollection::Series(_)
@@ -483,11 +613,30 @@ impl Format {
         } else {
             Map::default()
         };
-        let series_map = self.serializable_to_json_map(FormatSeries::from(&series));
+        let mut format_series = FormatSeries::from(&series);
+        if self.pattern_contains(Scope::Series, "complete")
+            || self.pattern_contains(Scope::Series, "next_air_date")
+        {
+            (format_series.complete, format_series.next_air_date) =
+                self.series_status(&series).await?
+        }
+        if self.pattern_contains(Scope::Series, "leaving_at") {
+            format_series.leaving_at = leaving_at(&series.clone().into())
+                .await?
+                .map_or(0, |d| d.timestamp())
+        }
+        let series_map = self.serializable_to_json_map(format_series);
         for (season, episodes) in tree {
             let season_map = self.serializable_to_json_map(FormatSeason::from(&season));
             for (episode, streams) in episodes {
-                let episode_map = self.serializable_to_json_map(FormatEpisode::from(&episode));
+                let mut format_episode = FormatEpisode::from(&episode);
+                if FormatEpisode::SKIP_EVENT_FIELDS
+                    .iter()
+                    .any(|field| self.pattern_contains(Scope::Episode, field))
+                {
+                    format_episode.set_skip_events(&episode.skip_events().await?)
+                }
+                let episode_map = self.serializable_to_json_map(format_episode);
                 for stream in streams {
                     let stream_map = self.serializable_to_json_map(FormatStream::from(&stream));
 
@@ -619,6 +768,36 @@ impl Format {
         serde_json::from_value(serde_json::to_value(s).unwrap()).unwrap()
     }
 
+    /// Get if all seasons of a series are complete and the air date of the next episode (as unix
+    /// timestamp, 0 if unknown). Requires to fetch all seasons and the episodes of incomplete seasons,
+    /// so this should only be called if the values are actually used.
+    async fn series_status(&self, series: &Series) -> Result<(bool, i64)> {
+        let now = Utc::now();
+        let mut complete = true;
+        let mut next_air_date: Option<DateTime<Utc>> = None;
+        for season in series.seasons().await? {
+            if season.is_complete {
+                continue;
+            }
+            complete = false;
+            for episode in season.episodes().await? {
+                // announced episodes are already listed, but not available yet
+                if episode.premium_available_date > now
+                    && next_air_date.map_or(true, |d| episode.premium_available_date < d)
+                {
+                    next_air_date = Some(episode.premium_available_date)
+                }
+            }
+        }
+        Ok((complete, next_air_date.map_or(0, |d| d.timestamp())))
+    }
+
+    fn pattern_contains(&self, scope: Scope, field: &str) -> bool {
+        self.pattern
+            .iter()
+            .any(|(_, s, f)| s == &scope && f == field)
+    }
+
     fn check_pattern_count_empty(&self, scope: Scope) -> bool {
         self.pattern_count.get(&scope).cloned().unwrap_or_default() == 0
     }
@@ -665,9 +844,16 @@ impl Format {
         let mut output = self.input.clone();
         let mut offset = 0;
         for (range, scope, field) in &self.pattern {
-            let item =
+            let mut item =
                 serde_plain::to_string(values.get(scope).unwrap().get(field.as_str()).unwrap())
                     .unwrap();
+            if let Some(image_dir) = &self.image_dir {
+                if field == "image" && !item.is_empty() {
+                    let path = image_dir.join(image_file_name(&item));
+                    self.images.lock().unwrap().push((item, path.clone()));
+                    item = path.to_string_lossy().to_string()
+                }
+            }
             let start = (range.start as i32 + offset) as usize;
             let end = (range.end as i32 + offset) as usize;
             output.replace_range(start..end, &item);
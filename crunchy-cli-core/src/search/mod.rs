@@ -1,5 +1,7 @@
 mod command;
 mod filter;
 mod format;
+mod non_video;
+mod table;
 
 pub use command::Search;
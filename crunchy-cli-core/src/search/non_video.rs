@@ -0,0 +1,64 @@
+use crunchyroll_rs::error::Error;
+use std::collections::BTreeMap;
+use std::fmt::{Display, Formatter};
+
+/// Search results which aren't videos. Crunchyroll sometimes mixes e.g. news articles or game
+/// promotions into search results, which can't be decoded as any video type.
+#[derive(Clone, Debug, Eq, Ord, PartialEq, PartialOrd)]
+pub(crate) enum NonVideoKind {
+    News,
+    Game,
+}
+
+impl Display for NonVideoKind {
+    fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
+        match self {
+            NonVideoKind::News => write!(f, "news"),
+            NonVideoKind::Game => write!(f, "game"),
+        }
+    }
+}
+
+impl NonVideoKind {
+    /// Classify a decode error of a search result. Returns [`None`] if the error isn't caused by
+    /// one of the known non-video result types, such errors must not be swallowed.
+    pub(crate) fn classify(error: &Error) -> Option<Self> {
+        let Error::Decode { message, .. } = error else {
+            return None;
+        };
+        // serde reports unknown enum tags as "unknown variant `<type>`, expected one of ..."
+        let (_, rest) = message.split_once("unknown variant `")?;
+        let (kind, _) = rest.split_once('`')?;
+
+        match kind {
+            "news" | "news_feed" | "article" => Some(NonVideoKind::News),
+            "game" | "games" => Some(NonVideoKind::Game),
+            _ => None,
+        }
+    }
+}
+
+/// Counts the non-video results which were skipped, per type.
+#[derive(Debug, Default)]
+pub(crate) struct SkippedNonVideo(BTreeMap<NonVideoKind, usize>);
+
+impl SkippedNonVideo {
+    pub(crate) fn add(&mut self, kind: NonVideoKind) {
+        *self.0.entry(kind).or_default() += 1
+    }
+
+    pub(crate) fn is_empty(&self) -> bool {
+        self.0.is_empty()
+    }
+}
+
+impl Display for SkippedNonVideo {
+    fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
+        let counts: Vec<String> = self
+            .0
+            .iter()
+            .map(|(kind, count)| format!("{} {}", count, kind))
+            .collect();
+        write!(f, "{}", counts.join(", "))
+    }
+}
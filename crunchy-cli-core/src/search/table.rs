@@ -0,0 +1,194 @@
+use chrono::Datelike;
+use crunchyroll_rs::{Locale, MediaCollection};
+use std::fmt::{Display, Formatter};
+
+#[derive(Clone, Copy, Debug, Eq, PartialEq)]
+pub(crate) enum Column {
+    Id,
+    Title,
+    Type,
+    Year,
+    Episodes,
+    Audio,
+    Subtitles,
+}
+
+impl Display for Column {
+    fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
+        let value = match self {
+            Column::Id => "id",
+            Column::Title => "title",
+            Column::Type => "type",
+            Column::Year => "year",
+            Column::Episodes => "episodes",
+            Column::Audio => "audio",
+            Column::Subtitles => "subtitles",
+        };
+        write!(f, "{}", value)
+    }
+}
+
+impl Column {
+    pub(crate) fn parse(s: &str) -> Result<Self, String> {
+        match s.to_lowercase().as_str() {
+            "id" => Ok(Self::Id),
+            "title" => Ok(Self::Title),
+            "type" => Ok(Self::Type),
+            "year" => Ok(Self::Year),
+            "episodes" => Ok(Self::Episodes),
+            "audio" => Ok(Self::Audio),
+            "subtitles" => Ok(Self::Subtitles),
+            _ => Err(format!("invalid column '{}'", s)),
+        }
+    }
+
+    pub(crate) fn default_columns() -> Vec<Self> {
+        vec![
+            Self::Title,
+            Self::Type,
+            Self::Year,
+            Self::Episodes,
+            Self::Audio,
+        ]
+    }
+
+    pub(crate) fn wide_columns() -> Vec<Self> {
+        vec![
+            Self::Id,
+            Self::Title,
+            Self::Type,
+            Self::Year,
+            Self::Episodes,
+            Self::Audio,
+            Self::Subtitles,
+        ]
+    }
+
+    fn value(&self, media_collection: &MediaCollection) -> String {
+        let locales = |locales: &[Locale]| {
+            locales
+                .iter()
+                .map(|l| l.to_string())
+                .collect::<Vec<String>>()
+                .join(",")
+        };
+
+        match (self, media_collection) {
+            (Column::Id, MediaCollection::Series(series)) => series.id.clone(),
+            (Column::Id, MediaCollection::Season(season)) => season.id.clone(),
+            (Column::Id, MediaCollection::Episode(episode)) => episode.id.clone(),
+            (Column::Id, MediaCollection::MovieListing(movie_listing)) => movie_listing.id.clone(),
+            (Column::Id, MediaCollection::Movie(movie)) => movie.id.clone(),
+            (Column::Id, MediaCollection::MusicVideo(music_video)) => music_video.id.clone(),
+            (Column::Id, MediaCollection::Concert(concert)) => concert.id.clone(),
+
+            (Column::Title, MediaCollection::Series(series)) => series.title.clone(),
+            (Column::Title, MediaCollection::Season(season)) => season.title.clone(),
+            (Column::Title, MediaCollection::Episode(episode)) => format!(
+                "{} S{:02}E{:0>2} - {}",
+                episode.series_title, episode.season_number, episode.episode, episode.title
+            ),
+            (Column::Title, MediaCollection::MovieListing(movie_listing)) => {
+                movie_listing.title.clone()
+            }
+            (Column::Title, MediaCollection::Movie(movie)) => movie.title.clone(),
+            (Column::Title, MediaCollection::MusicVideo(music_video)) => music_video.title.clone(),
+            (Column::Title, MediaCollection::Concert(concert)) => concert.title.clone(),
+
+            (Column::Type, MediaCollection::Series(_)) => "series".to_string(),
+            (Column::Type, MediaCollection::Season(_)) => "season".to_string(),
+            (Column::Type, MediaCollection::Episode(_)) => "episode".to_string(),
+            (Column::Type, MediaCollection::MovieListing(_)) => "movie_listing".to_string(),
+            (Column::Type, MediaCollection::Movie(_)) => "movie".to_string(),
+            (Column::Type, MediaCollection::MusicVideo(_)) => "music_video".to_string(),
+            (Column::Type, MediaCollection::Concert(_)) => "concert".to_string(),
+
+            (Column::Year, MediaCollection::Series(series)) => series
+                .series_launch_year
+                .map_or("".to_string(), |y| y.to_string()),
+            (Column::Year, MediaCollection::Episode(episode)) => {
+                episode.episode_air_date.year().to_string()
+            }
+            (Column::Year, MediaCollection::MusicVideo(music_video)) => {
+                music_video.original_release.year().to_string()
+            }
+            (Column::Year, MediaCollection::Concert(concert)) => {
+                concert.original_release.year().to_string()
+            }
+
+            (Column::Episodes, MediaCollection::Series(series)) => series.episode_count.to_string(),
+            (Column::Episodes, MediaCollection::Season(season)) => {
+                season.number_of_episodes.to_string()
+            }
+
+            (Column::Audio, MediaCollection::Series(series)) => locales(&series.audio_locales),
+            (Column::Audio, MediaCollection::Season(season)) => locales(&season.audio_locales),
+            (Column::Audio, MediaCollection::Episode(episode)) => episode.audio_locale.to_string(),
+
+            (Column::Subtitles, MediaCollection::Series(series)) => {
+                locales(&series.subtitle_locales)
+            }
+            (Column::Subtitles, MediaCollection::Season(season)) => {
+                locales(&season.subtitle_locales)
+            }
+            (Column::Subtitles, MediaCollection::Episode(episode)) => {
+                locales(&episode.subtitle_locales)
+            }
+
+            _ => "".to_string(),
+        }
+    }
+}
+
+/// Render the given media as table with aligned columns. Empty values are shown as `-`, so that
+/// columns without whitespace in their values (everything except the title) can be reliably split
+/// by tools like awk.
+pub(crate) fn render_table(columns: &[Column], media: &[MediaCollection]) -> String {
+    let mut rows = vec![columns
+        .iter()
+        .map(|c| c.to_string().to_uppercase())
+        .collect::<Vec<String>>()];
+    for media_collection in media {
+        rows.push(
+            columns
+                .iter()
+                .map(|c| {
+                    let value = c.value(media_collection);
+                    if value.is_empty() {
+                        "-".to_string()
+                    } else {
+                        value
+                    }
+                })
+                .collect(),
+        )
+    }
+
+    let widths: Vec<usize> = (0..columns.len())
+        .map(|i| {
+            rows.iter()
+                .map(|row| row[i].chars().count())
+                .max()
+                .unwrap_or_default()
+        })
+        .collect();
+
+    rows.into_iter()
+        .map(|row| {
+            row.into_iter()
+                .enumerate()
+                .map(|(i, value)| {
+                    // the last column isn't padded to avoid trailing whitespace
+                    if i == widths.len() - 1 {
+                        value
+                    } else {
+                        let padding = widths[i] - value.chars().count();
+                        format!("{}{}", value, " ".repeat(padding))
+                    }
+                })
+                .collect::<Vec<String>>()
+                .join("  ")
+        })
+        .collect::<Vec<String>>()
+        .join("\n")
+}
@@ -0,0 +1,202 @@
+use crate::utils::fmt::format_file_size;
+use crate::utils::history::{read_history, HistoryEntry};
+use anyhow::{bail, Result};
+use chrono::DateTime;
+use serde::Serialize;
+use serde_json::{Map, Value};
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::PathBuf;
+
+#[derive(Debug, clap::Parser)]
+#[clap(about = "Show statistics about your downloads")]
+pub struct Stats {
+    #[arg(help = "Number of series to show")]
+    #[arg(long, default_value_t = 10)]
+    limit: usize,
+
+    #[arg(help = "Export the statistics as csv to a file")]
+    #[arg(long)]
+    csv: Option<PathBuf>,
+    #[arg(help = "Print the statistics as json")]
+    #[arg(
+        long_help = "Print the statistics as json, e.g. to show them in your own dashboard. \
+    Every section is a list of objects containing the key (series, language or month), the number of videos, their size in bytes and their duration in seconds"
+    )]
+    #[arg(long, default_value_t = false, conflicts_with = "csv")]
+    json: bool,
+}
+
+#[derive(Default, Serialize)]
+struct StatsRow {
+    videos: u64,
+    size: u64,
+    /// Duration of all videos in seconds.
+    duration: i64,
+}
+
+impl StatsRow {
+    fn add(&mut self, entry: &HistoryEntry) {
+        self.videos += 1;
+        self.size += entry.size;
+        self.duration += entry.duration / 1000
+    }
+}
+
+struct StatsReport {
+    total: StatsRow,
+    series: Vec<(String, StatsRow)>,
+    audio: Vec<(String, StatsRow)>,
+    subtitles: Vec<(String, StatsRow)>,
+    months: Vec<(String, StatsRow)>,
+}
+
+impl StatsReport {
+    fn new(history: &[HistoryEntry]) -> Self {
+        let mut total = StatsRow::default();
+        let mut series: BTreeMap<String, StatsRow> = BTreeMap::new();
+        let mut audio: BTreeMap<String, StatsRow> = BTreeMap::new();
+        let mut subtitles: BTreeMap<String, StatsRow> = BTreeMap::new();
+        let mut months: BTreeMap<String, StatsRow> = BTreeMap::new();
+
+        for entry in history {
+            total.add(entry);
+            series
+                .entry(entry.series_name.clone())
+                .or_default()
+                .add(entry);
+            for locale in &entry.audio {
+                audio.entry(locale.clone()).or_default().add(entry)
+            }
+            for locale in &entry.subtitles {
+                subtitles.entry(locale.clone()).or_default().add(entry)
+            }
+            let month = DateTime::from_timestamp(entry.timestamp, 0)
+                .map_or("unknown".to_string(), |t| t.format("%Y-%m").to_string());
+            months.entry(month).or_default().add(entry)
+        }
+
+        let by_size = |map: BTreeMap<String, StatsRow>| {
+            let mut rows: Vec<(String, StatsRow)> = map.into_iter().collect();
+            rows.sort_by(|(_, a), (_, b)| b.size.cmp(&a.size));
+            rows
+        };
+        let by_videos = |map: BTreeMap<String, StatsRow>| {
+            let mut rows: Vec<(String, StatsRow)> = map.into_iter().collect();
+            rows.sort_by(|(_, a), (_, b)| b.videos.cmp(&a.videos));
+            rows
+        };
+
+        Self {
+            total,
+            series: by_size(series),
+            audio: by_videos(audio),
+            subtitles: by_videos(subtitles),
+            // months are already sorted chronologically by the btree map
+            months: months.into_iter().collect(),
+        }
+    }
+
+    fn sections(&self) -> [(&'static str, &Vec<(String, StatsRow)>); 4] {
+        [
+            ("series", &self.series),
+            ("audio", &self.audio),
+            ("subtitles", &self.subtitles),
+            ("month", &self.months),
+        ]
+    }
+
+    fn to_csv(&self) -> String {
+        let escape = |s: &str| format!("\"{}\"", s.replace('"', "\"\""));
+
+        let mut csv = vec!["report,key,videos,size,duration".to_string()];
+        csv.push(format!(
+            "total,,{},{},{}",
+            self.total.videos, self.total.size, self.total.duration
+        ));
+        for (name, rows) in self.sections() {
+            for (key, row) in rows {
+                csv.push(format!(
+                    "{},{},{},{},{}",
+                    name,
+                    escape(key),
+                    row.videos,
+                    row.size,
+                    row.duration
+                ))
+            }
+        }
+        csv.join("\n")
+    }
+
+    fn to_json(&self) -> Value {
+        let mut json = Map::new();
+        json.insert("total".to_string(), serde_json::json!(self.total));
+        for (name, rows) in self.sections() {
+            json.insert(
+                name.to_string(),
+                rows.iter()
+                    .map(|(key, row)| {
+                        serde_json::json!({
+                            "key": key,
+                            "videos": row.videos,
+                            "size": row.size,
+                            "duration": row.duration,
+                        })
+                    })
+                    .collect(),
+            );
+        }
+        Value::Object(json)
+    }
+}
+
+/// Format a duration in seconds as hours, which is easier to grasp for long durations.
+fn format_hours(seconds: i64) -> String {
+    format!("{:.1}h", seconds as f64 / 3600.0)
+}
+
+impl Stats {
+    pub fn run(&self) -> Result<()> {
+        let history = read_history()?;
+        if history.is_empty() {
+            bail!("No downloads recorded yet")
+        }
+        let report = StatsReport::new(&history);
+
+        if let Some(csv) = &self.csv {
+            fs::write(csv, report.to_csv())?;
+            return Ok(());
+        }
+        if self.json {
+            println!("{}", report.to_json());
+            return Ok(());
+        }
+
+        println!(
+            "Total: {} video(s), {}, {}",
+            report.total.videos,
+            format_file_size(report.total.size),
+            format_hours(report.total.duration)
+        );
+        for (name, rows) in report.sections() {
+            println!("\nBy {}:", name);
+            let limit = if name == "series" {
+                self.limit
+            } else {
+                usize::MAX
+            };
+            for (key, row) in rows.iter().take(limit) {
+                println!(
+                    "  {:<40} {:>6} video(s) {:>12} {:>9}",
+                    key,
+                    row.videos,
+                    format_file_size(row.size),
+                    format_hours(row.duration)
+                )
+            }
+        }
+
+        Ok(())
+    }
+}
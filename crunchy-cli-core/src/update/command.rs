@@ -0,0 +1,165 @@
+use crate::utils::update::latest_release;
+use anyhow::{bail, Result};
+use base64::engine::general_purpose::STANDARD;
+use base64::Engine;
+use ed25519_dalek::{Signature, VerifyingKey};
+use log::info;
+use reqwest::Client;
+use sha2::{Digest, Sha256};
+use std::env;
+use std::fs;
+
+/// Name of the release asset which contains the sha256 checksums of all binaries, in the format
+/// of `sha256sum` (`<checksum>  <file name>` per line).
+const CHECKSUMS_ASSET: &str = "SHA256SUMS";
+/// Name of the release asset which contains the base64 encoded ed25519 signature of
+/// [`CHECKSUMS_ASSET`].
+const CHECKSUMS_SIGNATURE_ASSET: &str = "SHA256SUMS.sig";
+
+#[derive(Debug, clap::Parser)]
+#[clap(about = "Update crunchy-cli to the latest release")]
+pub struct Update {
+    #[arg(help = "Only check if an update is available, without installing it")]
+    #[arg(long, default_value_t = false)]
+    check: bool,
+}
+
+impl Update {
+    pub async fn run(self, client: Client) -> Result<()> {
+        let release = latest_release(&client).await?;
+        if !release.is_newer() {
+            info!("crunchy-cli is up to date ({})", env!("CARGO_PKG_VERSION"));
+            return Ok(());
+        }
+        info!(
+            "A new version is available: {} (currently installed is {})",
+            release.version(),
+            env!("CARGO_PKG_VERSION")
+        );
+        if self.check {
+            return Ok(());
+        }
+
+        let public_key = env!("UPDATE_PUBLIC_KEY");
+        if public_key.is_empty() {
+            bail!(
+                "This build of crunchy-cli can't update itself. Please update it the way you installed it or download the new version from {}",
+                release.html_url
+            )
+        }
+
+        let binary_name = binary_asset_name(&release.tag_name);
+        let Some(binary_asset) = release.asset(&binary_name) else {
+            bail!(
+                "The release contains no binary for this platform ('{}'). Please download it manually from {}",
+                binary_name,
+                release.html_url
+            )
+        };
+        let (Some(checksums_asset), Some(signature_asset)) = (
+            release.asset(CHECKSUMS_ASSET),
+            release.asset(CHECKSUMS_SIGNATURE_ASSET),
+        ) else {
+            bail!("The release contains no signed checksums, refusing to update")
+        };
+
+        let checksums = download(&client, &checksums_asset.browser_download_url).await?;
+        let signature = download(&client, &signature_asset.browser_download_url).await?;
+        verify_signature(public_key, &checksums, &signature)?;
+        let Some(expected_checksum) = String::from_utf8_lossy(&checksums).lines().find_map(|l| {
+            let (checksum, name) = l.split_once(char::is_whitespace)?;
+            (name.trim_start_matches([' ', '*']) == binary_name).then(|| checksum.to_lowercase())
+        }) else {
+            bail!(
+                "The signed checksums contain no entry for '{}'",
+                binary_name
+            )
+        };
+
+        info!("Downloading {}", binary_name);
+        let binary = download(&client, &binary_asset.browser_download_url).await?;
+        let checksum: String = Sha256::digest(&binary)
+            .iter()
+            .map(|b| format!("{:02x}", b))
+            .collect();
+        if checksum != expected_checksum {
+            bail!(
+                "The checksum of the downloaded binary doesn't match ({} instead of {}), refusing to update",
+                checksum,
+                expected_checksum
+            )
+        }
+
+        replace_executable(&binary)?;
+        info!("Updated crunchy-cli to {}", release.version());
+
+        Ok(())
+    }
+}
+
+/// Name of the release binary for the current platform, e.g.
+/// `crunchy-cli-v3.6.6-linux-x86_64` or `crunchy-cli-v3.6.6-windows-x86_64.exe`.
+fn binary_asset_name(tag: &str) -> String {
+    let os = match env::consts::OS {
+        "macos" => "darwin",
+        os => os,
+    };
+    format!(
+        "crunchy-cli-{}-{}-{}{}",
+        tag,
+        os,
+        env::consts::ARCH,
+        env::consts::EXE_SUFFIX
+    )
+}
+
+async fn download(client: &Client, url: &str) -> Result<Vec<u8>> {
+    Ok(client
+        .get(url)
+        .send()
+        .await?
+        .error_for_status()?
+        .bytes()
+        .await?
+        .to_vec())
+}
+
+fn verify_signature(public_key: &str, message: &[u8], signature: &[u8]) -> Result<()> {
+    let Ok(public_key) = <[u8; 32]>::try_from(STANDARD.decode(public_key)?) else {
+        bail!("The public key of this build is invalid")
+    };
+    let public_key = VerifyingKey::from_bytes(&public_key)?;
+    let signature =
+        Signature::from_slice(&STANDARD.decode(String::from_utf8_lossy(signature).trim())?)?;
+
+    if public_key.verify_strict(message, &signature).is_err() {
+        bail!("The signature of the release checksums is invalid, refusing to update")
+    }
+    Ok(())
+}
+
+/// Replace the running executable. The new binary is written next to it first, so that the
+/// executable is never left half written. Windows doesn't allow to overwrite a running executable
+/// but to rename it, so the old one is moved out of the way there.
+fn replace_executable(binary: &[u8]) -> Result<()> {
+    let current_exe = env::current_exe()?;
+    let mut new_exe = current_exe.clone().into_os_string();
+    new_exe.push(".new");
+    fs::write(&new_exe, binary)?;
+
+    #[cfg(not(target_os = "windows"))]
+    {
+        use std::os::unix::fs::PermissionsExt;
+        fs::set_permissions(&new_exe, fs::Permissions::from_mode(0o755))?;
+    }
+    #[cfg(target_os = "windows")]
+    {
+        let mut old_exe = current_exe.clone().into_os_string();
+        old_exe.push(".old");
+        let _ = fs::remove_file(&old_exe);
+        fs::rename(&current_exe, &old_exe)?;
+    }
+
+    fs::rename(&new_exe, &current_exe)?;
+    Ok(())
+}
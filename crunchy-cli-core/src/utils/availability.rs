@@ -0,0 +1,51 @@
+use anyhow::Result;
+use chrono::{DateTime, Duration, Utc};
+use crunchyroll_rs::MediaCollection;
+use log::debug;
+
+/// If `availability_ends` is an actually announced removal date. Episodes which aren't leaving the
+/// catalog have a placeholder date which is thousands of years in the future.
+pub fn is_removal_announced(availability_ends: &DateTime<Utc>) -> bool {
+    *availability_ends < Utc::now() + Duration::days(365 * 100)
+}
+
+/// Get the date at which the first episode of `media_collection` leaves the catalog, if any
+/// removal is announced. Series require to fetch all seasons and their episodes, so this should
+/// only be called if the date is actually used.
+pub async fn leaving_at(media_collection: &MediaCollection) -> Result<Option<DateTime<Utc>>> {
+    let episodes = match media_collection {
+        MediaCollection::Series(series) => {
+            let mut episodes = vec![];
+            for season in series.seasons().await? {
+                episodes.extend(season.episodes().await?)
+            }
+            episodes
+        }
+        MediaCollection::Season(season) => season.episodes().await?,
+        MediaCollection::Episode(episode) => vec![episode.clone()],
+        _ => return Ok(None),
+    };
+
+    Ok(episodes
+        .into_iter()
+        .map(|episode| episode.availability_ends)
+        .filter(is_removal_announced)
+        .min())
+}
+
+/// Sort `parsed_urls` so that the ones whose videos leave the catalog first come first. Urls
+/// without an announced removal keep their order and are placed after all others.
+pub async fn sort_by_leaving_at<T>(
+    parsed_urls: Vec<(MediaCollection, T)>,
+) -> Result<Vec<(MediaCollection, T)>> {
+    let mut sortable = vec![];
+    for (i, (media_collection, url_filter)) in parsed_urls.into_iter().enumerate() {
+        let leaving_at = leaving_at(&media_collection).await?;
+        if let Some(leaving_at) = &leaving_at {
+            debug!("Url {} leaves the catalog on {}", i + 1, leaving_at)
+        }
+        sortable.push((leaving_at, (media_collection, url_filter)))
+    }
+    sortable.sort_by_key(|(leaving_at, _)| leaving_at.unwrap_or(DateTime::<Utc>::MAX_UTC));
+    Ok(sortable.into_iter().map(|(_, parsed)| parsed).collect())
+}
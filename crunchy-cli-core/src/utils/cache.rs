@@ -0,0 +1,137 @@
+use crate::utils::os::{cache_dir, write_atomic};
+use anyhow::Result;
+use log::debug;
+use std::collections::HashMap;
+use std::fs;
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex, OnceLock};
+use std::time::{Duration, Instant, SystemTime};
+
+static CACHE_OPTIONS: OnceLock<CacheOptions> = OnceLock::new();
+static CACHES: OnceLock<Mutex<HashMap<String, Arc<dyn CacheBackend>>>> = OnceLock::new();
+
+/// Storage for cached data (e.g. subtitles). Entries which are older than the ttl of the cache are
+/// treated as non-existent.
+pub trait CacheBackend: Send + Sync {
+    fn get(&self, key: &str) -> Option<Vec<u8>>;
+    fn set(&self, key: &str, data: &[u8]) -> Result<()>;
+    fn invalidate(&self, key: &str) -> Result<()>;
+}
+
+/// Cache which lives only as long as the process.
+pub struct MemoryCache {
+    entries: Mutex<HashMap<String, (Instant, Vec<u8>)>>,
+    ttl: Option<Duration>,
+}
+
+impl MemoryCache {
+    pub fn new(ttl: Option<Duration>) -> Self {
+        Self {
+            entries: Mutex::new(HashMap::new()),
+            ttl,
+        }
+    }
+}
+
+impl CacheBackend for MemoryCache {
+    fn get(&self, key: &str) -> Option<Vec<u8>> {
+        let mut entries = self.entries.lock().unwrap();
+        let (created, data) = entries.get(key)?;
+        if self.ttl.is_some_and(|ttl| created.elapsed() > ttl) {
+            entries.remove(key);
+            return None;
+        }
+        Some(data.clone())
+    }
+
+    fn set(&self, key: &str, data: &[u8]) -> Result<()> {
+        self.entries
+            .lock()
+            .unwrap()
+            .insert(key.to_string(), (Instant::now(), data.to_vec()));
+        Ok(())
+    }
+
+    fn invalidate(&self, key: &str) -> Result<()> {
+        self.entries.lock().unwrap().remove(key);
+        Ok(())
+    }
+}
+
+/// Cache which stores every entry as file in a directory, so it can be shared by multiple
+/// processes. The age of an entry is determined by the modification time of its file.
+pub struct DiskCache {
+    dir: PathBuf,
+    ttl: Option<Duration>,
+}
+
+impl DiskCache {
+    pub fn new(dir: PathBuf, ttl: Option<Duration>) -> Self {
+        Self { dir, ttl }
+    }
+}
+
+impl CacheBackend for DiskCache {
+    fn get(&self, key: &str) -> Option<Vec<u8>> {
+        let file = self.dir.join(key);
+        if let Some(ttl) = self.ttl {
+            let modified = fs::metadata(&file).and_then(|m| m.modified()).ok()?;
+            if SystemTime::now()
+                .duration_since(modified)
+                .is_ok_and(|age| age > ttl)
+            {
+                debug!("Cache entry {} expired", file.to_string_lossy());
+                let _ = fs::remove_file(&file);
+                return None;
+            }
+        }
+        fs::read(file).ok()
+    }
+
+    fn set(&self, key: &str, data: &[u8]) -> Result<()> {
+        Ok(write_atomic(self.dir.join(key), data)?)
+    }
+
+    fn invalidate(&self, key: &str) -> Result<()> {
+        let file = self.dir.join(key);
+        if file.exists() {
+            fs::remove_file(file)?
+        }
+        Ok(())
+    }
+}
+
+#[derive(Clone, Debug, Default)]
+pub struct CacheOptions {
+    /// Directory in which the caches are stored. Defaults to the temp directory.
+    pub dir: Option<PathBuf>,
+    pub ttl: Option<Duration>,
+    /// Keep cached data only in memory instead of on disk.
+    pub memory: bool,
+}
+
+pub fn set_cache_options(options: CacheOptions) {
+    let _ = CACHE_OPTIONS.set(options);
+}
+
+pub fn cache_options() -> &'static CacheOptions {
+    CACHE_OPTIONS.get_or_init(CacheOptions::default)
+}
+
+/// Get the cache with the given name. The backend is chosen by the options set via
+/// [`set_cache_options`].
+pub fn cache(name: &str) -> Result<Arc<dyn CacheBackend>> {
+    let mut caches = CACHES.get_or_init(Default::default).lock().unwrap();
+    if let Some(cache) = caches.get(name) {
+        return Ok(cache.clone());
+    }
+
+    let options = cache_options();
+    let cache: Arc<dyn CacheBackend> = if options.memory {
+        Arc::new(MemoryCache::new(options.ttl))
+    } else {
+        Arc::new(DiskCache::new(cache_dir(name)?, options.ttl))
+    };
+    caches.insert(name.to_string(), cache.clone());
+    Ok(cache)
+}
@@ -1,7 +1,11 @@
 use crate::utils::parse::parse_resolution;
 use crunchyroll_rs::media::Resolution;
 use regex::Regex;
+use reqwest::header::{HeaderName, HeaderValue};
 use reqwest::Proxy;
+use std::net::IpAddr;
+use std::str::FromStr;
+use std::time::Duration;
 
 pub fn clap_parse_resolution(s: &str) -> Result<Resolution, String> {
     parse_resolution(s.to_string()).map_err(|e| e.to_string())
@@ -43,6 +47,16 @@ pub fn clap_parse_proxies(s: &str) -> Result<(Option<Proxy>, Option<Proxy>), Str
     }
 }
 
+pub fn clap_parse_bitrate(s: &str) -> Result<String, String> {
+    let bitrate_regex = Regex::new(r"^\d+(\.\d+)?[kKmM]?$").unwrap();
+
+    if bitrate_regex.is_match(s) {
+        Ok(s.to_string())
+    } else {
+        Err("Invalid bitrate. Must be in format of <number>[k|M]".to_string())
+    }
+}
+
 pub fn clap_parse_speed_limit(s: &str) -> Result<u32, String> {
     let quota = s.to_lowercase();
 
@@ -59,3 +73,202 @@ pub fn clap_parse_speed_limit(s: &str) -> Result<u32, String> {
     };
     Ok(bytes)
 }
+
+/// ffmpeg decrypts all tracks with the same key, so a kid can't be used to pick a key per track
+/// and is rejected instead of being silently ignored.
+pub fn clap_parse_decryption_key(s: &str) -> Result<String, String> {
+    let key_regex = Regex::new(r"^[0-9a-fA-F]{32}$").unwrap();
+
+    if key_regex.is_match(s) {
+        Ok(s.to_lowercase())
+    } else if s.contains(':') {
+        Err("Invalid decryption key. Only the key is supported, not <kid>:<key>".to_string())
+    } else {
+        Err("Invalid decryption key. Must be 32 hex characters".to_string())
+    }
+}
+
+pub fn clap_parse_limit(s: &str) -> Result<u32, String> {
+    if s.to_lowercase() == "all" {
+        return Ok(u32::MAX);
+    }
+    s.parse()
+        .map_err(|_| "Invalid limit. Must be a number or 'all'".to_string())
+}
+
+pub fn clap_parse_request_rate(s: &str) -> Result<f64, String> {
+    let (count, per) = s.split_once('/').unwrap_or((s, "s"));
+    let Ok(count) = count.parse::<f64>() else {
+        return Err("Invalid request rate. Must be in format of <number>[/s|/m]".to_string());
+    };
+    // `parse` also accepts 'NaN' and 'inf', which aren't usable as rate
+    if !count.is_finite() || count <= 0.0 {
+        return Err("Request rate must be a number greater than 0".to_string());
+    }
+    let rate = match per {
+        "s" => count,
+        "m" => count / 60.0,
+        _ => return Err("Invalid request rate. Must be in format of <number>[/s|/m]".to_string()),
+    };
+    // the interval between two requests must fit into a duration
+    if Duration::try_from_secs_f64(1.0 / rate).is_err() {
+        return Err("Request rate is too small".to_string());
+    }
+    Ok(rate)
+}
+
+pub fn clap_parse_file_size(s: &str) -> Result<u64, String> {
+    let size = s.to_lowercase();
+
+    let bytes = if let Ok(b) = size.parse() {
+        b
+    } else if let Ok(b) = size.trim_end_matches('b').parse::<u64>() {
+        b
+    } else if let Ok(kb) = size.trim_end_matches("kb").parse::<u64>() {
+        kb * 1024
+    } else if let Ok(mb) = size.trim_end_matches("mb").parse::<u64>() {
+        mb * 1024 * 1024
+    } else if let Ok(gb) = size.trim_end_matches("gb").parse::<u64>() {
+        gb * 1024 * 1024 * 1024
+    } else {
+        return Err("Invalid file size".to_string());
+    };
+    Ok(bytes)
+}
+
+pub fn clap_parse_duration(s: &str) -> Result<chrono::Duration, String> {
+    let duration_regex =
+        Regex::new(r"^((?P<hours>\d+)h)?((?P<minutes>\d+)m)?((?P<seconds>\d+)s)?$").unwrap();
+
+    let Some(captures) = duration_regex
+        .captures(&s.to_lowercase())
+        .filter(|_| !s.is_empty())
+    else {
+        return Err(
+            "Invalid duration. Must be in format of <hours>h<minutes>m<seconds>s (e.g. 2h30m)"
+                .to_string(),
+        );
+    };
+    let get = |name: &str| {
+        captures
+            .name(name)
+            .map_or(0, |m| m.as_str().parse::<i64>().unwrap_or_default())
+    };
+
+    Ok(chrono::Duration::hours(get("hours"))
+        + chrono::Duration::minutes(get("minutes"))
+        + chrono::Duration::seconds(get("seconds")))
+}
+
+pub fn clap_parse_header(s: &str) -> Result<(String, String), String> {
+    match s.split_once(':') {
+        Some((name, value))
+            if HeaderName::from_str(name.trim()).is_ok()
+                && HeaderValue::from_str(value.trim()).is_ok() =>
+        {
+            Ok((name.trim().to_string(), value.trim().to_string()))
+        }
+        _ => Err("Invalid header. Must be in format of <name>:<value>".to_string()),
+    }
+}
+
+pub fn clap_parse_metadata_tag(s: &str) -> Result<(String, String), String> {
+    match s.split_once('=') {
+        Some((key, value)) if !key.trim().is_empty() => {
+            Ok((key.trim().to_string(), value.to_string()))
+        }
+        _ => Err("Invalid metadata tag. Must be in format of <key>=<value>".to_string()),
+    }
+}
+
+pub fn clap_parse_host_override(s: &str) -> Result<(String, IpAddr), String> {
+    let Some((host, ip)) = s.split_once('=') else {
+        return Err("Invalid host override. Must be in format of <host>=<ip>".to_string());
+    };
+    let ip = ip
+        .parse::<IpAddr>()
+        .map_err(|e| format!("Invalid ip address '{}': {}", ip, e))?;
+    Ok((host.to_string(), ip))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn request_rate() {
+        assert_eq!(clap_parse_request_rate("5"), Ok(5.0));
+        assert_eq!(clap_parse_request_rate("2.5/s"), Ok(2.5));
+        assert_eq!(clap_parse_request_rate("30/m"), Ok(0.5));
+        for invalid in [
+            "", "0", "-1", "NaN", "NaN/s", "inf", "inf/m", "1e-300", "5/h", "five/s",
+        ] {
+            assert!(
+                clap_parse_request_rate(invalid).is_err(),
+                "'{}' is accepted",
+                invalid
+            )
+        }
+    }
+
+    #[test]
+    fn file_size() {
+        assert_eq!(clap_parse_file_size("100"), Ok(100));
+        assert_eq!(clap_parse_file_size("100b"), Ok(100));
+        assert_eq!(clap_parse_file_size("2KB"), Ok(2 * 1024));
+        assert_eq!(clap_parse_file_size("3mb"), Ok(3 * 1024 * 1024));
+        assert_eq!(clap_parse_file_size("4gb"), Ok(4 * 1024 * 1024 * 1024));
+        assert!(clap_parse_file_size("").is_err());
+        assert!(clap_parse_file_size("1.5gb").is_err());
+        assert!(clap_parse_file_size("5tb").is_err());
+    }
+
+    #[test]
+    fn duration() {
+        assert_eq!(
+            clap_parse_duration("2h30m"),
+            Ok(chrono::Duration::minutes(150))
+        );
+        assert_eq!(
+            clap_parse_duration("1H2M3S"),
+            Ok(chrono::Duration::seconds(3723))
+        );
+        assert_eq!(
+            clap_parse_duration("90s"),
+            Ok(chrono::Duration::seconds(90))
+        );
+        assert!(clap_parse_duration("").is_err());
+        assert!(clap_parse_duration("30").is_err());
+        assert!(clap_parse_duration("30m2h").is_err());
+    }
+
+    #[test]
+    fn limit() {
+        assert_eq!(clap_parse_limit("25"), Ok(25));
+        assert_eq!(clap_parse_limit("All"), Ok(u32::MAX));
+        assert!(clap_parse_limit("-1").is_err());
+    }
+
+    #[test]
+    fn decryption_key() {
+        assert_eq!(
+            clap_parse_decryption_key("0123456789ABCDEF0123456789abcdef"),
+            Ok("0123456789abcdef0123456789abcdef".to_string())
+        );
+        assert!(clap_parse_decryption_key(
+            "0123456789abcdef0123456789abcdef:0123456789abcdef0123456789abcdef"
+        )
+        .is_err());
+        assert!(clap_parse_decryption_key("0123456789abcdef").is_err());
+    }
+
+    #[test]
+    fn header() {
+        assert_eq!(
+            clap_parse_header("X-Test: value"),
+            Ok(("X-Test".to_string(), "value".to_string()))
+        );
+        assert!(clap_parse_header("X-Test").is_err());
+        assert!(clap_parse_header("Invalid Name: value").is_err());
+    }
+}
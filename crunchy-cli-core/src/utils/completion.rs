@@ -0,0 +1,49 @@
+use crate::utils::ffmpeg::FFmpegPreset;
+use crate::utils::history::read_history;
+use clap_complete::engine::CompletionCandidate;
+use crunchyroll_rs::Locale;
+
+/// Maximal number of recently downloaded series which are suggested as url.
+const MAX_SERIES_CANDIDATES: usize = 20;
+
+pub(crate) fn locale_candidates() -> Vec<CompletionCandidate> {
+    Locale::all()
+        .into_iter()
+        .map(|l| CompletionCandidate::new(l.to_string()).help(Some(l.to_human_readable().into())))
+        .collect()
+}
+
+pub(crate) fn ffmpeg_preset_candidates() -> Vec<CompletionCandidate> {
+    FFmpegPreset::available_matches_described()
+        .into_iter()
+        .map(|(name, description)| CompletionCandidate::new(name).help(Some(description.into())))
+        .collect()
+}
+
+/// Urls of the most recently downloaded series. The completion runs before any argument is parsed,
+/// so the history is always read from the default location and `--history` is ignored.
+pub(crate) fn series_candidates() -> Vec<CompletionCandidate> {
+    let Ok(mut history) = read_history() else {
+        return vec![];
+    };
+    history.sort_by(|a, b| b.timestamp.cmp(&a.timestamp));
+
+    let mut series: Vec<(String, String)> = vec![];
+    for entry in history {
+        if entry.series_id.is_empty() || series.iter().any(|(id, _)| id == &entry.series_id) {
+            continue;
+        }
+        series.push((entry.series_id, entry.series_name));
+        if series.len() >= MAX_SERIES_CANDIDATES {
+            break;
+        }
+    }
+
+    series
+        .into_iter()
+        .map(|(id, name)| {
+            CompletionCandidate::new(format!("https://www.crunchyroll.com/series/{}", id))
+                .help(Some(name.into()))
+        })
+        .collect()
+}
@@ -0,0 +1,67 @@
+use log::{debug, warn};
+use std::fmt::{Display, Formatter};
+use std::sync::Mutex;
+
+static USED_DEPRECATIONS: Mutex<Vec<Deprecation>> = Mutex::new(vec![]);
+
+/// Known functionality which is deprecated by Crunchyroll and may stop working at any time.
+#[derive(Clone, Debug, Eq, PartialEq)]
+pub enum Deprecation {
+    /// Urls in the old (pre-beta) scheme, like `https://www.crunchyroll.com/darling-in-the-franxx`.
+    ClassicUrl,
+    /// Sessions which were stored with an `etp_rt` cookie instead of a refresh token.
+    EtpRtSession,
+}
+
+impl Display for Deprecation {
+    fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
+        let value = match self {
+            Deprecation::ClassicUrl => "classic-url",
+            Deprecation::EtpRtSession => "etp-rt-session",
+        };
+        write!(f, "{}", value)
+    }
+}
+
+impl Deprecation {
+    fn migration_hint(&self) -> &'static str {
+        match self {
+            Deprecation::ClassicUrl => "Classic Crunchyroll urls are only supported as long as Crunchyroll redirects them. Please use the url of the current website instead (e.g. 'https://www.crunchyroll.com/series/GY8VEQ95Y/darling-in-the-franxx')",
+            Deprecation::EtpRtSession => "Sessions stored via etp-rt aren't supported anymore. Please login again using your credentials",
+        }
+    }
+}
+
+/// Warns about the usage of deprecated functionality. Every deprecation is only shown once per
+/// run.
+pub fn warn_deprecated(deprecation: Deprecation) {
+    let mut used = USED_DEPRECATIONS.lock().unwrap();
+    if used.contains(&deprecation) {
+        return;
+    }
+    warn!(
+        "Deprecated ({}): {}",
+        deprecation,
+        deprecation.migration_hint()
+    );
+    used.push(deprecation)
+}
+
+/// Returns all deprecations which were used in this run.
+pub fn used_deprecations() -> Vec<Deprecation> {
+    USED_DEPRECATIONS.lock().unwrap().clone()
+}
+
+/// Prints a summary of all deprecations which were used in this run to the debug output.
+pub fn debug_deprecation_summary() {
+    let used = used_deprecations();
+    if !used.is_empty() {
+        debug!(
+            "Used deprecated functionality: {}",
+            used.iter()
+                .map(|d| d.to_string())
+                .collect::<Vec<String>>()
+                .join(", ")
+        )
+    }
+}
@@ -0,0 +1,117 @@
+use log::debug;
+use reqwest::dns::{Addrs, Name, Resolve, Resolving};
+use reqwest::{Client, ClientBuilder};
+use serde::Deserialize;
+use std::net::{IpAddr, SocketAddr};
+use std::sync::Arc;
+
+/// Dns record types as specified in RFC 1035 / RFC 3596.
+const RECORD_TYPE_A: u16 = 1;
+const RECORD_TYPE_AAAA: u16 = 28;
+
+#[derive(Deserialize)]
+struct DohResponse {
+    #[serde(rename = "Answer", default)]
+    answer: Vec<DohAnswer>,
+}
+
+#[derive(Deserialize)]
+struct DohAnswer {
+    #[serde(rename = "type")]
+    record_type: u16,
+    data: String,
+}
+
+/// Resolves hostnames via DNS-over-HTTPS, using the json api which is supported by most public
+/// DoH providers (e.g. https://cloudflare-dns.com/dns-query or https://dns.google/resolve).
+#[derive(Clone, Debug)]
+pub struct DohResolver {
+    url: String,
+    // the client which requests the DoH server must use the system resolver, else resolving the
+    // DoH server itself would end up in an endless recursion
+    client: Client,
+}
+
+impl DohResolver {
+    pub fn new(url: String) -> Self {
+        Self {
+            url,
+            client: Client::new(),
+        }
+    }
+
+    async fn lookup(
+        client: Client,
+        url: String,
+        name: String,
+    ) -> Result<Vec<SocketAddr>, Box<dyn std::error::Error + Send + Sync>> {
+        let mut addrs = vec![];
+        for record_type in [RECORD_TYPE_A, RECORD_TYPE_AAAA] {
+            let response: DohResponse = client
+                .get(&url)
+                .query(&[("name", name.as_str()), ("type", &record_type.to_string())])
+                .header("Accept", "application/dns-json")
+                .send()
+                .await?
+                .error_for_status()?
+                .json()
+                .await?;
+            addrs.extend(
+                response
+                    .answer
+                    .into_iter()
+                    .filter(|a| a.record_type == record_type)
+                    .filter_map(|a| a.data.parse::<IpAddr>().ok())
+                    // the port is overwritten by the http client with the port of the requested url
+                    .map(|ip| SocketAddr::new(ip, 0)),
+            )
+        }
+
+        if addrs.is_empty() {
+            return Err(format!("DoH server returned no address for {}", name).into());
+        }
+        debug!("Resolved {} via DoH to {:?}", name, addrs);
+
+        Ok(addrs)
+    }
+}
+
+impl Resolve for DohResolver {
+    fn resolve(&self, name: Name) -> Resolving {
+        let client = self.client.clone();
+        let url = self.url.clone();
+        let name = name.as_str().to_string();
+
+        Box::pin(async move {
+            let addrs = Self::lookup(client, url, name).await?;
+            Ok(Box::new(addrs.into_iter()) as Addrs)
+        })
+    }
+}
+
+/// Dns settings which are applied to every http client.
+#[derive(Clone, Debug, Default)]
+pub struct DnsOptions {
+    doh: Option<Arc<DohResolver>>,
+    hosts: Vec<(String, IpAddr)>,
+}
+
+impl DnsOptions {
+    pub fn new(doh: Option<String>, hosts: Vec<(String, IpAddr)>) -> Self {
+        Self {
+            doh: doh.map(|url| Arc::new(DohResolver::new(url))),
+            hosts,
+        }
+    }
+
+    pub fn apply(&self, mut builder: ClientBuilder) -> ClientBuilder {
+        if let Some(doh) = &self.doh {
+            builder = builder.dns_resolver(doh.clone())
+        }
+        for (host, ip) in &self.hosts {
+            // the port is ignored by reqwest, the default port of the url scheme is used instead
+            builder = builder.resolve(host, SocketAddr::new(*ip, 0))
+        }
+        builder
+    }
+}
@@ -1,18 +1,32 @@
-use crate::utils::ffmpeg::FFmpegPreset;
+use crate::utils::cache::cache;
+use crate::utils::ffmpeg::{FFmpegPreset, MuxOption};
 use crate::utils::filter::real_dedup_vec;
-use crate::utils::fmt::format_time_delta;
-use crate::utils::log::progress;
+use crate::utils::fmt::{format_file_size, format_time_delta};
+use crate::utils::format::SingleFormat;
+use crate::utils::i18n::tr;
+use crate::utils::log::{event, progress};
+use crate::utils::maintenance::{ServiceUnavailable, MAX_UNAVAILABLE_WAIT};
 use crate::utils::os::{cache_dir, is_special_file, temp_directory, temp_named_pipe, tempfile};
+use crate::utils::progress::{Progress, ProgressUnit};
 use crate::utils::rate_limit::RateLimiterService;
+use crate::utils::resume::{resume_dir, ResumeFile, StreamPath};
+use crate::utils::signal::{
+    detach_from_signals, detached_output, register_detached_process, unregister_detached_process,
+};
 use crate::utils::sync::{sync_audios, SyncAudio};
+use crate::utils::theme::{paint, Role};
+use crate::utils::video::{is_expired_signature, stream_data_expiry};
+use crate::utils::write::WriteOptions;
 use anyhow::{bail, Result};
 use chrono::{NaiveTime, TimeDelta};
 use crunchyroll_rs::media::{SkipEvents, SkipEventsEvent, StreamData, StreamSegment, Subtitle};
 use crunchyroll_rs::Locale;
-use indicatif::{ProgressBar, ProgressDrawTarget, ProgressFinish, ProgressStyle};
+use futures_util::StreamExt;
+use indicatif::{ProgressBar, ProgressFinish, ProgressStyle};
 use log::{debug, warn, LevelFilter};
 use regex::Regex;
-use reqwest::Client;
+use reqwest::header::RANGE;
+use reqwest::{Client, Response, StatusCode};
 use rsubs_lib::{SSA, VTT};
 use std::borrow::Borrow;
 use std::cmp::Ordering;
@@ -22,7 +36,7 @@ use std::ops::Add;
 use std::path::{Path, PathBuf};
 use std::process::{Command, Stdio};
 use std::sync::Arc;
-use std::time::Duration;
+use std::time::{Duration, Instant};
 use std::{env, fs};
 use tempfile::TempPath;
 use time::Time;
@@ -54,24 +68,134 @@ impl MergeBehavior {
     }
 }
 
+#[derive(Clone, Debug, clap::Parser)]
+pub struct SegmentTimeouts {
+    #[arg(help = "Maximal time to download a single segment before it's retried, e.g. 1m")]
+    #[arg(
+        long_help = "Maximal time to download a single segment before it's retried, e.g. 1m. \
+    Must be in format of <hours>h<minutes>m<seconds>s"
+    )]
+    #[arg(long, default_value = "60s", value_parser = crate::utils::clap::clap_parse_duration)]
+    pub(crate) segment_timeout: TimeDelta,
+    #[arg(help = "Minimal download speed of a segment. Must be in format of <number>[B|KB|MB]")]
+    #[arg(
+        long_help = "Minimal download speed of a segment. Must be in format of <number>[B|KB|MB] (e.g. 50KB). \
+    If a segment is downloaded slower than this (or receives no data at all) for `--stall-timeout`, the connection is considered stalled and the segment is retried. \
+    By default, only `--segment-timeout` aborts slow segments"
+    )]
+    #[arg(long, value_parser = crate::utils::clap::clap_parse_speed_limit)]
+    pub(crate) stall_speed: Option<u32>,
+    #[arg(
+        help = "How long a segment may be downloaded slower than `--stall-speed` before it's retried, e.g. 10s"
+    )]
+    #[arg(long, default_value = "10s", value_parser = crate::utils::clap::clap_parse_duration)]
+    pub(crate) stall_timeout: TimeDelta,
+    #[arg(help = "How often a segment is retried before the download fails")]
+    #[arg(long, default_value_t = 5)]
+    pub(crate) segment_retries: u32,
+}
+
+impl Default for SegmentTimeouts {
+    fn default() -> Self {
+        Self {
+            segment_timeout: TimeDelta::seconds(60),
+            stall_speed: None,
+            stall_timeout: TimeDelta::seconds(10),
+            segment_retries: 5,
+        }
+    }
+}
+
+impl SegmentTimeouts {
+    pub(crate) fn check(&self) -> Result<()> {
+        if self.segment_timeout <= TimeDelta::zero() {
+            bail!("`--segment-timeout` must be greater than 0")
+        }
+        if self.stall_timeout <= TimeDelta::zero() {
+            bail!("`--stall-timeout` must be greater than 0")
+        }
+        Ok(())
+    }
+
+    /// Read the body of a segment response into `buf`. Data which was received before an error
+    /// stays in `buf`, so it can be resumed with a range request. If a stall speed is set, the
+    /// throughput is measured in windows of the stall timeout and the read is aborted if it drops
+    /// below the stall speed, so that a single stalled connection can be retried instead of hanging
+    /// the whole download.
+    async fn read_segment(&self, mut response: Response, buf: &mut Vec<u8>) -> Result<()> {
+        // only a partial response continues the already received data, servers which don't
+        // support range requests send the whole segment again
+        if response.status() != StatusCode::PARTIAL_CONTENT {
+            buf.clear()
+        }
+        let stall_timeout = self.stall_timeout.to_std()?;
+
+        let mut window_start = Instant::now();
+        let mut window_bytes = 0;
+        loop {
+            let chunk = if self.stall_speed.is_some() {
+                let Ok(chunk) = tokio::time::timeout(stall_timeout, response.chunk()).await else {
+                    bail!(
+                        "segment stalled, no data received for {}",
+                        format_time_delta(&self.stall_timeout)
+                    )
+                };
+                chunk?
+            } else {
+                response.chunk().await?
+            };
+            let Some(chunk) = chunk else {
+                break;
+            };
+            window_bytes += chunk.len() as u64;
+            buf.extend_from_slice(&chunk);
+
+            let Some(stall_speed) = self.stall_speed else {
+                continue;
+            };
+            let elapsed = window_start.elapsed();
+            if elapsed >= stall_timeout {
+                let speed = (window_bytes as f64 / elapsed.as_secs_f64()) as u64;
+                if speed < stall_speed as u64 {
+                    bail!(
+                        "segment stalled, download speed dropped to {}/s",
+                        format_file_size(speed)
+                    )
+                }
+                window_start = Instant::now();
+                window_bytes = 0;
+            }
+        }
+        Ok(())
+    }
+}
+
 #[derive(Clone, derive_setters::Setters)]
 pub struct DownloadBuilder {
     client: Client,
     rate_limiter: Option<RateLimiterService>,
     ffmpeg_preset: FFmpegPreset,
     default_subtitle: Option<Locale>,
+    default_audio: Option<Locale>,
     output_format: Option<String>,
     audio_sort: Option<Vec<Locale>>,
     subtitle_sort: Option<Vec<Locale>>,
     force_hardsub: bool,
+    hardsub_crf: Option<u8>,
+    hardsub_bitrate: Option<String>,
     download_fonts: bool,
     no_closed_caption: bool,
     merge_sync_tolerance: Option<u32>,
     merge_sync_precision: Option<u32>,
     threads: usize,
+    segment_timeouts: SegmentTimeouts,
+    write_options: WriteOptions,
     ffmpeg_threads: Option<usize>,
+    decryption_key: Option<String>,
+    mux_options: Vec<MuxOption>,
     audio_locale_output_map: HashMap<Locale, String>,
     subtitle_locale_output_map: HashMap<Locale, String>,
+    metadata_tags: Vec<(String, String)>,
 }
 
 impl DownloadBuilder {
@@ -81,18 +205,26 @@ impl DownloadBuilder {
             rate_limiter,
             ffmpeg_preset: FFmpegPreset::default(),
             default_subtitle: None,
+            default_audio: None,
             output_format: None,
             audio_sort: None,
             subtitle_sort: None,
             force_hardsub: false,
+            hardsub_crf: None,
+            hardsub_bitrate: None,
             download_fonts: false,
             no_closed_caption: false,
             merge_sync_tolerance: None,
             merge_sync_precision: None,
             threads: num_cpus::get(),
+            segment_timeouts: SegmentTimeouts::default(),
+            write_options: WriteOptions::default(),
             ffmpeg_threads: None,
+            decryption_key: None,
+            mux_options: vec![],
             audio_locale_output_map: HashMap::new(),
             subtitle_locale_output_map: HashMap::new(),
+            metadata_tags: vec![],
         }
     }
 
@@ -102,11 +234,14 @@ impl DownloadBuilder {
             rate_limiter: self.rate_limiter,
             ffmpeg_preset: self.ffmpeg_preset,
             default_subtitle: self.default_subtitle,
+            default_audio: self.default_audio,
             output_format: self.output_format,
             audio_sort: self.audio_sort,
             subtitle_sort: self.subtitle_sort,
 
             force_hardsub: self.force_hardsub,
+            hardsub_crf: self.hardsub_crf,
+            hardsub_bitrate: self.hardsub_bitrate,
             download_fonts: self.download_fonts,
             no_closed_caption: self.no_closed_caption,
 
@@ -114,24 +249,31 @@ impl DownloadBuilder {
             merge_sync_precision: self.merge_sync_precision,
 
             download_threads: self.threads,
+            segment_timeouts: self.segment_timeouts,
+            write_options: self.write_options,
             ffmpeg_threads: self.ffmpeg_threads,
+            decryption_key: self.decryption_key,
+
+            mux_options: self.mux_options,
 
             formats: vec![],
 
             audio_locale_output_map: self.audio_locale_output_map,
             subtitle_locale_output_map: self.subtitle_locale_output_map,
+
+            metadata_tags: self.metadata_tags,
         }
     }
 }
 
 struct FFmpegVideoMeta {
-    path: TempPath,
+    path: StreamPath,
     length: TimeDelta,
     start_time: Option<TimeDelta>,
 }
 
 struct FFmpegAudioMeta {
-    path: TempPath,
+    path: StreamPath,
     locale: Locale,
     start_time: Option<TimeDelta>,
     video_idx: usize,
@@ -148,10 +290,20 @@ struct FFmpegSubtitleMeta {
 pub struct DownloadFormat {
     pub video: (StreamData, Locale),
     pub audios: Vec<(StreamData, Locale)>,
-    pub subtitles: Vec<(Subtitle, bool)>,
+    /// Subtitles, if they're closed captions and the id of the episode they belong to.
+    pub subtitles: Vec<(Subtitle, bool, String)>,
     pub metadata: DownloadFormatMetadata,
 }
 
+impl DownloadFormat {
+    /// Estimate the size of the output from the bandwidth of the streams, before anything is
+    /// downloaded.
+    pub fn estimated_size(&self, duration: TimeDelta) -> u64 {
+        (self.video.0.bandwidth + self.audios.iter().map(|(a, _)| a.bandwidth).sum::<u64>()) / 8
+            * duration.num_seconds() as u64
+    }
+}
+
 pub struct DownloadFormatMetadata {
     pub skip_events: Option<SkipEvents>,
 }
@@ -162,11 +314,14 @@ pub struct Downloader {
 
     ffmpeg_preset: FFmpegPreset,
     default_subtitle: Option<Locale>,
+    default_audio: Option<Locale>,
     output_format: Option<String>,
     audio_sort: Option<Vec<Locale>>,
     subtitle_sort: Option<Vec<Locale>>,
 
     force_hardsub: bool,
+    hardsub_crf: Option<u8>,
+    hardsub_bitrate: Option<String>,
     download_fonts: bool,
     no_closed_caption: bool,
 
@@ -174,12 +329,19 @@ pub struct Downloader {
     merge_sync_precision: Option<u32>,
 
     download_threads: usize,
+    segment_timeouts: SegmentTimeouts,
+    write_options: WriteOptions,
     ffmpeg_threads: Option<usize>,
+    decryption_key: Option<String>,
+
+    mux_options: Vec<MuxOption>,
 
     formats: Vec<DownloadFormat>,
 
     audio_locale_output_map: HashMap<Locale, String>,
     subtitle_locale_output_map: HashMap<Locale, String>,
+
+    metadata_tags: Vec<(String, String)>,
 }
 
 impl Downloader {
@@ -214,6 +376,11 @@ impl Downloader {
             )
         }
 
+        // part files can only be stored next to real files
+        let resume_dir =
+            (self.write_options.resume && !is_special_file(dst) && dst.to_string_lossy() != "-")
+                .then(|| resume_dir(dst));
+
         if let Some(audio_sort_locales) = &self.audio_sort {
             self.formats.sort_by(|a, b| {
                 audio_sort_locales
@@ -234,7 +401,7 @@ impl Downloader {
             if let Some(subtitle_sort) = &self.subtitle_sort {
                 format
                     .subtitles
-                    .sort_by(|(a_subtitle, a_cc), (b_subtitle, b_cc)| {
+                    .sort_by(|(a_subtitle, a_cc, _), (b_subtitle, b_cc, _)| {
                         let ordering = subtitle_sort
                             .iter()
                             .position(|l| l == &a_subtitle.locale)
@@ -259,14 +426,23 @@ impl Downloader {
         let mut chapters = None;
         let mut max_len = TimeDelta::min_value();
         let mut max_frames = 0;
+        // the labels are translated, so every label must be considered to align them
         let fmt_space = self
             .formats
             .iter()
-            .flat_map(|f| {
+            .enumerate()
+            .flat_map(|(i, f)| {
                 f.audios
                     .iter()
-                    .map(|(_, locale)| format!("Downloading {} audio", locale).len())
+                    .map(|(_, locale)| tr!("Downloading {0} audio", locale))
+                    .chain([tr!("Downloading video #{0}", i + 1)])
             })
+            .chain([
+                tr!("Downloading subtitles"),
+                tr!("Downloading fonts"),
+                tr!("Generating output file"),
+            ])
+            .map(|label| label.chars().count())
             .max()
             .unwrap();
 
@@ -276,7 +452,10 @@ impl Downloader {
                 let path = self
                     .download_audio(
                         stream_data,
-                        format!("{:<1$}", format!("Downloading {} audio", locale), fmt_space),
+                        format!("{:<1$}", tr!("Downloading {0} audio", locale), fmt_space),
+                        resume_dir
+                            .as_ref()
+                            .map(|dir| dir.join(format!("audio{}_{}.m4a", i, locale))),
                     )
                     .await?;
                 raw_audios.push(SyncAudio {
@@ -290,8 +469,10 @@ impl Downloader {
         }
 
         if self.formats.len() > 1 && self.merge_sync_tolerance.is_some() {
-            let _progress_handler =
-                progress!("Syncing video start times (this might take some time)");
+            let _progress_handler = progress!(
+                "{}",
+                tr!("Syncing video start times (this might take some time)")
+            );
             let mut offsets = sync_audios(
                 &raw_audios,
                 self.merge_sync_tolerance.unwrap(),
@@ -400,8 +581,11 @@ impl Downloader {
             let path = self
                 .download_video(
                     &format.video.0,
-                    format!("{:<1$}", format!("Downloading video #{}", i + 1), fmt_space),
+                    format!("{:<1$}", tr!("Downloading video #{0}", i + 1), fmt_space),
                     None,
+                    resume_dir
+                        .as_ref()
+                        .map(|dir| dir.join(format!("video{}.mp4", i))),
                 )
                 .await?;
 
@@ -434,8 +618,10 @@ impl Downloader {
                     .with_style(
                         ProgressStyle::with_template(
                             format!(
-                                ":: {:<1$}  {{msg}} {{spinner}}",
-                                "Downloading subtitles", fmt_space
+                                "{} {:<2$}  {{msg}} {{spinner}}",
+                                paint(Role::Prefix, "::"),
+                                tr!("Downloading subtitles"),
+                                fmt_space
                             )
                             .as_str(),
                         )
@@ -449,7 +635,7 @@ impl Downloader {
                 None
             };
 
-            for (j, (subtitle, cc)) in format.subtitles.iter().enumerate() {
+            for (j, (subtitle, cc, episode_id)) in format.subtitles.iter().enumerate() {
                 if *cc && self.no_closed_caption {
                     continue;
                 }
@@ -470,7 +656,12 @@ impl Downloader {
                 }
 
                 let path = self
-                    .download_subtitle(subtitle.clone(), videos[i.min(videos.len() - 1)].length)
+                    .download_subtitle(
+                        subtitle.clone(),
+                        episode_id,
+                        *cc,
+                        videos[i.min(videos.len() - 1)].length,
+                    )
                     .await?;
                 debug!(
                     "Downloaded {} subtitles{}",
@@ -520,8 +711,10 @@ impl Downloader {
                     .with_style(
                         ProgressStyle::with_template(
                             format!(
-                                ":: {:<1$}  {{msg}} {{spinner}}",
-                                "Downloading fonts", fmt_space
+                                "{} {:<2$}  {{msg}} {{spinner}}",
+                                paint(Role::Prefix, "::"),
+                                tr!("Downloading fonts"),
+                                fmt_space
                             )
                             .as_str(),
                         )
@@ -569,6 +762,9 @@ impl Downloader {
             if let Some(start_time) = meta.start_time {
                 input.extend(["-ss".to_string(), format_time_delta(&start_time)])
             }
+            if let Some(decryption_key) = &self.decryption_key {
+                input.extend(["-decryption_key".to_string(), decryption_key.clone()])
+            }
             input.extend(["-i".to_string(), meta.path.to_string_lossy().to_string()]);
             maps.extend(["-map".to_string(), i.to_string()]);
             metadata.extend([
@@ -590,6 +786,9 @@ impl Downloader {
             if let Some(start_time) = meta.start_time {
                 input.extend(["-ss".to_string(), format_time_delta(&start_time)])
             }
+            if let Some(decryption_key) = &self.decryption_key {
+                input.extend(["-decryption_key".to_string(), decryption_key.clone()])
+            }
             input.extend(["-i".to_string(), meta.path.to_string_lossy().to_string()]);
             maps.extend(["-map".to_string(), (i + videos.len()).to_string()]);
             metadata.extend([
@@ -681,6 +880,11 @@ impl Downloader {
             ])
         }
 
+        // user defined tags are set after the chapter metadata is mapped, so they take precedence
+        for (key, value) in &self.metadata_tags {
+            metadata.extend(["-metadata".to_string(), format!("{}={}", key, value)])
+        }
+
         let preset_custom = matches!(self.ffmpeg_preset, FFmpegPreset::Custom(_));
         let (input_presets, mut output_presets) = self.ffmpeg_preset.into_input_output_args();
         let fifo = temp_named_pipe()?;
@@ -702,17 +906,21 @@ impl Downloader {
             }
         }
 
+        // ffmpeg only respects the last given '-movflags' argument, so all flags are collected first
+        // and passed together
+        let mut movflags = vec![];
+
         // set default subtitle
         if let Some(default_subtitle) = self.default_subtitle {
             if let Some(position) = subtitles.iter().position(|m| m.locale == default_subtitle) {
                 if container_supports_softsubs {
                     match dst.extension().unwrap_or_default().to_str().unwrap() {
-                        "mov" | "mp4" => output_presets.extend([
-                            "-movflags".to_string(),
-                            "faststart".to_string(),
-                            "-c:s".to_string(),
-                            "mov_text".to_string(),
-                        ]),
+                        "mov" | "mp4" => {
+                            if !self.mux_options.contains(&MuxOption::Fragmented) {
+                                movflags.push("faststart")
+                            }
+                            output_presets.extend(["-c:s".to_string(), "mov_text".to_string()])
+                        }
                         _ => (),
                     }
                 } else {
@@ -731,6 +939,15 @@ impl Downloader {
                         last.clone_from(s);
                     }
 
+                    // the video has to be re-encoded when burning subtitles into it, so the quality
+                    // of the encoder can be controlled here
+                    if let Some(crf) = self.hardsub_crf {
+                        output_presets.extend(["-crf".to_string(), crf.to_string()])
+                    }
+                    if let Some(bitrate) = &self.hardsub_bitrate {
+                        output_presets.extend(["-b:v".to_string(), bitrate.clone()])
+                    }
+
                     output_presets.extend([
                         "-vf".to_string(),
                         format!(
@@ -773,6 +990,19 @@ impl Downloader {
             }
         }
 
+        // set default audio. the flag is explicitly removed from all other audio tracks as ffmpeg
+        // marks the first audio track as default otherwise
+        if let Some(default_audio) = &self.default_audio {
+            if let Some(position) = audios.iter().position(|m| &m.locale == default_audio) {
+                for i in 0..audios.len() {
+                    command_args.extend([
+                        format!("-disposition:a:{}", i),
+                        if i == position { "default" } else { "0" }.to_string(),
+                    ])
+                }
+            }
+        }
+
         // set the 'forced' flag to CC subtitles
         for (i, subtitle) in subtitles.iter().enumerate() {
             if !subtitle.cc {
@@ -782,6 +1012,31 @@ impl Downloader {
             command_args.extend([format!("-disposition:s:s:{}", i), "forced".to_string()])
         }
 
+        for mux_option in &self.mux_options {
+            match mux_option {
+                MuxOption::Faststart => movflags.push("faststart"),
+                MuxOption::Fragmented => {
+                    movflags.extend(["frag_keyframe", "empty_moov", "default_base_moof"])
+                }
+                // moves the cues (seeking index) to the front of the file. requires ffmpeg 6.0 or
+                // newer
+                MuxOption::CuesFront => {
+                    command_args.extend(["-cues_to_front".to_string(), "1".to_string()])
+                }
+            }
+        }
+        real_dedup_vec(&mut movflags);
+        if !movflags.is_empty() {
+            command_args.extend([
+                "-movflags".to_string(),
+                movflags
+                    .into_iter()
+                    .map(|f| format!("+{}", f))
+                    .collect::<Vec<String>>()
+                    .join(""),
+            ])
+        }
+
         command_args.extend(output_presets);
         if let Some(output_format) = self.output_format {
             command_args.extend(["-f".to_string(), output_format]);
@@ -808,7 +1063,7 @@ impl Downloader {
             }
         }
 
-        let ffmpeg = Command::new("ffmpeg")
+        let ffmpeg = detach_from_signals(&mut Command::new("ffmpeg"))
             // pass ffmpeg stdout to real stdout only if output file is stdout
             .stdout(if dst.to_str().unwrap() == "-" {
                 Stdio::inherit()
@@ -818,25 +1073,36 @@ impl Downloader {
             .stderr(Stdio::piped())
             .args(command_args)
             .spawn()?;
+        let ffmpeg_pid = ffmpeg.id();
+        register_detached_process(ffmpeg_pid);
         let ffmpeg_progress_cancel = CancellationToken::new();
         let ffmpeg_progress_cancellation_token = ffmpeg_progress_cancel.clone();
         let ffmpeg_progress = tokio::spawn(async move {
             ffmpeg_progress(
                 max_frames,
                 fifo,
-                format!("{:<1$}", "Generating output file", fmt_space + 1),
+                format!("{:<1$}", tr!("Generating output file"), fmt_space + 1),
                 ffmpeg_progress_cancellation_token,
             )
             .await
         });
 
-        let result = ffmpeg.wait_with_output()?;
+        let result = ffmpeg.wait_with_output();
+        unregister_detached_process(ffmpeg_pid);
+        let result = result?;
         if !result.status.success() {
             ffmpeg_progress.abort();
             bail!("{}", String::from_utf8_lossy(result.stderr.as_slice()))
         }
         ffmpeg_progress_cancel.cancel();
-        ffmpeg_progress.await?
+        ffmpeg_progress.await??;
+
+        // the output file was generated, so the part files aren't needed anymore
+        if let Some(resume_dir) = resume_dir {
+            fs::remove_dir_all(resume_dir)?
+        }
+
+        Ok(())
     }
 
     async fn check_free_space(
@@ -904,32 +1170,88 @@ impl Downloader {
         stream_data: &StreamData,
         message: String,
         max_segments: Option<usize>,
-    ) -> Result<TempPath> {
-        let tempfile = tempfile(".mp4")?;
-        let (mut file, path) = tempfile.into_parts();
+        part: Option<PathBuf>,
+    ) -> Result<StreamPath> {
+        if let Some(part) = part {
+            return self
+                .download_part(part, message, stream_data, max_segments)
+                .await;
+        }
 
-        self.download_segments(&mut file, message, stream_data, max_segments)
+        let path = tempfile(".mp4")?.into_temp_path();
+        let mut writer = self.write_options.open(&path)?;
+
+        self.download_segments(&mut writer, message, stream_data, max_segments, None)
             .await?;
+        writer.finish()?;
 
-        Ok(path)
+        Ok(StreamPath::Temp(path))
     }
 
-    async fn download_audio(&self, stream_data: &StreamData, message: String) -> Result<TempPath> {
-        let tempfile = tempfile(".m4a")?;
-        let (mut file, path) = tempfile.into_parts();
+    async fn download_audio(
+        &self,
+        stream_data: &StreamData,
+        message: String,
+        part: Option<PathBuf>,
+    ) -> Result<StreamPath> {
+        if let Some(part) = part {
+            return self.download_part(part, message, stream_data, None).await;
+        }
+
+        let path = tempfile(".m4a")?.into_temp_path();
+        let mut writer = self.write_options.open(&path)?;
 
-        self.download_segments(&mut file, message, stream_data, None)
+        self.download_segments(&mut writer, message, stream_data, None, None)
             .await?;
+        writer.finish()?;
 
-        Ok(path)
+        Ok(StreamPath::Temp(path))
+    }
+
+    /// Download a stream into the part file `part`. If the part file was already partially
+    /// downloaded before, the download continues after the last completely written segment.
+    async fn download_part(
+        &self,
+        part: PathBuf,
+        message: String,
+        stream_data: &StreamData,
+        max_segments: Option<usize>,
+    ) -> Result<StreamPath> {
+        let mut segments = stream_data.segments().len();
+        if let Some(max_segments) = max_segments {
+            segments = max_segments.min(segments - 1)
+        }
+        let mut resume = ResumeFile::load(part, segments, stream_data.bandwidth)?;
+        if resume.is_complete() {
+            debug!(
+                "{} is already downloaded completely",
+                resume.path().to_string_lossy()
+            );
+            return Ok(StreamPath::Part(resume.path().to_path_buf()));
+        }
+
+        let mut writer = self.write_options.open_at(resume.path(), resume.size())?;
+        self.download_segments(
+            &mut writer,
+            message,
+            stream_data,
+            max_segments,
+            Some(&mut resume),
+        )
+        .await?;
+        writer.finish()?;
+
+        Ok(StreamPath::Part(resume.path().to_path_buf()))
     }
 
     async fn download_subtitle(
         &self,
         subtitle: Subtitle,
+        episode_id: &str,
+        cc: bool,
         max_length: TimeDelta,
     ) -> Result<TempPath> {
-        let buf = subtitle.data().await?;
+        let buf = subtitle_data(&subtitle, episode_id, cc).await?;
         let mut ass = match subtitle.format.as_str() {
             "ass" => SSA::parse(String::from_utf8_lossy(&buf))?,
             "vtt" => VTT::parse(String::from_utf8_lossy(&buf))?.to_ssa(),
@@ -1005,34 +1327,36 @@ impl Downloader {
         message: String,
         stream_data: &StreamData,
         max_segments: Option<usize>,
+        mut resume: Option<&mut ResumeFile>,
     ) -> Result<()> {
+        if let Some(expiry) = stream_data_expiry(stream_data) {
+            if expiry < chrono::Utc::now() {
+                warn!(
+                    "The stream urls expired at {}, the download will probably fail",
+                    expiry
+                )
+            }
+        }
+
         let mut segments = stream_data.segments();
         if let Some(max_segments) = max_segments {
             segments = segments
                 .drain(0..max_segments.min(segments.len() - 1))
                 .collect();
         }
+        // segments which were already written by a previous, interrupted download
+        if let Some(resume) = &resume {
+            segments.drain(0..resume.done().min(segments.len()));
+        }
         let total_segments = segments.len();
 
         let count = Arc::new(Mutex::new(0));
 
-        let progress = if log::max_level() == LevelFilter::Info {
-            let estimated_file_size = estimate_stream_data_file_size(stream_data, &segments);
-
-            let progress = ProgressBar::new(estimated_file_size)
-                .with_style(
-                    ProgressStyle::with_template(
-                        ":: {msg} {bytes:>10} {bytes_per_sec:>12} [{wide_bar}] {percent:>3}%",
-                    )
-                    .unwrap()
-                    .progress_chars("##-"),
-                )
-                .with_message(message)
-                .with_finish(ProgressFinish::Abandon);
-            Some(progress)
-        } else {
-            None
-        };
+        let progress = Progress::new(
+            estimate_stream_data_file_size(stream_data, &segments),
+            ProgressUnit::Bytes,
+            message,
+        );
 
         let cpus = self.download_threads.min(segments.len());
         let mut segs: Vec<Vec<StreamSegment>> = Vec::with_capacity(cpus);
@@ -1045,12 +1369,22 @@ impl Downloader {
 
         let (sender, mut receiver) = unbounded_channel();
 
+        // only suggest `--resume` if the already downloaded segments are actually kept
+        let expired_hint = if resume.is_some() {
+            "Run the same command again to continue with new urls, the already downloaded segments are kept"
+        } else if self.write_options.resume {
+            "Run the same command again to continue with new urls"
+        } else {
+            "Run the same command again (with `--resume` to keep the already downloaded segments) to continue with new urls"
+        };
+
         let mut join_set: JoinSet<Result<()>> = JoinSet::new();
         for num in 0..cpus {
             let thread_sender = sender.clone();
             let thread_segments = segs.remove(0);
             let thread_client = self.client.clone();
             let mut thread_rate_limiter = self.rate_limiter.clone();
+            let thread_segment_timeouts = self.segment_timeouts.clone();
             let thread_count = count.clone();
             join_set.spawn(async move {
                 let after_download_sender = thread_sender.clone();
@@ -1061,28 +1395,66 @@ impl Downloader {
                 let download = || async move {
                     for (i, segment) in thread_segments.into_iter().enumerate() {
                         let mut retry_count = 0;
+                        // time which was waited because crunchyroll was unavailable. this doesn't
+                        // count as retry, else a maintenance would fail the download immediately
+                        let mut unavailable_wait = Duration::ZERO;
+                        // data which was received before a retry. the retry requests only the
+                        // remaining bytes, so big segments on flaky connections don't have to be
+                        // downloaded completely again
+                        let mut segment_buf = vec![];
                         let buf = loop {
-                            let request = thread_client
+                            let mut request = thread_client
                                 .get(&segment.url)
-                                .timeout(Duration::from_secs(60));
+                                .timeout(thread_segment_timeouts.segment_timeout.to_std()?);
+                            if !segment_buf.is_empty() {
+                                debug!("Resuming segment {} at byte {}", num + (i * cpus), segment_buf.len());
+                                request = request.header(RANGE, format!("bytes={}-", segment_buf.len()))
+                            }
                             let response = if let Some(rate_limiter) = &mut thread_rate_limiter {
                                 rate_limiter.call(request.build()?).await.map_err(anyhow::Error::new)
                             } else {
                                 request.send().await.map_err(anyhow::Error::new)
                             };
 
-                            let err = match response {
-                                Ok(r) => match r.bytes().await {
-                                    Ok(b) => break b.to_vec(),
-                                    Err(e) => anyhow::Error::new(e)
+                            // a non-successful status must be treated as error, else the error
+                            // body would be written into the output file as segment data
+                            let err = match response.and_then(|r| {
+                                // the server doesn't accept the range of the already received
+                                // data, so the next retry starts over
+                                if r.status() == StatusCode::RANGE_NOT_SATISFIABLE {
+                                    segment_buf.clear()
+                                }
+                                if let Some(unavailable) = ServiceUnavailable::from_response(&r) {
+                                    return Err(unavailable.into())
+                                }
+                                r.error_for_status().map_err(anyhow::Error::new)
+                            }) {
+                                Ok(r) => match thread_segment_timeouts.read_segment(r, &mut segment_buf).await {
+                                    Ok(()) => break segment_buf,
+                                    Err(e) => e
                                 }
                                 Err(e) => e,
                             };
 
-                            if retry_count == 5 {
+                            if is_expired_signature(&err, &segment.url) {
+                                bail!("The url of segment {} expired. {}", num + (i * cpus), expired_hint)
+                            }
+
+                            if let Some(unavailable) = err.downcast_ref::<ServiceUnavailable>() {
+                                let wait = unavailable.wait();
+                                if unavailable_wait + wait > MAX_UNAVAILABLE_WAIT {
+                                    bail!("{} for more than {} minutes, giving up", unavailable, MAX_UNAVAILABLE_WAIT.as_secs() / 60)
+                                }
+                                debug!("Crunchyroll is unavailable, waiting {} seconds before retrying segment {}", wait.as_secs(), num + (i * cpus));
+                                tokio::time::sleep(wait).await;
+                                unavailable_wait += wait;
+                                continue
+                            }
+
+                            if retry_count == thread_segment_timeouts.segment_retries {
                                 bail!("Max retry count reached ({}), multiple errors occurred while receiving segment {}: {}", retry_count, num + (i * cpus), err)
                             }
-                            debug!("Failed to download segment {} ({}). Retrying, {} out of 5 retries left", num + (i * cpus), err, 5 - retry_count);
+                            event!("retry", "Failed to download segment {} ({}). Retrying, {} out of {} retries left", num + (i * cpus), err, thread_segment_timeouts.segment_retries - retry_count, thread_segment_timeouts.segment_retries);
 
                             retry_count += 1;
                         };
@@ -1121,34 +1493,44 @@ impl Downloader {
         // the segment number and the values the corresponding bytes
         let mut data_pos = 0;
         let mut buf: BTreeMap<i32, Vec<u8>> = BTreeMap::new();
+        // resumable downloads store their progress after every segment. to make sure that the
+        // stored progress matches the data on disk, the segment is flushed before
+        let mut write_segment = |bytes: &[u8]| -> Result<()> {
+            writer.write_all(bytes)?;
+            if let Some(resume) = resume.as_mut() {
+                writer.flush()?;
+                resume.segment_written(bytes.len() as u64)?
+            }
+            Ok(())
+        };
+        let mut received_segments = 0;
         while let Some((pos, bytes)) = receiver.recv().await {
             // if the position is lower than 0, an error occurred in the sending download thread
             if pos < 0 {
                 break;
             }
+            received_segments += 1;
 
-            if let Some(p) = &progress {
-                let progress_len = p.length().unwrap();
-                let estimated_segment_len = (stream_data.bandwidth / 8)
-                    * segments.get(pos as usize).unwrap().length.as_secs();
-                let bytes_len = bytes.len() as u64;
-
-                p.set_length(progress_len - estimated_segment_len + bytes_len);
-                p.inc(bytes_len)
-            }
+            let estimated_segment_len =
+                (stream_data.bandwidth / 8) * segments.get(pos as usize).unwrap().length.as_secs();
+            let bytes_len = bytes.len() as u64;
+            progress
+                .set_length(progress.length().saturating_sub(estimated_segment_len) + bytes_len);
+            progress.set_segments(received_segments, total_segments);
+            progress.inc(bytes_len);
 
             // check if the currently sent bytes are the next in the buffer. if so, write them directly
             // to the target without first adding them to the buffer.
             // if not, add them to the buffer
             if data_pos == pos {
-                writer.write_all(bytes.borrow())?;
+                write_segment(bytes.borrow())?;
                 data_pos += 1;
             } else {
                 buf.insert(pos, bytes);
             }
             // check if the buffer contains the next segment(s)
             while let Some(b) = buf.remove(&data_pos) {
-                writer.write_all(b.borrow())?;
+                write_segment(b.borrow())?;
                 data_pos += 1;
             }
         }
@@ -1160,7 +1542,7 @@ impl Downloader {
 
         // write the remaining buffer, if existent
         while let Some(b) = buf.remove(&data_pos) {
-            writer.write_all(b.borrow())?;
+            write_segment(b.borrow())?;
             data_pos += 1;
         }
 
@@ -1178,6 +1560,108 @@ impl Downloader {
     }
 }
 
+/// Name of the cache directory for subtitles, see [`subtitle_data`].
+pub(crate) const SUBTITLE_CACHE: &str = "subtitles";
+
+/// Get the raw data of a subtitle. Subtitles are cached, so re-muxing or re-archiving an episode
+/// doesn't fetch them again. Cache entries are named `<episode id>_<locale>[_cc].<format>`, the
+/// subtitle url can't be used as it changes with every request.
+async fn subtitle_data(subtitle: &Subtitle, episode_id: &str, cc: bool) -> Result<Vec<u8>> {
+    let key = format!(
+        "{}_{}{}.{}",
+        episode_id,
+        subtitle.locale,
+        if cc { "_cc" } else { "" },
+        subtitle.format
+    );
+    let cache = cache(SUBTITLE_CACHE)?;
+
+    if let Some(data) = cache.get(&key) {
+        // an empty entry is the remains of an interrupted write
+        if !data.is_empty() {
+            event!(
+                "cache_hit",
+                "Using cached {} subtitles ({})",
+                subtitle.locale,
+                key
+            );
+            return Ok(data);
+        }
+        cache.invalidate(&key)?
+    }
+    event!(
+        "cache_miss",
+        "{} subtitles are not cached ({})",
+        subtitle.locale,
+        key
+    );
+    let data = subtitle.data().await?;
+    if let Err(e) = cache.set(&key, &data) {
+        debug!("Failed to cache subtitles: {}", e)
+    }
+    Ok(data)
+}
+
+/// Store the name of a video (e.g. `Series S01E05 - Title`) next to its cached subtitles as
+/// `<episode id>.name`, so that `cache export` can name the exported subtitles after the video
+/// instead of its id.
+pub(crate) fn cache_subtitle_name(format: &SingleFormat) {
+    let name = format!(
+        "{} S{:02}E{:0>2} - {}",
+        format.series_name, format.season_number, format.episode_number, format.title
+    );
+    let result = cache(SUBTITLE_CACHE)
+        .and_then(|cache| cache.set(&format!("{}.name", format.episode_id), name.as_bytes()));
+    if let Err(e) = result {
+        debug!("Failed to cache subtitle name: {}", e)
+    }
+}
+
+/// Maximal number of subtitles which are downloaded at the same time by [`export_subtitles`].
+const MAX_CONCURRENT_SUBTITLE_DOWNLOADS: usize = 4;
+
+/// Write every subtitle of `subtitles` (which belong to the episode `episode_id`) next to `dst`,
+/// named after it with the subtitle locale and format as extension (e.g. `Title.S01E05.de-DE.ass`
+/// if `dst` is `Title.S01E05.mp4`). Subtitles which are already existing are only overwritten if
+/// `overwrite` is set. Returns the paths of all written subtitles.
+pub async fn export_subtitles(
+    episode_id: &str,
+    subtitles: Vec<(Subtitle, bool)>,
+    dst: &Path,
+    overwrite: bool,
+) -> Result<Vec<PathBuf>> {
+    let stem = dst.file_stem().unwrap_or_default().to_string_lossy();
+    let mut exports = vec![];
+    for (subtitle, cc) in subtitles {
+        let path = dst.with_file_name(format!("{}.{}.{}", stem, subtitle.locale, subtitle.format));
+        if !overwrite && path.exists() {
+            debug!(
+                "Skipping already existing subtitle '{}'",
+                path.to_string_lossy()
+            );
+            continue;
+        }
+        exports.push((subtitle, cc, path))
+    }
+    if let Some(parent) = dst.parent().filter(|p| !p.as_os_str().is_empty()) {
+        fs::create_dir_all(parent)?
+    }
+
+    let mut downloads = futures_util::stream::iter(exports)
+        .map(|(subtitle, cc, path)| async move {
+            fs::write(&path, subtitle_data(&subtitle, episode_id, cc).await?)?;
+            Ok::<PathBuf, anyhow::Error>(path)
+        })
+        .buffer_unordered(MAX_CONCURRENT_SUBTITLE_DOWNLOADS);
+    let mut written = vec![];
+    while let Some(result) = downloads.next().await {
+        written.push(result?)
+    }
+    written.sort();
+
+    Ok(written)
+}
+
 fn estimate_stream_data_file_size(stream_data: &StreamData, segments: &[StreamSegment]) -> u64 {
     (stream_data.bandwidth / 8) * segments.iter().map(|s| s.length.as_secs()).sum::<u64>()
 }
@@ -1187,13 +1671,14 @@ fn get_video_stats(path: &Path) -> Result<(TimeDelta, f64)> {
     let video_length = Regex::new(r"Duration:\s(?P<time>\d+:\d+:\d+\.\d+),")?;
     let video_fps = Regex::new(r"(?P<fps>[\d/.]+)\sfps")?;
 
-    let ffmpeg = Command::new("ffmpeg")
-        .stdout(Stdio::null())
-        .stderr(Stdio::piped())
-        .arg("-y")
-        .arg("-hide_banner")
-        .args(["-i", path.to_str().unwrap()])
-        .output()?;
+    let ffmpeg = detached_output(
+        Command::new("ffmpeg")
+            .stdout(Stdio::null())
+            .stderr(Stdio::piped())
+            .arg("-y")
+            .arg("-hide_banner")
+            .args(["-i", path.to_str().unwrap()]),
+    )?;
     let ffmpeg_output = String::from_utf8(ffmpeg.stderr)?;
     let length_caps = video_length
         .captures(ffmpeg_output.as_str())
@@ -1216,6 +1701,91 @@ fn get_video_stats(path: &Path) -> Result<(TimeDelta, f64)> {
     ))
 }
 
+/// Concatenate multiple videos into a single file without re-encoding. Every video gets its own
+/// chapter, named by the given title. All videos must have the same stream layout (same codecs and
+/// audio / subtitle tracks in the same order).
+pub fn concat_videos(
+    videos: &[(PathBuf, String)],
+    title: &str,
+    tags: &[(String, String)],
+    dst: &Path,
+) -> Result<()> {
+    let (mut concat_file, concat_path) = tempfile(".concat")?.into_parts();
+    let (mut metadata_file, metadata_path) = tempfile(".chapter")?.into_parts();
+
+    writeln!(metadata_file, ";FFMETADATA1")?;
+    writeln!(metadata_file, "title={}", escape_ffmpeg_metadata(title))?;
+    for (key, value) in tags {
+        writeln!(
+            metadata_file,
+            "{}={}",
+            escape_ffmpeg_metadata(key),
+            escape_ffmpeg_metadata(value)
+        )?;
+    }
+
+    let mut start = TimeDelta::zero();
+    for (path, chapter_title) in videos {
+        // the concat demuxer resolves relative paths relative to the concat file, which is located
+        // in the temp directory
+        let path = fs::canonicalize(path)?;
+        writeln!(
+            concat_file,
+            "file '{}'",
+            path.to_string_lossy().replace('\'', "'\\''")
+        )?;
+
+        let (len, _) = get_video_stats(&path)?;
+        writeln!(metadata_file, "[CHAPTER]")?;
+        writeln!(metadata_file, "TIMEBASE=1/1000")?;
+        writeln!(metadata_file, "START={}", start.num_milliseconds())?;
+        writeln!(metadata_file, "END={}", (start + len).num_milliseconds())?;
+        writeln!(
+            metadata_file,
+            "title={}",
+            escape_ffmpeg_metadata(chapter_title)
+        )?;
+        start += len
+    }
+
+    let ffmpeg = detach_from_signals(&mut Command::new("ffmpeg"))
+        .stdout(Stdio::null())
+        .stderr(Stdio::piped())
+        .arg("-y")
+        .arg("-hide_banner")
+        .args(["-f", "concat", "-safe", "0"])
+        .args(["-i", concat_path.to_str().unwrap()])
+        .args(["-i", metadata_path.to_str().unwrap()])
+        .args(["-map", "0"])
+        .args(["-map_metadata", "1"])
+        .args(["-map_chapters", "1"])
+        .args(["-c", "copy"])
+        .arg(dst.to_str().unwrap())
+        .spawn()?;
+    let ffmpeg_pid = ffmpeg.id();
+    register_detached_process(ffmpeg_pid);
+    let ffmpeg = ffmpeg.wait_with_output();
+    unregister_detached_process(ffmpeg_pid);
+    let ffmpeg = ffmpeg?;
+    if !ffmpeg.status.success() {
+        bail!("{}", String::from_utf8_lossy(ffmpeg.stderr.as_slice()))
+    }
+
+    Ok(())
+}
+
+/// Escape characters which have a special meaning in ffmpeg metadata files.
+fn escape_ffmpeg_metadata(value: &str) -> String {
+    let mut escaped = String::with_capacity(value.len());
+    for c in value.chars() {
+        if matches!(c, '=' | ';' | '#' | '\\' | '\n') {
+            escaped.push('\\')
+        }
+        escaped.push(c)
+    }
+    escaped
+}
+
 // all subtitle fonts (extracted from javascript)
 const FONTS: [(&str, &str); 68] = [
     ("Adobe Arabic", "AdobeArabic-Bold.woff2"),
@@ -1388,21 +1958,7 @@ async fn ffmpeg_progress<R: AsyncReadExt + Unpin>(
 ) -> Result<()> {
     let current_frame = Regex::new(r"frame=\s+(?P<frame>\d+)")?;
 
-    let progress = if log::max_level() == LevelFilter::Info {
-        let progress = ProgressBar::new(total_frames)
-            .with_style(
-                ProgressStyle::with_template(":: {msg} [{wide_bar}] {percent:>3}%")
-                    .unwrap()
-                    .progress_chars("##-"),
-            )
-            .with_message(message)
-            .with_finish(ProgressFinish::Abandon);
-        progress.set_draw_target(ProgressDrawTarget::stdout());
-        progress.enable_steady_tick(Duration::from_millis(200));
-        Some(progress)
-    } else {
-        None
-    };
+    let progress = Progress::new(total_frames, ProgressUnit::Frames, message);
 
     let reader = BufReader::new(stats);
     let mut lines = reader.lines();
@@ -1427,9 +1983,7 @@ async fn ffmpeg_progress<R: AsyncReadExt + Unpin>(
                 };
                 frame = frame_str.as_str().parse()?;
 
-                if let Some(p) = &progress {
-                    p.set_position(frame)
-                }
+                progress.set_position(frame);
 
                 debug!(
                     "Processed frame [{}/{} {:.2}%]",
@@ -1445,9 +1999,7 @@ async fn ffmpeg_progress<R: AsyncReadExt + Unpin>(
     // reading process of 'stats' starts (which causes the progress to be stuck at 0%), the progress
     // is manually set to 100% here
     if frame < total_frames {
-        if let Some(p) = &progress {
-            p.set_position(total_frames)
-        }
+        progress.set_position(total_frames);
         debug!("Processed frame [{}/{} 100%]", total_frames, total_frames);
     }
 
@@ -0,0 +1,309 @@
+use crate::utils::fixture::{buffer_response, is_text, redact_body, redact_headers, redact_url};
+use crate::utils::log::event;
+use crate::utils::rate_limit::{RateLimiterService, RequestLimits};
+use crate::utils::retry::RequestPolicy;
+use anyhow::{bail, Result};
+use crunchyroll_rs::error::Error;
+use log::{debug, log_enabled, trace, Level};
+use reqwest::header::{HeaderName, HeaderValue};
+use reqwest::{Client, Request, Response, Url};
+use serde::Deserialize;
+use serde::Serialize;
+use std::collections::HashMap;
+use std::fs;
+use std::fs::File;
+use std::future::Future;
+use std::io::Write;
+use std::path::Path;
+use std::pin::Pin;
+use std::str::FromStr;
+use std::sync::{Arc, Mutex};
+use std::task::{Context, Poll};
+use std::time::Instant;
+use tower_service::Service;
+
+/// Overrides which are shipped with the binary. Can be used to hotfix endpoints without changing
+/// any code.
+const EMBEDDED_ENDPOINT_OVERRIDES: &str = include_str!("endpoint_overrides.json");
+
+#[derive(Clone, Debug, Default, Deserialize)]
+pub struct EndpointOverrides {
+    /// Url prefixes which should be replaced. The key is the prefix to replace, the value the
+    /// replacement.
+    #[serde(default)]
+    pub endpoints: HashMap<String, String>,
+    /// Headers which should be set on every request. Already existing headers are overwritten.
+    #[serde(default)]
+    pub headers: HashMap<String, String>,
+}
+
+impl EndpointOverrides {
+    pub fn embedded() -> Result<Self> {
+        Ok(serde_json::from_str(EMBEDDED_ENDPOINT_OVERRIDES)?)
+    }
+
+    /// Load overrides from a local file or, if `source` is a http(s) url, from the web.
+    pub async fn load(source: &str, client: &Client) -> Result<Self> {
+        let raw = if source.starts_with("http://") || source.starts_with("https://") {
+            client
+                .get(source)
+                .send()
+                .await?
+                .error_for_status()?
+                .text()
+                .await?
+        } else {
+            fs::read_to_string(Path::new(source))?
+        };
+        let overrides: Self = match serde_json::from_str(&raw) {
+            Ok(overrides) => overrides,
+            Err(e) => bail!("invalid endpoint override file '{}': {}", source, e),
+        };
+
+        for header in overrides.headers.keys() {
+            if HeaderName::from_str(header).is_err() {
+                bail!("invalid header name '{}' in endpoint override file", header)
+            }
+        }
+
+        Ok(overrides)
+    }
+
+    /// Merge `other` into this overrides. Values of `other` take precedence.
+    pub fn merge(&mut self, other: EndpointOverrides) {
+        self.endpoints.extend(other.endpoints);
+        self.headers.extend(other.headers);
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.endpoints.is_empty() && self.headers.is_empty()
+    }
+
+    fn apply(&self, req: &mut Request) {
+        let url = req.url().to_string();
+        // if multiple prefixes are matching, the longest (most specific) one wins
+        if let Some((prefix, replacement)) = self
+            .endpoints
+            .iter()
+            .filter(|(prefix, _)| url.starts_with(prefix.as_str()))
+            .max_by_key(|(prefix, _)| prefix.len())
+        {
+            let new_url = format!("{}{}", replacement, &url[prefix.len()..]);
+            match Url::parse(&new_url) {
+                Ok(new_url) => {
+                    debug!("Overriding endpoint {} with {}", url, new_url);
+                    *req.url_mut() = new_url
+                }
+                Err(e) => debug!("Invalid endpoint override {}: {}", new_url, e),
+            }
+        }
+
+        for (name, value) in &self.headers {
+            if let (Ok(name), Ok(value)) =
+                (HeaderName::from_str(name), HeaderValue::from_str(value))
+            {
+                req.headers_mut().insert(name, value);
+            }
+        }
+    }
+}
+
+/// A line of the request log.
+#[derive(Serialize)]
+struct RequestLogEntry {
+    method: String,
+    url: String,
+    /// [`None`] if the request failed without a response.
+    status: Option<u16>,
+    duration_ms: u128,
+}
+
+#[derive(Clone)]
+pub struct EndpointOverrideService {
+    overrides: Arc<EndpointOverrides>,
+    client: Arc<Client>,
+    rate_limiter: Option<RateLimiterService>,
+    request_log: Option<Arc<Mutex<File>>>,
+    request_limits: RequestLimits,
+    request_policy: RequestPolicy,
+}
+
+impl EndpointOverrideService {
+    pub fn new(
+        overrides: EndpointOverrides,
+        client: Client,
+        rate_limiter: Option<RateLimiterService>,
+    ) -> Self {
+        Self {
+            overrides: Arc::new(overrides),
+            client: Arc::new(client),
+            rate_limiter,
+            request_log: None,
+            request_limits: RequestLimits::default(),
+            request_policy: RequestPolicy::default(),
+        }
+    }
+
+    /// Write the method, (redacted) url, status and duration of every api request as json line
+    /// into `file`, e.g. to collect metrics.
+    pub fn request_log(mut self, file: Option<File>) -> Self {
+        self.request_log = file.map(|f| Arc::new(Mutex::new(f)));
+        self
+    }
+
+    /// Delay api requests so that they don't exceed `limits`.
+    pub fn request_limits(mut self, limits: RequestLimits) -> Self {
+        self.request_limits = limits;
+        self
+    }
+
+    /// Set the timeout and retries of api requests.
+    pub fn request_policy(mut self, policy: RequestPolicy) -> Self {
+        self.request_policy = policy;
+        self
+    }
+}
+
+impl Service<Request> for EndpointOverrideService {
+    type Response = Response;
+    type Error = Error;
+    type Future = Pin<Box<dyn Future<Output = Result<Self::Response, Self::Error>> + Send>>;
+
+    fn poll_ready(&mut self, _: &mut Context<'_>) -> Poll<Result<(), Self::Error>> {
+        Poll::Ready(Ok(()))
+    }
+
+    fn call(&mut self, mut req: Request) -> Self::Future {
+        self.overrides.apply(&mut req);
+        if let Some(timeout) = self.request_policy.timeout {
+            *req.timeout_mut() = Some(timeout)
+        }
+
+        let trace = log_enabled!(Level::Trace);
+        if trace {
+            trace!(
+                "Request: {} {}\n  headers: {:?}\n  body: {}",
+                req.method(),
+                redact_url(req.url().as_str()),
+                redact_headers(req.headers()),
+                req.body()
+                    .and_then(|b| b.as_bytes())
+                    .map_or("<empty>".to_string(), |b| redact_body(
+                        &String::from_utf8_lossy(b)
+                    )
+                    .to_string())
+            )
+        }
+
+        let log_entry =
+            (self.request_log.is_some() || log_enabled!(Level::Debug)).then(|| RequestLogEntry {
+                method: req.method().to_string(),
+                url: redact_url(req.url().as_str()),
+                status: None,
+                duration_ms: 0,
+            });
+
+        let limiter = self.request_limits.limiter(req.url().as_str());
+        let retries = self.request_policy.retries(&req);
+        let mut fut: Self::Future = if retries > 0 {
+            let client = self.client.clone();
+            let mut rate_limiter = self.rate_limiter.clone();
+            Box::pin(async move {
+                let mut retry_count = 0;
+                loop {
+                    // every attempt counts against the request limit, retries included
+                    if let Some(limiter) = &limiter {
+                        limiter.wait().await;
+                    }
+                    // requests with a streamed body can't be cloned and therefore not be retried
+                    let retry_req = (retry_count < retries).then(|| req.try_clone()).flatten();
+                    let url = redact_url(req.url().as_str());
+                    let res = if let Some(rate_limiter) = &mut rate_limiter {
+                        rate_limiter.call(req).await
+                    } else {
+                        client.execute(req).await.map_err(Into::into)
+                    };
+                    let Some(next_req) = retry_req.filter(|_| RequestPolicy::should_retry(&res))
+                    else {
+                        return res;
+                    };
+
+                    retry_count += 1;
+                    event!(
+                        "retry",
+                        "Api request {} failed ({}). Retrying, {} out of {} retries left",
+                        url,
+                        match &res {
+                            Ok(response) => response.status().to_string(),
+                            Err(e) => e.to_string(),
+                        },
+                        retries - retry_count + 1,
+                        retries
+                    );
+                    tokio::time::sleep(RequestPolicy::backoff(retry_count)).await;
+                    req = next_req
+                }
+            })
+        } else {
+            let fut: Self::Future = if let Some(rate_limiter) = &mut self.rate_limiter {
+                rate_limiter.call(req)
+            } else {
+                let client = self.client.clone();
+                Box::pin(async move { Ok(client.execute(req).await?) })
+            };
+            match limiter {
+                Some(limiter) => Box::pin(async move {
+                    limiter.wait().await;
+                    fut.await
+                }),
+                None => fut,
+            }
+        };
+        if let Some(mut log_entry) = log_entry {
+            let request_log = self.request_log.clone();
+            fut = Box::pin(async move {
+                let start = Instant::now();
+                let res = fut.await;
+                log_entry.status = res.as_ref().ok().map(|r| r.status().as_u16());
+                log_entry.duration_ms = start.elapsed().as_millis();
+                event!(
+                    "api_request",
+                    "{} {} {} ({}ms)",
+                    log_entry.method,
+                    log_entry.url,
+                    log_entry
+                        .status
+                        .map_or("failed".to_string(), |s| s.to_string()),
+                    log_entry.duration_ms
+                );
+                if let Some(request_log) = request_log {
+                    if let Ok(line) = serde_json::to_string(&log_entry) {
+                        if let Err(e) = writeln!(request_log.lock().unwrap(), "{}", line) {
+                            debug!("Failed to write request log: {}", e)
+                        }
+                    }
+                }
+                res
+            })
+        }
+        if !trace {
+            return fut;
+        }
+
+        Box::pin(async move {
+            let (res, body) = buffer_response(fut.await?).await?;
+            trace!(
+                "Response: {} {}\n  headers: {:?}\n  body: {}",
+                res.status(),
+                redact_url(res.url().as_str()),
+                redact_headers(res.headers()),
+                if is_text(res.headers()) {
+                    redact_body(&String::from_utf8_lossy(&body)).to_string()
+                } else {
+                    format!("<{} bytes>", body.len())
+                }
+            );
+            Ok(res)
+        })
+    }
+}
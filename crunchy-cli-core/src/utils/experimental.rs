@@ -0,0 +1,63 @@
+use log::warn;
+use std::env;
+use std::fmt::{Display, Formatter};
+use std::sync::OnceLock;
+
+static EXPERIMENTAL_FEATURES: OnceLock<Vec<ExperimentalFeature>> = OnceLock::new();
+
+/// Implementations which are not (yet) used by default, e.g. fixes for api issues which still have
+/// to prove that they don't break anything else.
+#[derive(Clone, Copy, Debug, Eq, PartialEq)]
+pub enum ExperimentalFeature {
+    /// Correct mislabeled audio locales of series, seasons and episodes.
+    StabilizeLocales,
+    /// Correct wrong season numbers.
+    StabilizeSeasonNumber,
+}
+
+impl Display for ExperimentalFeature {
+    fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
+        let value = match self {
+            ExperimentalFeature::StabilizeLocales => "stabilize-locales",
+            ExperimentalFeature::StabilizeSeasonNumber => "stabilize-season-number",
+        };
+        write!(f, "{}", value)
+    }
+}
+
+impl ExperimentalFeature {
+    pub fn all() -> Vec<Self> {
+        vec![Self::StabilizeLocales, Self::StabilizeSeasonNumber]
+    }
+
+    pub fn parse(s: &str) -> Result<Self, String> {
+        Self::all()
+            .into_iter()
+            .find(|f| f.to_string() == s.to_lowercase())
+            .ok_or(format!("invalid experimental feature '{}'", s))
+    }
+}
+
+/// Enable experimental features. Additionally to the given ones, the features of the comma
+/// separated `CRUNCHY_CLI_EXPERIMENTAL` environment variable are enabled, so they can be set
+/// once e.g. in a docker setup.
+pub fn set_experimental_features(mut features: Vec<ExperimentalFeature>) {
+    if let Ok(env_features) = env::var("CRUNCHY_CLI_EXPERIMENTAL") {
+        for feature in env_features.split(',').filter(|f| !f.trim().is_empty()) {
+            match ExperimentalFeature::parse(feature.trim()) {
+                Ok(feature) if !features.contains(&feature) => features.push(feature),
+                Ok(_) => (),
+                Err(e) => warn!("Ignoring `CRUNCHY_CLI_EXPERIMENTAL`: {}", e),
+            }
+        }
+    }
+    let _ = EXPERIMENTAL_FEATURES.set(features);
+}
+
+pub fn experimental_features() -> &'static [ExperimentalFeature] {
+    EXPERIMENTAL_FEATURES.get_or_init(Vec::new)
+}
+
+pub fn is_experimental_enabled(feature: ExperimentalFeature) -> bool {
+    experimental_features().contains(&feature)
+}
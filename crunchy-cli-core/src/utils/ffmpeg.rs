@@ -6,6 +6,53 @@ use std::str::FromStr;
 
 pub const SOFTSUB_CONTAINERS: [&str; 3] = ["mkv", "mov", "mp4"];
 
+#[derive(Clone, Debug, Eq, PartialEq)]
+pub enum MuxOption {
+    Faststart,
+    Fragmented,
+    CuesFront,
+}
+
+impl fmt::Display for MuxOption {
+    fn fmt(&self, f: &mut Formatter<'_>) -> fmt::Result {
+        let value = match self {
+            MuxOption::Faststart => "faststart",
+            MuxOption::Fragmented => "fragmented",
+            MuxOption::CuesFront => "cues-front",
+        };
+        write!(f, "{}", value)
+    }
+}
+
+impl MuxOption {
+    pub(crate) fn parse(s: &str) -> Result<Self, String> {
+        match s.to_lowercase().as_str() {
+            "faststart" => Ok(Self::Faststart),
+            "fragmented" => Ok(Self::Fragmented),
+            "cues-front" => Ok(Self::CuesFront),
+            _ => Err(format!("'{}' is not a valid mux option", s)),
+        }
+    }
+
+    /// Check if the option can be applied to a file with the given extension.
+    pub(crate) fn supports_extension(&self, ext: &str) -> bool {
+        match self {
+            MuxOption::Faststart | MuxOption::Fragmented => ["mov", "mp4"].contains(&ext),
+            MuxOption::CuesFront => ext == "mkv",
+        }
+    }
+
+    /// Check if the given options are conflicting with each other.
+    pub(crate) fn check_conflicts(options: &[MuxOption]) -> Result<(), String> {
+        if options.contains(&MuxOption::Faststart) && options.contains(&MuxOption::Fragmented) {
+            return Err(
+                "mux options 'faststart' and 'fragmented' cannot be used together".to_string(),
+            );
+        }
+        Ok(())
+    }
+}
+
 #[derive(Clone, Debug, Eq, PartialEq)]
 pub enum FFmpegPreset {
     Predefined(FFmpegCodec, Option<FFmpegHwAccel>, FFmpegQuality),
@@ -132,6 +179,14 @@ impl FFmpegPreset {
     }
 
     pub(crate) fn available_matches_human_readable() -> Vec<String> {
+        FFmpegPreset::available_matches_described()
+            .into_iter()
+            .map(|(name, description)| format!("{} ({})", name, description))
+            .collect()
+    }
+
+    /// All predefined presets as tuple of their name and a description.
+    pub(crate) fn available_matches_described() -> Vec<(String, String)> {
         let mut return_values = vec![];
 
         for (codec, hwaccel, quality) in FFmpegPreset::available_matches() {
@@ -159,18 +214,17 @@ impl FFmpegPreset {
                 format!("{codec} encoded with {first}{mid} and {last}",)
             };
 
-            return_values.push(format!(
-                "{} ({})",
+            return_values.push((
                 vec![
                     Some(codec.to_string()),
                     hwaccel.map(|h| h.to_string()),
-                    quality.map(|q| q.to_string())
+                    quality.map(|q| q.to_string()),
                 ]
                 .into_iter()
                 .flatten()
                 .collect::<Vec<String>>()
                 .join("-"),
-                description
+                description,
             ))
         }
         return_values
@@ -90,3 +90,12 @@ pub fn real_dedup_vec<T: Clone + Eq>(input: &mut Vec<T>) {
     }
     *input = dedup
 }
+
+/// Check if the episode was already fully watched by the logged in account. Partially watched
+/// episodes are treated as not watched.
+pub async fn is_watched(episode: &Episode) -> Result<bool> {
+    Ok(episode
+        .playhead()
+        .await?
+        .map_or(false, |playhead| playhead.fully_watched))
+}
@@ -0,0 +1,286 @@
+use crate::utils::endpoint_override::EndpointOverrideService;
+use crunchyroll_rs::error::Error;
+use log::debug;
+use reqwest::header::{HeaderMap, CONTENT_TYPE};
+use reqwest::{Request, Response, ResponseBuilderExt};
+use serde::Serialize;
+use serde_json::Value;
+use std::collections::BTreeMap;
+use std::fs;
+use std::future::Future;
+use std::path::PathBuf;
+use std::pin::Pin;
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::Arc;
+use std::task::{Context, Poll};
+use tower_service::Service;
+
+const REDACTED: &str = "<redacted>";
+/// Replacement of redacted url path segments. [`REDACTED`] would be percent encoded in paths.
+const REDACTED_PATH_SEGMENT: &str = "redacted";
+
+/// Headers whose values are replaced with [`REDACTED`] before a fixture is written.
+const SENSITIVE_HEADERS: &[&str] = &["authorization", "cookie", "set-cookie", "etp-anonymous-id"];
+/// Query parameters, form fields and json keys whose values are replaced with [`REDACTED`] before
+/// a fixture is written.
+const SENSITIVE_KEYS: &[&str] = &[
+    "access_token",
+    "refresh_token",
+    "password",
+    "username",
+    "device_id",
+    "account_id",
+    "external_id",
+    "profile_id",
+    "email",
+    "etp_rt",
+    "Policy",
+    "Signature",
+    "Key-Pair-Id",
+    "policy",
+    "signature",
+    "key_pair_id",
+];
+
+#[derive(Serialize)]
+struct Fixture {
+    request: FixtureRequest,
+    response: FixtureResponse,
+}
+
+#[derive(Serialize)]
+struct FixtureRequest {
+    method: String,
+    url: String,
+    headers: BTreeMap<String, String>,
+    body: Option<Value>,
+}
+
+#[derive(Serialize)]
+struct FixtureResponse {
+    status: u16,
+    headers: BTreeMap<String, String>,
+    /// `None` if the body is binary (e.g. video segments). Only text and json bodies are recorded.
+    body: Option<Value>,
+}
+
+/// Records all api requests and their responses as json files into a directory. Credentials and
+/// other personal values are redacted so that the files can be shared to reproduce bugs.
+#[derive(Clone)]
+pub struct FixtureRecorderService {
+    inner: EndpointOverrideService,
+    dir: Arc<PathBuf>,
+    counter: Arc<AtomicUsize>,
+}
+
+impl FixtureRecorderService {
+    pub fn new(dir: PathBuf, inner: EndpointOverrideService) -> Self {
+        Self {
+            inner,
+            dir: Arc::new(dir),
+            counter: Arc::new(AtomicUsize::new(0)),
+        }
+    }
+}
+
+impl Service<Request> for FixtureRecorderService {
+    type Response = Response;
+    type Error = Error;
+    type Future = Pin<Box<dyn Future<Output = Result<Self::Response, Self::Error>> + Send>>;
+
+    fn poll_ready(&mut self, cx: &mut Context<'_>) -> Poll<Result<(), Self::Error>> {
+        self.inner.poll_ready(cx)
+    }
+
+    fn call(&mut self, req: Request) -> Self::Future {
+        let fixture_request = FixtureRequest {
+            method: req.method().to_string(),
+            url: redact_url(req.url().as_str()),
+            headers: redact_headers(req.headers()),
+            body: req
+                .body()
+                .and_then(|b| b.as_bytes())
+                .map(|b| redact_body(&String::from_utf8_lossy(b))),
+        };
+        let index = self.counter.fetch_add(1, Ordering::SeqCst);
+        let path = self.dir.join(fixture_name(
+            index,
+            &fixture_request.method,
+            &redact_path(req.url().path()),
+        ));
+        let fut = self.inner.call(req);
+
+        Box::pin(async move {
+            let (res, body) = buffer_response(fut.await?).await?;
+
+            let fixture = Fixture {
+                request: fixture_request,
+                response: FixtureResponse {
+                    status: res.status().as_u16(),
+                    headers: redact_headers(res.headers()),
+                    body: is_text(res.headers())
+                        .then(|| redact_body(&String::from_utf8_lossy(&body))),
+                },
+            };
+            match serde_json::to_string_pretty(&fixture) {
+                Ok(json) => {
+                    if let Err(e) = fs::write(&path, json) {
+                        debug!("Failed to write fixture {}: {}", path.to_string_lossy(), e)
+                    }
+                }
+                Err(e) => debug!("Failed to serialize fixture: {}", e),
+            }
+
+            Ok(res)
+        })
+    }
+}
+
+/// Read the whole body of a response. Returns the body and a new response with the same body, so
+/// that the response can still be consumed by the caller.
+pub(crate) async fn buffer_response(res: Response) -> Result<(Response, Vec<u8>), Error> {
+    let url = res.url().clone();
+    let status = res.status();
+    let version = res.version();
+    let headers = res.headers().clone();
+    let body = res
+        .bytes()
+        .await
+        .map_err(|e| Error::Request {
+            url: url.to_string(),
+            status: Some(status),
+            message: e.to_string(),
+        })?
+        .to_vec();
+
+    let mut http_res = http::Response::builder()
+        .url(url)
+        .status(status)
+        .version(version);
+    *http_res.headers_mut().unwrap() = headers;
+    Ok((Response::from(http_res.body(body.clone()).unwrap()), body))
+}
+
+/// If the body is text or json. Binary bodies (e.g. video segments) shouldn't be recorded.
+pub(crate) fn is_text(headers: &HeaderMap) -> bool {
+    headers
+        .get(CONTENT_TYPE)
+        .and_then(|c| c.to_str().ok())
+        .map_or(false, |c| c.contains("json") || c.starts_with("text/"))
+}
+
+fn fixture_name(index: usize, method: &str, path: &str) -> String {
+    let path = path
+        .trim_matches('/')
+        .chars()
+        .map(|c| if c.is_ascii_alphanumeric() { c } else { '_' })
+        .collect::<String>();
+    format!("{:04}-{}-{}.json", index, method.to_lowercase(), path)
+}
+
+fn is_sensitive(key: &str) -> bool {
+    SENSITIVE_KEYS.contains(&key)
+}
+
+/// Account and profile ids are uuids which are part of the path of account specific endpoints
+/// (e.g. `/content/v2/<account id>/watchlist`). Content ids (e.g. `GRDQPM1ZY`) have another format
+/// and are kept.
+fn is_uuid(segment: &str) -> bool {
+    let parts: Vec<&str> = segment.split('-').collect();
+    parts.iter().map(|p| p.len()).eq([8, 4, 4, 4, 12])
+        && parts
+            .iter()
+            .all(|p| p.chars().all(|c| c.is_ascii_hexdigit()))
+}
+
+fn redact_path(path: &str) -> String {
+    path.split('/')
+        .map(|segment| {
+            if is_uuid(segment) {
+                REDACTED_PATH_SEGMENT
+            } else {
+                segment
+            }
+        })
+        .collect::<Vec<&str>>()
+        .join("/")
+}
+
+pub(crate) fn redact_url(url: &str) -> String {
+    let Ok(mut url) = reqwest::Url::parse(url) else {
+        return url.to_string();
+    };
+    let path = redact_path(url.path());
+    url.set_path(&path);
+    if url.query().is_none() {
+        return url.to_string();
+    }
+    let query: Vec<(String, String)> = url
+        .query_pairs()
+        .map(|(k, v)| {
+            let v = if is_sensitive(&k) {
+                REDACTED.to_string()
+            } else {
+                v.to_string()
+            };
+            (k.to_string(), v)
+        })
+        .collect();
+    url.query_pairs_mut().clear().extend_pairs(query);
+    url.to_string()
+}
+
+pub(crate) fn redact_headers(headers: &HeaderMap) -> BTreeMap<String, String> {
+    headers
+        .iter()
+        .map(|(name, value)| {
+            let value = if SENSITIVE_HEADERS.contains(&name.as_str()) {
+                REDACTED.to_string()
+            } else {
+                String::from_utf8_lossy(value.as_bytes()).to_string()
+            };
+            (name.to_string(), value)
+        })
+        .collect()
+}
+
+/// Redacts json bodies and form encoded bodies. Any other body is returned as string as it is.
+pub(crate) fn redact_body(body: &str) -> Value {
+    if let Ok(mut json) = serde_json::from_str::<Value>(body) {
+        redact_json(&mut json);
+        return json;
+    }
+    if body.contains('=') && !body.contains(char::is_whitespace) {
+        let form: Vec<String> = body
+            .split('&')
+            .map(|pair| match pair.split_once('=') {
+                Some((k, _)) if is_sensitive(k) => format!("{}={}", k, REDACTED),
+                _ => pair.to_string(),
+            })
+            .collect();
+        return Value::String(form.join("&"));
+    }
+    Value::String(body.to_string())
+}
+
+fn redact_json(value: &mut Value) {
+    match value {
+        Value::Object(map) => {
+            for (k, v) in map.iter_mut() {
+                if is_sensitive(k) {
+                    *v = Value::String(REDACTED.to_string())
+                } else {
+                    redact_json(v)
+                }
+            }
+        }
+        Value::Array(array) => array.iter_mut().for_each(redact_json),
+        Value::String(s) => {
+            // urls may contain signed query parameters
+            if s.starts_with("http://") || s.starts_with("https://") {
+                *s = redact_url(s)
+            }
+        }
+        _ => (),
+    }
+}
@@ -2,16 +2,20 @@ use crate::utils::filter::real_dedup_vec;
 use crate::utils::locale::LanguageTagging;
 use crate::utils::log::tab_info;
 use crate::utils::os::{is_special_file, sanitize};
+use crate::utils::video::signed_url_expiry;
 use anyhow::{bail, Result};
-use chrono::{Datelike, Duration};
+use chrono::{Datelike, Duration, Utc};
 use crunchyroll_rs::media::{Resolution, SkipEvents, Stream, StreamData, Subtitle};
 use crunchyroll_rs::{Concert, Episode, Locale, MediaCollection, Movie, MusicVideo};
-use log::{debug, info};
+use log::{debug, info, warn};
 use std::cmp::Ordering;
 use std::collections::BTreeMap;
 use std::env;
 use std::path::{Path, PathBuf};
 
+/// Minimal time the urls of a stream must be valid before a download is started with them.
+const MIN_STREAM_VALIDITY: Duration = Duration::minutes(30);
+
 #[derive(Clone)]
 pub struct SingleFormat {
     pub identifier: String,
@@ -24,6 +28,8 @@ pub struct SingleFormat {
     pub release_day: u64,
 
     pub audio: Locale,
+    /// If the audio is the original audio of the video (and not a dub).
+    pub original: bool,
     pub subtitles: Vec<Locale>,
 
     pub series_id: String,
@@ -70,6 +76,12 @@ impl SingleFormat {
             release_month: episode.episode_air_date.month() as u64,
             release_day: episode.episode_air_date.day() as u64,
             audio: episode.audio_locale.clone(),
+            original: episode.versions.as_ref().map_or(true, |versions| {
+                versions
+                    .iter()
+                    .find(|v| v.audio_locale == episode.audio_locale)
+                    .map_or(true, |v| v.original)
+            }),
             subtitles,
             series_id: episode.series_id.clone(),
             series_name: episode.series_title.clone(),
@@ -99,6 +111,7 @@ impl SingleFormat {
             release_month: movie.free_available_date.month() as u64,
             release_day: movie.free_available_date.day() as u64,
             audio: Locale::ja_JP,
+            original: true,
             subtitles,
             series_id: movie.movie_listing_id.clone(),
             series_name: movie.movie_listing_title.clone(),
@@ -124,6 +137,7 @@ impl SingleFormat {
             release_month: music_video.original_release.month() as u64,
             release_day: music_video.original_release.day() as u64,
             audio: Locale::ja_JP,
+            original: true,
             subtitles: vec![],
             series_id: music_video.id.clone(),
             series_name: music_video.title.clone(),
@@ -149,6 +163,7 @@ impl SingleFormat {
             release_month: concert.original_release.month() as u64,
             release_day: concert.original_release.day() as u64,
             audio: Locale::ja_JP,
+            original: true,
             subtitles: vec![],
             series_id: concert.id.clone(),
             series_name: concert.title.clone(),
@@ -165,7 +180,35 @@ impl SingleFormat {
         }
     }
 
+    /// Get the stream of this format. If the urls of the stream expire soon, the stream is
+    /// requested again so that a (long) download doesn't start with nearly expired urls.
     pub async fn stream(&self) -> Result<Stream> {
+        let stream = self.request_stream().await?;
+
+        let Some(expiry) = signed_url_expiry(&stream.url) else {
+            return Ok(stream);
+        };
+        debug!("Stream urls of {} are valid until {}", self.title, expiry);
+        if expiry - Utc::now() >= MIN_STREAM_VALIDITY {
+            return Ok(stream);
+        }
+
+        debug!(
+            "Stream urls of {} expire soon, requesting new ones",
+            self.title
+        );
+        stream.invalidate().await?;
+        let stream = self.request_stream().await?;
+        if signed_url_expiry(&stream.url).map_or(false, |e| e - Utc::now() < MIN_STREAM_VALIDITY) {
+            warn!(
+                "The stream urls of {} expire soon, the download may fail",
+                self.title
+            )
+        }
+        Ok(stream)
+    }
+
+    async fn request_stream(&self) -> Result<Stream> {
         let stream = match &self.source {
             MediaCollection::Episode(e) => e.stream_maybe_without_drm().await,
             MediaCollection::Movie(m) => m.stream_maybe_without_drm().await,
@@ -201,6 +244,33 @@ impl SingleFormat {
         .to_string()
     }
 
+    /// The crunchyroll url of the video.
+    pub fn url(&self) -> String {
+        match &self.source {
+            MediaCollection::MusicVideo(_) => {
+                format!(
+                    "https://www.crunchyroll.com/watch/musicvideo/{}",
+                    self.episode_id
+                )
+            }
+            MediaCollection::Concert(_) => {
+                format!(
+                    "https://www.crunchyroll.com/watch/concert/{}",
+                    self.episode_id
+                )
+            }
+            _ => format!("https://www.crunchyroll.com/watch/{}", self.episode_id),
+        }
+    }
+
+    /// Short human readable name of the video, used in warnings.
+    pub fn display_name(&self) -> String {
+        format!(
+            "{} S{:02}E{:0>2} ({} audio)",
+            self.title, self.season_number, self.episode_number, self.audio
+        )
+    }
+
     pub fn is_episode(&self) -> bool {
         matches!(self.source, MediaCollection::Episode(_))
     }
@@ -291,6 +361,36 @@ impl SingleFormatCollection {
             );
     }
 
+    /// Request the stream of every format to check if it can be downloaded, so that e.g. missing
+    /// premium or region restrictions are noticed before the first download starts instead of in
+    /// the middle of it. Formats which can't be downloaded are removed. Returns every removed format
+    /// together with the reason why it can't be downloaded.
+    pub async fn preflight(&mut self) -> Vec<(SingleFormat, String)> {
+        let mut failed = vec![];
+        for episodes in self.0.values_mut() {
+            let mut remove = vec![];
+            for (key, formats) in episodes.iter() {
+                for format in formats {
+                    match format.stream().await {
+                        Ok(stream) => {
+                            if let Err(e) = stream.invalidate().await {
+                                debug!("Failed to invalidate stream: {}", e)
+                            }
+                        }
+                        Err(e) => {
+                            failed.push((format.clone(), e.to_string()));
+                            remove.push(key.0);
+                            break;
+                        }
+                    }
+                }
+            }
+            episodes.retain(|key, _| !remove.contains(&key.0))
+        }
+        self.0.retain(|_, episodes| !episodes.is_empty());
+        failed
+    }
+
     pub fn full_visual_output(&self) {
         debug!("Series has {} seasons", self.0.len());
         for (season_key, episodes) in &self.0 {
@@ -303,7 +403,7 @@ impl SingleFormatCollection {
             );
             for (i, (_, formats)) in episodes.iter().enumerate() {
                 let format = formats.first().unwrap();
-                if log::max_level() == log::Level::Debug {
+                if log::max_level() >= log::Level::Debug {
                     info!(
                         "{} S{:02}E{:0>2}",
                         format.title, format.season_number, format.episode_number
@@ -376,12 +476,16 @@ pub struct Format {
     pub relative_episode_number: Option<u32>,
     pub sequence_number: f32,
     pub relative_sequence_number: Option<f32>,
+
+    pub duration: Duration,
+
+    pub identifier: String,
 }
 
 impl Format {
     #[allow(clippy::type_complexity)]
     pub fn from_single_formats(
-        mut single_formats: Vec<(SingleFormat, StreamData, Vec<(Subtitle, bool)>)>,
+        mut single_formats: Vec<(SingleFormat, StreamData, Vec<(Subtitle, bool, String)>)>,
     ) -> Self {
         let locales: Vec<(Locale, Vec<Locale>)> = single_formats
             .iter()
@@ -390,7 +494,7 @@ impl Format {
                     single_format.audio.clone(),
                     subtitles
                         .iter()
-                        .map(|(s, _)| s.locale.clone())
+                        .map(|(s, _, _)| s.locale.clone())
                         .collect::<Vec<Locale>>(),
                 )
             })
@@ -418,6 +522,8 @@ impl Format {
             relative_episode_number: first_format.relative_episode_number,
             sequence_number: first_format.sequence_number,
             relative_sequence_number: first_format.relative_sequence_number,
+            duration: first_format.duration,
+            identifier: first_format.identifier,
         }
     }
 
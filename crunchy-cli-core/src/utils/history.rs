@@ -0,0 +1,326 @@
+use crate::utils::filter::real_dedup_vec;
+use crate::utils::fmt::format_file_size;
+use crate::utils::format::Format;
+use crate::utils::os::is_special_file;
+use anyhow::Result;
+use chrono::{DateTime, Datelike, Local};
+use crunchyroll_rs::Locale;
+use fs2::FileExt;
+use log::{debug, warn};
+use serde::{Deserialize, Serialize};
+use std::fs;
+use std::fs::OpenOptions;
+use std::io::{Read, Seek, SeekFrom, Write};
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::OnceLock;
+
+static HISTORY_FILE_PATH: OnceLock<PathBuf> = OnceLock::new();
+static MONTHLY_CAP: OnceLock<u64> = OnceLock::new();
+static MONTHLY_CAP_WARNED: AtomicBool = AtomicBool::new(false);
+static INVALID_ENTRIES_REPORTED: AtomicBool = AtomicBool::new(false);
+
+/// Share of the monthly cap after which a warning is shown.
+const MONTHLY_CAP_WARNING: f64 = 0.9;
+
+/// A single downloaded file.
+#[derive(Clone, Debug, Deserialize, Serialize)]
+pub struct HistoryEntry {
+    /// Unix timestamp of when the file was downloaded.
+    pub timestamp: i64,
+    /// Identifies a video independent of its audio version, see [`SingleFormat::identifier`].
+    ///
+    /// [`SingleFormat::identifier`]: crate::utils::format::SingleFormat::identifier
+    #[serde(default)]
+    pub identifier: String,
+    pub series_id: String,
+    pub series_name: String,
+    pub season_number: u32,
+    pub episode_id: String,
+    pub episode_number: String,
+    pub title: String,
+    pub audio: Vec<String>,
+    pub subtitles: Vec<String>,
+    pub path: PathBuf,
+    pub size: u64,
+    /// Duration of the video in milliseconds. 0 for entries which were recorded before the
+    /// duration was stored.
+    #[serde(default)]
+    pub duration: i64,
+}
+
+impl HistoryEntry {
+    pub fn new(format: &Format, path: &Path) -> Self {
+        let mut audio = vec![];
+        let mut subtitles = vec![];
+        for (a, s) in &format.locales {
+            audio.push(a.to_string());
+            subtitles.extend(s.iter().map(|l| l.to_string()))
+        }
+        real_dedup_vec(&mut subtitles);
+
+        Self {
+            timestamp: chrono::Utc::now().timestamp(),
+            identifier: format.identifier.clone(),
+            series_id: format.series_id.clone(),
+            series_name: format.series_name.clone(),
+            season_number: format.season_number,
+            episode_id: format.episode_id.clone(),
+            episode_number: format.episode_number.clone(),
+            title: format.title.clone(),
+            audio,
+            subtitles,
+            path: path.to_path_buf(),
+            size: fs::metadata(path).map_or(0, |m| m.len()),
+            duration: format.duration.num_milliseconds(),
+        }
+    }
+}
+
+/// Use a custom history file, e.g. to share one history between multiple machines.
+pub fn set_history_file_path(path: PathBuf) {
+    let _ = HISTORY_FILE_PATH.set(path);
+}
+
+pub fn history_file_path() -> Option<PathBuf> {
+    if let Some(path) = HISTORY_FILE_PATH.get() {
+        return Some(path.clone());
+    }
+    dirs::config_dir().map(|config_dir| config_dir.join("crunchy-cli").join("history"))
+}
+
+/// Add a downloaded file to the download history. The history is stored as json lines, so adding
+/// an entry never requires to read or rewrite the whole file.
+pub fn add_to_history(format: &Format, path: &Path) {
+    if is_special_file(path) || path.to_string_lossy() == "-" {
+        return;
+    }
+    let Some(history_file_path) = history_file_path() else {
+        return;
+    };
+
+    let entry = HistoryEntry::new(format, path);
+    let result: Result<()> = (|| {
+        if let Some(parent) = history_file_path.parent() {
+            fs::create_dir_all(parent)?
+        }
+        let mut file = OpenOptions::new()
+            .create(true)
+            .append(true)
+            .open(&history_file_path)?;
+        // the history might be shared by multiple instances, the lock prevents that lines of
+        // different instances get mixed up
+        file.lock_exclusive()?;
+        let result = writeln!(file, "{}", serde_json::to_string(&entry)?);
+        file.unlock()?;
+        Ok(result?)
+    })();
+    if let Err(e) = result {
+        debug!("Failed to add {} to history: {}", path.to_string_lossy(), e)
+    }
+}
+
+/// Read all entries of the download history. Lines which can't be parsed are skipped.
+pub fn read_history() -> Result<Vec<HistoryEntry>> {
+    Ok(read_history_from(0)?.0)
+}
+
+/// Read the entries of the download history which start at byte `offset` of the history file.
+/// Returns the entries and the offset up to which the file was read. A trailing line which is
+/// still written by another instance is not read.
+fn read_history_from(offset: u64) -> Result<(Vec<HistoryEntry>, u64)> {
+    let Some(history_file_path) = history_file_path() else {
+        return Ok((vec![], 0));
+    };
+    read_history_file(&history_file_path, offset)
+}
+
+fn read_history_file(history_file_path: &Path, offset: u64) -> Result<(Vec<HistoryEntry>, u64)> {
+    if !history_file_path.exists() {
+        return Ok((vec![], 0));
+    }
+
+    let mut file = fs::File::open(history_file_path)?;
+    file.seek(SeekFrom::Start(offset))?;
+    let mut content = String::new();
+    file.read_to_string(&mut content)?;
+    let complete_len = content.rfind('\n').map_or(0, |i| i + 1);
+    content.truncate(complete_len);
+
+    let mut invalid = 0;
+    let entries = content
+        .lines()
+        .filter(|l| !l.trim().is_empty())
+        .filter_map(|l| match serde_json::from_str(l) {
+            Ok(entry) => Some(entry),
+            Err(e) => {
+                debug!("Skipping invalid history entry: {}", e);
+                invalid += 1;
+                None
+            }
+        })
+        .collect();
+    // an unclean shutdown may leave a truncated line behind. it's only reported once per run as the
+    // history may be read multiple times
+    if invalid > 0 && !INVALID_ENTRIES_REPORTED.swap(true, Ordering::Relaxed) {
+        warn!(
+            "Skipped {} corrupt entries of the download history '{}'",
+            invalid,
+            history_file_path.to_string_lossy()
+        )
+    }
+    Ok((entries, offset + complete_len as u64))
+}
+
+/// The download history, read once per run. [`History::refresh`] reads only the entries which
+/// other instances added since then.
+#[derive(Default)]
+pub struct History {
+    entries: Vec<HistoryEntry>,
+    /// Offset up to which the history file was read. The file is only appended to.
+    read_len: u64,
+}
+
+impl History {
+    pub fn load() -> Result<Self> {
+        let (entries, read_len) = read_history_from(0)?;
+        Ok(Self { entries, read_len })
+    }
+
+    /// Read the entries which were added to the history file since it was read last.
+    pub fn refresh(&mut self) -> Result<()> {
+        let file_len = history_file_path()
+            .and_then(|p| fs::metadata(p).ok())
+            .map_or(0, |m| m.len());
+        // the file was replaced or truncated, e.g. because it was deleted manually
+        if file_len < self.read_len {
+            *self = Self::load()?;
+            return Ok(());
+        }
+        let (entries, read_len) = read_history_from(self.read_len)?;
+        self.entries.extend(entries);
+        self.read_len = read_len;
+        Ok(())
+    }
+
+    /// Check if a video with the given identifier and (at least) the given audio locales was
+    /// already downloaded, by this or any other instance which shares the same history.
+    pub fn contains(&self, identifier: &str, audio: &[Locale]) -> bool {
+        let audio: Vec<String> = audio.iter().map(|a| a.to_string()).collect();
+        self.entries
+            .iter()
+            .any(|e| e.identifier == identifier && audio.iter().all(|a| e.audio.contains(a)))
+    }
+}
+
+/// Limit how much may be downloaded per month, e.g. on connections with a data cap.
+pub fn set_monthly_cap(cap: u64) {
+    let _ = MONTHLY_CAP.set(cap);
+}
+
+/// Total size of all files which were downloaded in the current month (in local time).
+pub fn downloaded_this_month() -> Result<u64> {
+    let now = Local::now();
+    Ok(read_history()?
+        .iter()
+        .filter(|e| {
+            DateTime::from_timestamp(e.timestamp, 0)
+                .map(|d| d.with_timezone(&Local))
+                .is_some_and(|d| d.year() == now.year() && d.month() == now.month())
+        })
+        .map(|e| e.size)
+        .sum())
+}
+
+/// Check if the monthly cap would be exceeded by downloading a video with the given (estimated)
+/// size. If it's almost reached, a warning is shown (once).
+pub fn monthly_cap_reached(estimated_size: u64) -> Result<bool> {
+    let Some(cap) = MONTHLY_CAP.get() else {
+        return Ok(false);
+    };
+    let downloaded = downloaded_this_month()?;
+    if downloaded + estimated_size > *cap {
+        return Ok(true);
+    }
+    if downloaded as f64 >= *cap as f64 * MONTHLY_CAP_WARNING
+        && !MONTHLY_CAP_WARNED.swap(true, Ordering::Relaxed)
+    {
+        warn!(
+            "{} of the monthly cap of {} are already used",
+            format_file_size(downloaded),
+            format_file_size(*cap)
+        )
+    }
+    Ok(false)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn entry_json(identifier: &str, audio: &str) -> String {
+        format!(
+            r#"{{"timestamp":0,"identifier":"{}","series_id":"S","series_name":"Series","season_number":1,"episode_id":"E","episode_number":"1","title":"Title","audio":["{}"],"subtitles":[],"path":"video.mkv","size":1024,"duration":1420000}}"#,
+            identifier, audio
+        )
+    }
+
+    #[test]
+    fn read_complete_lines() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("history");
+        // the last line is still written by another instance and must not be read yet
+        let first = format!("{}\n", entry_json("a", "ja-JP"));
+        fs::write(
+            &path,
+            format!("{}not json\n{}", first, entry_json("b", "ja-JP")),
+        )
+        .unwrap();
+
+        let (entries, read_len) = read_history_file(&path, 0).unwrap();
+        assert_eq!(entries.len(), 1);
+        assert_eq!(entries[0].identifier, "a");
+        assert_eq!(read_len, first.len() as u64 + "not json\n".len() as u64);
+
+        let mut file = OpenOptions::new().append(true).open(&path).unwrap();
+        writeln!(file).unwrap();
+        let (entries, _) = read_history_file(&path, read_len).unwrap();
+        assert_eq!(entries.len(), 1);
+        assert_eq!(entries[0].identifier, "b");
+    }
+
+    #[test]
+    fn read_missing_file() {
+        let dir = tempfile::tempdir().unwrap();
+        let (entries, read_len) = read_history_file(&dir.path().join("history"), 0).unwrap();
+        assert!(entries.is_empty());
+        assert_eq!(read_len, 0)
+    }
+
+    #[test]
+    fn entry_defaults() {
+        // entries which were written before the identifier and duration were stored
+        let entry: HistoryEntry = serde_json::from_str(
+            r#"{"timestamp":0,"series_id":"S","series_name":"Series","season_number":1,"episode_id":"E","episode_number":"1","title":"Title","audio":["ja-JP"],"subtitles":[],"path":"video.mkv","size":1024}"#,
+        )
+        .unwrap();
+        assert_eq!(entry.identifier, "");
+        assert_eq!(entry.duration, 0)
+    }
+
+    #[test]
+    fn contains() {
+        let history = History {
+            entries: vec![
+                serde_json::from_str(&entry_json("a", "ja-JP")).unwrap(),
+                serde_json::from_str(&entry_json("a", "en-US")).unwrap(),
+            ],
+            read_len: 0,
+        };
+        assert!(history.contains("a", &[Locale::ja_JP]));
+        assert!(history.contains("a", &[Locale::en_US]));
+        assert!(!history.contains("a", &[Locale::ja_JP, Locale::en_US]));
+        assert!(!history.contains("a", &[Locale::de_DE]));
+        assert!(!history.contains("b", &[Locale::ja_JP]))
+    }
+}
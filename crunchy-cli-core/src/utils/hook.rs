@@ -0,0 +1,247 @@
+use crate::utils::signal::{
+    detach_from_signals, kill, register_detached_process, unregister_detached_process,
+};
+use anyhow::{bail, Result};
+use log::{debug, warn};
+use std::fmt::{Display, Formatter};
+use std::io::Read;
+use std::path::{Path, PathBuf};
+use std::process::{Command, Stdio};
+use std::sync::{Arc, Mutex};
+use std::thread;
+use std::time::{Duration, Instant};
+
+/// Minimal time the output of a post process command is read after it exited. Processes it spawned
+/// itself (e.g. in the background) may keep the output pipes open, they're killed afterward.
+const OUTPUT_GRACE_PERIOD: Duration = Duration::from_secs(5);
+
+#[derive(Clone, Debug, Eq, PartialEq)]
+pub enum HookErrorPolicy {
+    Fail,
+    Warn,
+    Ignore,
+}
+
+impl Display for HookErrorPolicy {
+    fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
+        let value = match self {
+            HookErrorPolicy::Fail => "fail",
+            HookErrorPolicy::Warn => "warn",
+            HookErrorPolicy::Ignore => "ignore",
+        };
+        write!(f, "{}", value)
+    }
+}
+
+impl HookErrorPolicy {
+    fn parse(s: &str) -> Result<Self, String> {
+        match s.to_lowercase().as_str() {
+            "fail" => Ok(Self::Fail),
+            "warn" => Ok(Self::Warn),
+            "ignore" => Ok(Self::Ignore),
+            _ => Err(format!("'{}' is not a valid hook error policy", s)),
+        }
+    }
+}
+
+#[derive(Clone, Debug, clap::Parser)]
+pub struct PostProcessHook {
+    #[arg(help = "Command which gets executed after a file was downloaded")]
+    #[arg(
+        long_help = "Command which gets executed after a file was downloaded. \
+    '{path}' in the command gets replaced with the path of the downloaded file, it's also available via the 'CRUNCHY_CLI_OUTPUT' environment variable"
+    )]
+    #[arg(long)]
+    pub(crate) post_process: Option<String>,
+    #[arg(help = "Working directory of the post process command")]
+    #[arg(long)]
+    pub(crate) post_process_dir: Option<PathBuf>,
+    #[arg(
+        help = "Environment variables which are passed to the post process command. Can be used multiple times"
+    )]
+    #[arg(
+        long_help = "Environment variables which are passed to the post process command. Can be used multiple times. \
+    If set, only the given variables (and 'CRUNCHY_CLI_OUTPUT') are passed, otherwise the command inherits all environment variables"
+    )]
+    #[arg(long)]
+    pub(crate) post_process_env: Vec<String>,
+    #[arg(help = "Maximal time in seconds the post process command may run before it gets killed")]
+    #[arg(long)]
+    pub(crate) post_process_timeout: Option<u64>,
+    #[arg(
+        help = "What should happen if the post process command fails. Valid policies are 'fail', 'warn' and 'ignore'"
+    )]
+    #[arg(
+        long_help = "What should happen if the post process command fails (exits with a non-zero exit code or times out). \
+    Valid policies are 'fail' (stop the whole download), 'warn' (show a warning and continue) and 'ignore' (continue silently)"
+    )]
+    #[arg(long, default_value_t = HookErrorPolicy::Warn)]
+    #[arg(value_parser = HookErrorPolicy::parse)]
+    pub(crate) post_process_on_error: HookErrorPolicy,
+}
+
+impl PostProcessHook {
+    pub(crate) fn check(&self) -> Result<()> {
+        if let Some(command) = &self.post_process {
+            if shlex::split(command).map_or(true, |c| c.is_empty()) {
+                bail!("Invalid post process command '{}'", command)
+            }
+        } else if self.post_process_dir.is_some()
+            || !self.post_process_env.is_empty()
+            || self.post_process_timeout.is_some()
+        {
+            warn!("`--post-process-*` flags have no effect if `--post-process` is not set")
+        }
+        if let Some(dir) = &self.post_process_dir {
+            if !dir.is_dir() {
+                bail!(
+                    "Post process directory '{}' does not exist",
+                    dir.to_string_lossy()
+                )
+            }
+        }
+        Ok(())
+    }
+
+    /// Run the post process command for the given file, if one is set. Errors are handled as
+    /// specified by `post_process_on_error`.
+    pub(crate) async fn run(&self, path: &Path) -> Result<()> {
+        let Some(command) = &self.post_process else {
+            return Ok(());
+        };
+
+        let result = self.execute(command, path).await;
+        match (&result, &self.post_process_on_error) {
+            (Ok(_), _) | (Err(_), HookErrorPolicy::Fail) => result,
+            (Err(e), HookErrorPolicy::Warn) => {
+                warn!("Post process command failed: {}", e);
+                Ok(())
+            }
+            (Err(e), HookErrorPolicy::Ignore) => {
+                debug!("Post process command failed: {}", e);
+                Ok(())
+            }
+        }
+    }
+
+    async fn execute(&self, command: &str, path: &Path) -> Result<()> {
+        let path_str = path.to_string_lossy().to_string();
+        let args: Vec<String> = shlex::split(command)
+            .unwrap_or_default()
+            .into_iter()
+            .map(|a| a.replace("{path}", &path_str))
+            .collect();
+        debug!("Executing post process command: {}", args.join(" "));
+
+        let mut cmd = Command::new(&args[0]);
+        detach_from_signals(&mut cmd)
+            .args(&args[1..])
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped());
+        if let Some(dir) = &self.post_process_dir {
+            cmd.current_dir(dir);
+        }
+        if !self.post_process_env.is_empty() {
+            cmd.env_clear();
+            for key in &self.post_process_env {
+                if let Some(value) = std::env::var_os(key) {
+                    cmd.env(key, value);
+                }
+            }
+        }
+        cmd.env("CRUNCHY_CLI_OUTPUT", &path_str);
+
+        let mut child = cmd.spawn()?;
+        let pid = child.id();
+        register_detached_process(pid);
+
+        // the output is read in separate threads, otherwise the command may block forever if it
+        // writes more than the pipe buffer can hold
+        let (stdout, stdout_reader) = read_output(child.stdout.take().unwrap());
+        let (stderr, stderr_reader) = read_output(child.stderr.take().unwrap());
+
+        let start = Instant::now();
+        let status = loop {
+            match child.try_wait() {
+                Ok(Some(status)) => break Ok(Some(status)),
+                Ok(None) => (),
+                Err(e) => break Err(e),
+            }
+            if let Some(timeout) = self.post_process_timeout {
+                if start.elapsed() > Duration::from_secs(timeout) {
+                    // processes spawned by the command (e.g. if it's a shell script) are killed
+                    // too, else they'd keep running and holding the output pipes open
+                    kill(pid);
+                    let _ = tokio::task::spawn_blocking(move || child.wait()).await;
+                    break Ok(None);
+                }
+            }
+            tokio::time::sleep(Duration::from_millis(100)).await
+        };
+        let status = match status {
+            Ok(status) => status,
+            Err(e) => {
+                unregister_detached_process(pid);
+                return Err(e.into());
+            }
+        };
+
+        // processes spawned by the command may still hold the output pipes open, so the output is
+        // only read until the remaining timeout (or at least the grace period) is over
+        let output_timeout = self
+            .post_process_timeout
+            .map_or(Duration::ZERO, |timeout| {
+                Duration::from_secs(timeout).saturating_sub(start.elapsed())
+            })
+            .max(OUTPUT_GRACE_PERIOD);
+        let output_start = Instant::now();
+        while !stdout_reader.is_finished() || !stderr_reader.is_finished() {
+            if output_start.elapsed() > output_timeout {
+                kill(pid);
+                warn!("Processes spawned by the post process command kept running after it exited and were killed, its output may be truncated");
+                break;
+            }
+            tokio::time::sleep(Duration::from_millis(100)).await
+        }
+        unregister_detached_process(pid);
+
+        let stdout = String::from_utf8_lossy(&stdout.lock().unwrap()).to_string();
+        let stderr = String::from_utf8_lossy(&stderr.lock().unwrap()).to_string();
+        if !stdout.trim().is_empty() {
+            debug!("Post process command stdout: {}", stdout.trim())
+        }
+        if !stderr.trim().is_empty() {
+            debug!("Post process command stderr: {}", stderr.trim())
+        }
+
+        match status {
+            Some(status) if status.success() => Ok(()),
+            Some(status) => bail!("command exited with {}: {}", status, stderr.trim()),
+            None => bail!(
+                "command timed out after {} seconds",
+                self.post_process_timeout.unwrap()
+            ),
+        }
+    }
+}
+
+/// Read `pipe` until it's closed in a separate thread. The output read so far is available at any
+/// time via the returned buffer.
+fn read_output(
+    mut pipe: impl Read + Send + 'static,
+) -> (Arc<Mutex<Vec<u8>>>, thread::JoinHandle<()>) {
+    let output = Arc::new(Mutex::new(vec![]));
+    let output_clone = output.clone();
+    // a plain thread instead of a blocking tokio task, a pipe which is never closed would otherwise
+    // prevent the runtime from shutting down
+    let reader = thread::spawn(move || {
+        let mut buf = [0; 8192];
+        while let Ok(n) = pipe.read(&mut buf) {
+            if n == 0 {
+                break;
+            }
+            output_clone.lock().unwrap().extend_from_slice(&buf[..n])
+        }
+    });
+    (output, reader)
+}
@@ -0,0 +1,473 @@
+use crunchyroll_rs::Locale;
+use std::sync::OnceLock;
+
+static UI_LOCALE: OnceLock<Locale> = OnceLock::new();
+
+/// Locales in which user-facing messages are available. These are the same locales the api
+/// supports for `--lang`.
+pub fn ui_locales() -> Vec<Locale> {
+    vec![
+        Locale::ar_ME,
+        Locale::de_DE,
+        Locale::en_US,
+        Locale::es_ES,
+        Locale::es_419,
+        Locale::fr_FR,
+        Locale::it_IT,
+        Locale::pt_BR,
+        Locale::pt_PT,
+        Locale::ru_RU,
+    ]
+}
+
+pub fn set_ui_locale(locale: Locale) {
+    let _ = UI_LOCALE.set(locale);
+}
+
+/// Messages are only translated if a locale was set explicitly with `--ui-lang`, the system locale
+/// isn't considered.
+fn ui_locale() -> &'static Locale {
+    UI_LOCALE.get_or_init(|| Locale::en_US)
+}
+
+/// Translate a message into the ui locale. The english message is used as key, and also as
+/// fallback if no translation exists. `{0}`, `{1}`, ... are replaced with the given arguments in a
+/// single pass, so placeholders which are part of an argument are kept as they are.
+pub(crate) fn translate(message: &'static str, args: &[String]) -> String {
+    let mut rest = translations(ui_locale())
+        .iter()
+        .find(|(key, _)| *key == message)
+        .map_or(message, |(_, translation)| translation);
+
+    let mut translated = String::with_capacity(rest.len());
+    while let Some(start) = rest.find('{') {
+        translated.push_str(&rest[..start]);
+        rest = &rest[start..];
+        let arg = rest
+            .find('}')
+            .and_then(|end| Some((rest[1..end].parse::<usize>().ok()?, end)))
+            .and_then(|(i, end)| Some((args.get(i)?, end)));
+        match arg {
+            Some((arg, end)) => {
+                translated.push_str(arg);
+                rest = &rest[end + 1..]
+            }
+            None => {
+                translated.push('{');
+                rest = &rest[1..]
+            }
+        }
+    }
+    translated.push_str(rest);
+    translated
+}
+
+macro_rules! tr {
+    ($message:literal) => {
+        $crate::utils::i18n::translate($message, &[])
+    };
+    ($message:literal, $($arg:expr),+ $(,)?) => {
+        $crate::utils::i18n::translate($message, &[$($arg.to_string()),+])
+    };
+}
+pub(crate) use tr;
+
+fn translations(locale: &Locale) -> &'static [(&'static str, &'static str)] {
+    match locale {
+        Locale::ar_ME => &[
+            ("Logging in", "جارٍ تسجيل الدخول"),
+            ("Logged in", "تم تسجيل الدخول"),
+            ("Fetching watchlist", "جارٍ جلب قائمة المشاهدة"),
+            ("Parsing url {0}", "جارٍ تحليل الرابط {0}"),
+            ("Parsed url {0}", "تم تحليل الرابط {0}"),
+            ("Fetching series details", "جارٍ جلب تفاصيل المسلسل"),
+            (
+                "Loaded series information for url {0}",
+                "تم تحميل معلومات المسلسل للرابط {0}",
+            ),
+            (
+                "Skipping url {0} (no matching videos found)",
+                "تخطي الرابط {0} (لم يتم العثور على مقاطع مطابقة)",
+            ),
+            (
+                "Checking if all videos can be downloaded",
+                "جارٍ التحقق من إمكانية تنزيل كل المقاطع",
+            ),
+            ("All videos can be downloaded", "يمكن تنزيل كل المقاطع"),
+            (
+                "{0} video(s) can't be downloaded",
+                "لا يمكن تنزيل {0} من المقاطع",
+            ),
+            (
+                "Checking when the videos leave the catalog",
+                "جارٍ التحقق من موعد إزالة المقاطع من الكتالوج",
+            ),
+            (
+                "Sorted urls by their removal date",
+                "تم ترتيب الروابط حسب تاريخ إزالتها",
+            ),
+            ("Fetching schedule of {0}", "جارٍ جلب جدول {0}"),
+            ("Fetched schedule", "تم جلب الجدول"),
+            (
+                "Selected {0} episode(s) from the watchlist",
+                "تم اختيار {0} من الحلقات من قائمة المشاهدة",
+            ),
+            ("Downloaded {0} video(s)", "تم تنزيل {0} من المقاطع"),
+            ("Merging season {0} of {1}", "جارٍ دمج الموسم {0} من {1}"),
+            ("Merged season {0} of {1} into '{2}'", "تم دمج الموسم {0} من {1} في '{2}'"),
+            (
+                "Syncing video start times (this might take some time)",
+                "جارٍ مزامنة أوقات بدء المقاطع (قد يستغرق ذلك بعض الوقت)",
+            ),
+            ("Downloading video #{0}", "جارٍ تنزيل المقطع #{0}"),
+            ("Downloading {0} audio", "جارٍ تنزيل الصوت {0}"),
+            ("Downloading subtitles", "جارٍ تنزيل الترجمات"),
+            ("Downloading fonts", "جارٍ تنزيل الخطوط"),
+            ("Generating output file", "جارٍ إنشاء ملف الإخراج"),
+            ("An error occurred: {0}", "حدث خطأ: {0}"),
+        ],
+        Locale::de_DE => &[
+            ("Logging in", "Anmelden"),
+            ("Logged in", "Angemeldet"),
+            ("Fetching watchlist", "Lade Watchlist"),
+            ("Parsing url {0}", "Verarbeite URL {0}"),
+            ("Parsed url {0}", "URL {0} verarbeitet"),
+            ("Fetching series details", "Lade Seriendetails"),
+            (
+                "Loaded series information for url {0}",
+                "Serieninformationen für URL {0} geladen",
+            ),
+            (
+                "Skipping url {0} (no matching videos found)",
+                "Überspringe URL {0} (keine passenden Videos gefunden)",
+            ),
+            (
+                "Checking if all videos can be downloaded",
+                "Prüfe, ob alle Videos heruntergeladen werden können",
+            ),
+            (
+                "All videos can be downloaded",
+                "Alle Videos können heruntergeladen werden",
+            ),
+            (
+                "{0} video(s) can't be downloaded",
+                "{0} Video(s) können nicht heruntergeladen werden",
+            ),
+            (
+                "Checking when the videos leave the catalog",
+                "Prüfe, wann die Videos aus dem Katalog entfernt werden",
+            ),
+            (
+                "Sorted urls by their removal date",
+                "URLs nach ihrem Entfernungsdatum sortiert",
+            ),
+            ("Fetching schedule of {0}", "Lade Zeitplan von {0}"),
+            ("Fetched schedule", "Zeitplan geladen"),
+            (
+                "Selected {0} episode(s) from the watchlist",
+                "{0} Episode(n) aus der Watchlist ausgewählt",
+            ),
+            ("Downloaded {0} video(s)", "{0} Video(s) heruntergeladen"),
+            ("Merging season {0} of {1}", "Füge Staffel {0} von {1} zusammen"),
+            ("Merged season {0} of {1} into '{2}'", "Staffel {0} von {1} in '{2}' zusammengefügt"),
+            (
+                "Syncing video start times (this might take some time)",
+                "Synchronisiere die Startzeiten der Videos (das kann eine Weile dauern)",
+            ),
+            ("Downloading video #{0}", "Lade Video #{0} herunter"),
+            ("Downloading {0} audio", "Lade {0} Audio herunter"),
+            ("Downloading subtitles", "Lade Untertitel herunter"),
+            ("Downloading fonts", "Lade Schriftarten herunter"),
+            ("Generating output file", "Erstelle Ausgabedatei"),
+            ("An error occurred: {0}", "Ein Fehler ist aufgetreten: {0}"),
+        ],
+        Locale::es_ES | Locale::es_419 => &[
+            ("Logging in", "Iniciando sesión"),
+            ("Logged in", "Sesión iniciada"),
+            ("Fetching watchlist", "Obteniendo la lista de seguimiento"),
+            ("Parsing url {0}", "Analizando la url {0}"),
+            ("Parsed url {0}", "Url {0} analizada"),
+            (
+                "Fetching series details",
+                "Obteniendo los detalles de la serie",
+            ),
+            (
+                "Loaded series information for url {0}",
+                "Información de la serie cargada para la url {0}",
+            ),
+            (
+                "Skipping url {0} (no matching videos found)",
+                "Omitiendo la url {0} (no se encontraron vídeos coincidentes)",
+            ),
+            (
+                "Checking if all videos can be downloaded",
+                "Comprobando si se pueden descargar todos los vídeos",
+            ),
+            (
+                "All videos can be downloaded",
+                "Se pueden descargar todos los vídeos",
+            ),
+            (
+                "{0} video(s) can't be downloaded",
+                "No se pueden descargar {0} vídeo(s)",
+            ),
+            (
+                "Checking when the videos leave the catalog",
+                "Comprobando cuándo salen los vídeos del catálogo",
+            ),
+            (
+                "Sorted urls by their removal date",
+                "Urls ordenadas por su fecha de retirada",
+            ),
+            (
+                "Fetching schedule of {0}",
+                "Obteniendo el calendario de {0}",
+            ),
+            ("Fetched schedule", "Calendario obtenido"),
+            (
+                "Selected {0} episode(s) from the watchlist",
+                "{0} episodio(s) seleccionado(s) de la lista de seguimiento",
+            ),
+            ("Downloaded {0} video(s)", "{0} vídeo(s) descargado(s)"),
+            ("Merging season {0} of {1}", "Uniendo la temporada {0} de {1}"),
+            ("Merged season {0} of {1} into '{2}'", "Temporada {0} de {1} unida en '{2}'"),
+            (
+                "Syncing video start times (this might take some time)",
+                "Sincronizando los tiempos de inicio de los vídeos (esto puede tardar un poco)",
+            ),
+            ("Downloading video #{0}", "Descargando el vídeo #{0}"),
+            ("Downloading {0} audio", "Descargando el audio {0}"),
+            ("Downloading subtitles", "Descargando subtítulos"),
+            ("Downloading fonts", "Descargando fuentes"),
+            ("Generating output file", "Generando el archivo de salida"),
+            ("An error occurred: {0}", "Se produjo un error: {0}"),
+        ],
+        Locale::fr_FR => &[
+            ("Logging in", "Connexion"),
+            ("Logged in", "Connecté"),
+            ("Fetching watchlist", "Récupération de la liste de suivi"),
+            ("Parsing url {0}", "Analyse de l'url {0}"),
+            ("Parsed url {0}", "Url {0} analysée"),
+            (
+                "Fetching series details",
+                "Récupération des détails de la série",
+            ),
+            (
+                "Loaded series information for url {0}",
+                "Informations de la série chargées pour l'url {0}",
+            ),
+            (
+                "Skipping url {0} (no matching videos found)",
+                "Url {0} ignorée (aucune vidéo correspondante trouvée)",
+            ),
+            (
+                "Checking if all videos can be downloaded",
+                "Vérification que toutes les vidéos peuvent être téléchargées",
+            ),
+            (
+                "All videos can be downloaded",
+                "Toutes les vidéos peuvent être téléchargées",
+            ),
+            (
+                "{0} video(s) can't be downloaded",
+                "{0} vidéo(s) ne peuvent pas être téléchargées",
+            ),
+            (
+                "Checking when the videos leave the catalog",
+                "Vérification de la date de retrait des vidéos du catalogue",
+            ),
+            (
+                "Sorted urls by their removal date",
+                "Urls triées par date de retrait",
+            ),
+            (
+                "Fetching schedule of {0}",
+                "Récupération du programme de {0}",
+            ),
+            ("Fetched schedule", "Programme récupéré"),
+            (
+                "Selected {0} episode(s) from the watchlist",
+                "{0} épisode(s) sélectionné(s) dans la liste de suivi",
+            ),
+            ("Downloaded {0} video(s)", "{0} vidéo(s) téléchargée(s)"),
+            ("Merging season {0} of {1}", "Fusion de la saison {0} de {1}"),
+            ("Merged season {0} of {1} into '{2}'", "Saison {0} de {1} fusionnée dans '{2}'"),
+            (
+                "Syncing video start times (this might take some time)",
+                "Synchronisation des débuts des vidéos (cela peut prendre un moment)",
+            ),
+            ("Downloading video #{0}", "Téléchargement de la vidéo #{0}"),
+            ("Downloading {0} audio", "Téléchargement de l'audio {0}"),
+            ("Downloading subtitles", "Téléchargement des sous-titres"),
+            ("Downloading fonts", "Téléchargement des polices"),
+            ("Generating output file", "Création du fichier de sortie"),
+            ("An error occurred: {0}", "Une erreur est survenue : {0}"),
+        ],
+        Locale::it_IT => &[
+            ("Logging in", "Accesso in corso"),
+            ("Logged in", "Accesso effettuato"),
+            ("Fetching watchlist", "Recupero della watchlist"),
+            ("Parsing url {0}", "Analisi dell'url {0}"),
+            ("Parsed url {0}", "Url {0} analizzato"),
+            (
+                "Fetching series details",
+                "Recupero dei dettagli della serie",
+            ),
+            (
+                "Loaded series information for url {0}",
+                "Informazioni sulla serie caricate per l'url {0}",
+            ),
+            (
+                "Skipping url {0} (no matching videos found)",
+                "Url {0} saltato (nessun video corrispondente trovato)",
+            ),
+            (
+                "Checking if all videos can be downloaded",
+                "Verifica che tutti i video possano essere scaricati",
+            ),
+            (
+                "All videos can be downloaded",
+                "Tutti i video possono essere scaricati",
+            ),
+            (
+                "{0} video(s) can't be downloaded",
+                "{0} video non possono essere scaricati",
+            ),
+            (
+                "Checking when the videos leave the catalog",
+                "Verifica di quando i video lasciano il catalogo",
+            ),
+            (
+                "Sorted urls by their removal date",
+                "Url ordinati per data di rimozione",
+            ),
+            ("Fetching schedule of {0}", "Recupero del palinsesto di {0}"),
+            ("Fetched schedule", "Palinsesto recuperato"),
+            (
+                "Selected {0} episode(s) from the watchlist",
+                "{0} episodio/i selezionato/i dalla watchlist",
+            ),
+            ("Downloaded {0} video(s)", "{0} video scaricato/i"),
+            ("Merging season {0} of {1}", "Unione della stagione {0} di {1}"),
+            ("Merged season {0} of {1} into '{2}'", "Stagione {0} di {1} unita in '{2}'"),
+            (
+                "Syncing video start times (this might take some time)",
+                "Sincronizzazione degli orari di inizio dei video (potrebbe richiedere un po' di tempo)",
+            ),
+            ("Downloading video #{0}", "Download del video #{0}"),
+            ("Downloading {0} audio", "Download dell'audio {0}"),
+            ("Downloading subtitles", "Download dei sottotitoli"),
+            ("Downloading fonts", "Download dei font"),
+            ("Generating output file", "Creazione del file di output"),
+            ("An error occurred: {0}", "Si è verificato un errore: {0}"),
+        ],
+        Locale::pt_BR | Locale::pt_PT => &[
+            ("Logging in", "Entrando"),
+            ("Logged in", "Sessão iniciada"),
+            ("Fetching watchlist", "Obtendo a lista de interesses"),
+            ("Parsing url {0}", "Analisando a url {0}"),
+            ("Parsed url {0}", "Url {0} analisada"),
+            ("Fetching series details", "Obtendo os detalhes da série"),
+            (
+                "Loaded series information for url {0}",
+                "Informações da série carregadas para a url {0}",
+            ),
+            (
+                "Skipping url {0} (no matching videos found)",
+                "Ignorando a url {0} (nenhum vídeo correspondente encontrado)",
+            ),
+            (
+                "Checking if all videos can be downloaded",
+                "Verificando se todos os vídeos podem ser baixados",
+            ),
+            (
+                "All videos can be downloaded",
+                "Todos os vídeos podem ser baixados",
+            ),
+            (
+                "{0} video(s) can't be downloaded",
+                "{0} vídeo(s) não podem ser baixados",
+            ),
+            (
+                "Checking when the videos leave the catalog",
+                "Verificando quando os vídeos saem do catálogo",
+            ),
+            (
+                "Sorted urls by their removal date",
+                "Urls ordenadas pela data de remoção",
+            ),
+            ("Fetching schedule of {0}", "Obtendo a programação de {0}"),
+            ("Fetched schedule", "Programação obtida"),
+            (
+                "Selected {0} episode(s) from the watchlist",
+                "{0} episódio(s) selecionado(s) da lista de interesses",
+            ),
+            ("Downloaded {0} video(s)", "{0} vídeo(s) baixado(s)"),
+            ("Merging season {0} of {1}", "Juntando a temporada {0} de {1}"),
+            ("Merged season {0} of {1} into '{2}'", "Temporada {0} de {1} juntada em '{2}'"),
+            (
+                "Syncing video start times (this might take some time)",
+                "Sincronizando os tempos de início dos vídeos (isso pode demorar um pouco)",
+            ),
+            ("Downloading video #{0}", "Baixando o vídeo #{0}"),
+            ("Downloading {0} audio", "Baixando o áudio {0}"),
+            ("Downloading subtitles", "Baixando legendas"),
+            ("Downloading fonts", "Baixando fontes"),
+            ("Generating output file", "Gerando o arquivo de saída"),
+            ("An error occurred: {0}", "Ocorreu um erro: {0}"),
+        ],
+        Locale::ru_RU => &[
+            ("Logging in", "Вход в систему"),
+            ("Logged in", "Вход выполнен"),
+            ("Fetching watchlist", "Загрузка списка просмотра"),
+            ("Parsing url {0}", "Обработка ссылки {0}"),
+            ("Parsed url {0}", "Ссылка {0} обработана"),
+            ("Fetching series details", "Загрузка информации о сериале"),
+            (
+                "Loaded series information for url {0}",
+                "Информация о сериале для ссылки {0} загружена",
+            ),
+            (
+                "Skipping url {0} (no matching videos found)",
+                "Пропуск ссылки {0} (подходящие видео не найдены)",
+            ),
+            (
+                "Checking if all videos can be downloaded",
+                "Проверка возможности загрузки всех видео",
+            ),
+            ("All videos can be downloaded", "Все видео можно загрузить"),
+            (
+                "{0} video(s) can't be downloaded",
+                "Невозможно загрузить видео: {0}",
+            ),
+            (
+                "Checking when the videos leave the catalog",
+                "Проверка, когда видео будут удалены из каталога",
+            ),
+            (
+                "Sorted urls by their removal date",
+                "Ссылки отсортированы по дате удаления",
+            ),
+            ("Fetching schedule of {0}", "Загрузка расписания {0}"),
+            ("Fetched schedule", "Расписание загружено"),
+            (
+                "Selected {0} episode(s) from the watchlist",
+                "Выбрано эпизодов из списка просмотра: {0}",
+            ),
+            ("Downloaded {0} video(s)", "Загружено видео: {0}"),
+            ("Merging season {0} of {1}", "Объединение сезона {0} сериала {1}"),
+            ("Merged season {0} of {1} into '{2}'", "Сезон {0} сериала {1} объединён в '{2}'"),
+            (
+                "Syncing video start times (this might take some time)",
+                "Синхронизация времени начала видео (это может занять некоторое время)",
+            ),
+            ("Downloading video #{0}", "Загрузка видео #{0}"),
+            ("Downloading {0} audio", "Загрузка аудио {0}"),
+            ("Downloading subtitles", "Загрузка субтитров"),
+            ("Downloading fonts", "Загрузка шрифтов"),
+            ("Generating output file", "Создание выходного файла"),
+            ("An error occurred: {0}", "Произошла ошибка: {0}"),
+        ],
+        _ => &[],
+    }
+}
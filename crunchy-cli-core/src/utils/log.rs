@@ -1,12 +1,15 @@
+use crate::utils::theme::{paint, paint_stderr, template_style, Role};
 use indicatif::{ProgressBar, ProgressDrawTarget, ProgressStyle};
 use log::{
     info, set_boxed_logger, set_max_level, Level, LevelFilter, Log, Metadata, Record,
     SetLoggerError,
 };
+use std::fs::{File, OpenOptions};
 use std::io::{stdout, Write};
+use std::path::PathBuf;
 use std::sync::Mutex;
-use std::thread;
 use std::time::Duration;
+use std::{fs, io, thread};
 
 pub struct ProgressHandler {
     pub(crate) stopped: bool,
@@ -48,7 +51,7 @@ pub(crate) use progress_pause;
 
 macro_rules! tab_info {
     ($($arg:tt)+) => {
-        if log::max_level() == log::LevelFilter::Debug {
+        if log::max_level() >= log::LevelFilter::Debug {
             info!($($arg)+)
         } else {
             info!("\t{}", format!($($arg)+))
@@ -57,9 +60,112 @@ macro_rules! tab_info {
 }
 pub(crate) use tab_info;
 
+/// Log a debug record for a notable event, like an api request, a cache hit or a retry. In the json
+/// log file, the name of the event is written into the `event` field, so events can be filtered
+/// without parsing the message.
+macro_rules! event {
+    ($event:literal, $($arg:tt)+) => {
+        log::debug!(target: concat!("crunchy_cli_core::event::", $event), $($arg)+)
+    }
+}
+pub(crate) use event;
+
+/// Writes log records as json lines into a file. If the file exceeds a specific size, it gets
+/// rotated, similar to how logrotate works: `<file>` is renamed to `<file>.1`, `<file>.1` to
+/// `<file>.2` and so on, until `max_files` is reached.
+pub struct JsonLogFile {
+    path: PathBuf,
+    file: File,
+    size: u64,
+    max_size: u64,
+    max_files: usize,
+}
+
+impl JsonLogFile {
+    pub fn new(path: PathBuf, max_size: u64, max_files: usize) -> io::Result<Self> {
+        if let Some(parent) = path.parent() {
+            if !parent.as_os_str().is_empty() {
+                fs::create_dir_all(parent)?
+            }
+        }
+        let file = OpenOptions::new().create(true).append(true).open(&path)?;
+        let size = file.metadata()?.len();
+
+        Ok(Self {
+            path,
+            file,
+            size,
+            max_size,
+            max_files,
+        })
+    }
+
+    fn write(&mut self, record: &Record) -> io::Result<()> {
+        let msg = format!("{}", record.args());
+        // progress records without a message are only used to control the progress spinner
+        if msg.is_empty() {
+            return Ok(());
+        }
+
+        let mut line = serde_json::json!({
+            "timestamp": chrono::Utc::now().to_rfc3339(),
+            "level": record.level().to_string(),
+            "target": record
+                .target()
+                .replacen("crunchy_cli_core", "crunchy_cli", 1)
+                .replacen("progress_end", "crunchy_cli", 1)
+                .replacen("progress", "crunchy_cli", 1),
+            "message": msg,
+        });
+        if let Some((_, event)) = record.target().split_once("::event::") {
+            line["event"] = event.into()
+        }
+        let mut line = line.to_string();
+        line.push('\n');
+
+        if self.max_size > 0 && self.size + line.len() as u64 > self.max_size && self.size > 0 {
+            self.rotate()?
+        }
+        self.file.write_all(line.as_bytes())?;
+        self.size += line.len() as u64;
+
+        Ok(())
+    }
+
+    fn rotate(&mut self) -> io::Result<()> {
+        let rotated_path = |i: usize| {
+            let mut path = self.path.clone().into_os_string();
+            path.push(format!(".{}", i));
+            PathBuf::from(path)
+        };
+
+        if self.max_files == 0 {
+            fs::remove_file(&self.path)?
+        } else {
+            let _ = fs::remove_file(rotated_path(self.max_files));
+            for i in (1..self.max_files).rev() {
+                let from = rotated_path(i);
+                if from.exists() {
+                    fs::rename(from, rotated_path(i + 1))?
+                }
+            }
+            fs::rename(&self.path, rotated_path(1))?;
+        }
+
+        self.file = OpenOptions::new()
+            .create(true)
+            .append(true)
+            .open(&self.path)?;
+        self.size = 0;
+
+        Ok(())
+    }
+}
+
 pub struct CliLogger {
     level: LevelFilter,
     progress: Mutex<Option<ProgressBar>>,
+    log_file: Option<Mutex<JsonLogFile>>,
 }
 
 impl Log for CliLogger {
@@ -77,6 +183,14 @@ impl Log for CliLogger {
             return;
         }
 
+        if record.target() != "progress_pause" {
+            if let Some(log_file) = &self.log_file {
+                if let Err(e) = log_file.lock().unwrap().write(record) {
+                    eprintln!(":: Failed to write to log file: {}", e)
+                }
+            }
+        }
+
         if self.level >= LevelFilter::Debug {
             self.extended(record);
             return;
@@ -113,16 +227,17 @@ impl Log for CliLogger {
 }
 
 impl CliLogger {
-    pub fn new(level: LevelFilter) -> Self {
+    pub fn new(level: LevelFilter, log_file: Option<JsonLogFile>) -> Self {
         Self {
             level,
             progress: Mutex::new(None),
+            log_file: log_file.map(Mutex::new),
         }
     }
 
-    pub fn init(level: LevelFilter) -> Result<(), SetLoggerError> {
+    pub fn init(level: LevelFilter, log_file: Option<JsonLogFile>) -> Result<(), SetLoggerError> {
         set_max_level(level);
-        set_boxed_logger(Box::new(CliLogger::new(level)))
+        set_boxed_logger(Box::new(CliLogger::new(level, log_file)))
     }
 
     fn extended(&self, record: &Record) {
@@ -144,11 +259,20 @@ impl CliLogger {
     }
 
     fn normal(&self, record: &Record) {
-        println!(":: {}", record.args())
+        println!("{} {}", paint(Role::Prefix, "::"), record.args())
     }
 
     fn error(&self, record: &Record) {
-        eprintln!(":: {}", record.args())
+        let role = if record.level() == Level::Error {
+            Role::Error
+        } else {
+            Role::Warning
+        };
+        eprintln!(
+            "{} {}",
+            paint_stderr(Role::Prefix, "::"),
+            paint_stderr(role, record.args())
+        )
     }
 
     fn progress(&self, record: &Record, stop: bool) {
@@ -162,7 +286,7 @@ impl CliLogger {
                 progress.take().unwrap().finish_with_message(msg)
             }
         } else if let Some(p) = &*progress {
-            p.println(format!(":: → {}", msg))
+            p.println(format!("{} → {}", paint(Role::Prefix, "::"), msg))
         } else {
             #[cfg(not(windows))]
             let finish_str = "✔";
@@ -173,9 +297,13 @@ impl CliLogger {
 
             let pb = ProgressBar::new_spinner();
             pb.set_style(
-                ProgressStyle::with_template(":: {spinner} {msg}")
-                    .unwrap()
-                    .tick_strings(&["—", "\\", "|", "/", finish_str]),
+                ProgressStyle::with_template(&format!(
+                    "{} {{spinner{}}} {{msg}}",
+                    paint(Role::Prefix, "::"),
+                    template_style(Role::Success)
+                ))
+                .unwrap()
+                .tick_strings(&["—", "\\", "|", "/", finish_str]),
             );
             pb.set_draw_target(ProgressDrawTarget::stdout());
             pb.enable_steady_tick(Duration::from_millis(200));
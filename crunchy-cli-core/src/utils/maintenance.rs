@@ -0,0 +1,72 @@
+use chrono::{DateTime, Utc};
+use reqwest::header::{CONTENT_TYPE, RETRY_AFTER};
+use reqwest::{Response, StatusCode};
+use std::fmt::{Display, Formatter};
+use std::time::Duration;
+
+/// How long is waited before a request is retried if Crunchyroll doesn't advertise a retry time.
+pub const DEFAULT_RETRY_AFTER: Duration = Duration::from_secs(30);
+/// Maximal time which is waited in total for Crunchyroll to become available again before giving
+/// up.
+pub const MAX_UNAVAILABLE_WAIT: Duration = Duration::from_secs(30 * 60);
+
+/// Crunchyroll (or its cdn) is in maintenance mode or otherwise temporarily unavailable.
+#[derive(Debug)]
+pub struct ServiceUnavailable {
+    /// The time after which a retry should be made, if advertised via the `Retry-After` header.
+    pub retry_after: Option<Duration>,
+}
+
+impl Display for ServiceUnavailable {
+    fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
+        write!(f, "Crunchyroll is temporarily unavailable (maintenance)")?;
+        if let Some(retry_after) = self.retry_after {
+            write!(f, ", retry in {} seconds", retry_after.as_secs())?
+        }
+        Ok(())
+    }
+}
+
+impl std::error::Error for ServiceUnavailable {}
+
+impl ServiceUnavailable {
+    /// Detect if `response` is a maintenance / service unavailable response. During maintenance
+    /// Crunchyroll answers with status 503 and either a html page or a json error.
+    pub fn from_response(response: &Response) -> Option<Self> {
+        if response.status() != StatusCode::SERVICE_UNAVAILABLE {
+            return None;
+        }
+        if let Some(content_type) = response
+            .headers()
+            .get(CONTENT_TYPE)
+            .and_then(|ct| ct.to_str().ok())
+        {
+            if !content_type.contains("html") && !content_type.contains("json") {
+                return None;
+            }
+        }
+
+        let retry_after = response
+            .headers()
+            .get(RETRY_AFTER)
+            .and_then(|ra| ra.to_str().ok())
+            .and_then(parse_retry_after);
+        Some(Self { retry_after })
+    }
+
+    /// The time to wait before the next retry.
+    pub fn wait(&self) -> Duration {
+        self.retry_after
+            .unwrap_or(DEFAULT_RETRY_AFTER)
+            .min(MAX_UNAVAILABLE_WAIT)
+    }
+}
+
+/// Parse a `Retry-After` header value, which is either a number of seconds or a http date.
+fn parse_retry_after(value: &str) -> Option<Duration> {
+    if let Ok(secs) = value.trim().parse::<u64>() {
+        return Some(Duration::from_secs(secs));
+    }
+    let date = DateTime::parse_from_rfc2822(value.trim()).ok()?;
+    (date.with_timezone(&Utc) - Utc::now()).to_std().ok()
+}
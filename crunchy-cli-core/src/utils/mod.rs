@@ -1,15 +1,37 @@
+pub mod availability;
+pub mod cache;
 pub mod clap;
+pub mod completion;
 pub mod context;
+pub mod deprecation;
+pub mod dns;
 pub mod download;
+pub mod endpoint_override;
+pub mod experimental;
 pub mod ffmpeg;
 pub mod filter;
+pub mod fixture;
 pub mod fmt;
 pub mod format;
+pub mod history;
+pub mod hook;
+pub mod i18n;
 pub mod interactive_select;
 pub mod locale;
 pub mod log;
+pub mod maintenance;
+pub mod notify;
 pub mod os;
 pub mod parse;
+pub mod progress;
 pub mod rate_limit;
+pub mod report;
+pub mod resume;
+pub mod retry;
+pub mod signal;
+pub mod skipped;
 pub mod sync;
+pub mod theme;
+pub mod update;
 pub mod video;
+pub mod write;
@@ -0,0 +1,85 @@
+use anyhow::{bail, Result};
+use log::debug;
+use std::process::{Command, Stdio};
+use std::sync::atomic::{AtomicBool, Ordering};
+
+static NOTIFICATIONS_ENABLED: AtomicBool = AtomicBool::new(false);
+
+pub fn enable_notifications() {
+    NOTIFICATIONS_ENABLED.store(true, Ordering::SeqCst)
+}
+
+/// Show a desktop notification, if notifications are enabled. Failures are only logged as the
+/// notification is nothing crunchy-cli depends on.
+pub fn notify<S: AsRef<str>>(message: S) {
+    if !NOTIFICATIONS_ENABLED.load(Ordering::SeqCst) {
+        return;
+    }
+    if let Err(e) = send_notification("crunchy-cli", message.as_ref()) {
+        debug!("Failed to show notification: {}", e)
+    }
+}
+
+#[cfg(target_os = "linux")]
+fn send_notification(title: &str, message: &str) -> Result<()> {
+    let mut command = Command::new("notify-send");
+    command.args(["--app-name", "crunchy-cli", title, message]);
+    run(command)
+}
+
+#[cfg(target_os = "macos")]
+fn send_notification(title: &str, message: &str) -> Result<()> {
+    // title and message are passed as arguments instead of being formatted into the script, so
+    // they don't have to be escaped
+    let mut command = Command::new("osascript");
+    command.args([
+        "-e",
+        "on run argv",
+        "-e",
+        "display notification (item 2 of argv) with title (item 1 of argv)",
+        "-e",
+        "end run",
+        title,
+        message,
+    ]);
+    run(command)
+}
+
+#[cfg(target_os = "windows")]
+fn send_notification(title: &str, message: &str) -> Result<()> {
+    // title and message are passed as environment variables instead of being formatted into the
+    // script, so they don't have to be escaped
+    let script = "\
+        $template = [Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02); \
+        $text = $template.GetElementsByTagName('text'); \
+        $text.Item(0).AppendChild($template.CreateTextNode($env:CRUNCHY_CLI_NOTIFICATION_TITLE)) > $null; \
+        $text.Item(1).AppendChild($template.CreateTextNode($env:CRUNCHY_CLI_NOTIFICATION_MESSAGE)) > $null; \
+        [Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('crunchy-cli').Show([Windows.UI.Notifications.ToastNotification]::new($template))";
+    let mut command = Command::new("powershell");
+    command
+        .args(["-NoProfile", "-NonInteractive", "-Command", script])
+        .env("CRUNCHY_CLI_NOTIFICATION_TITLE", title)
+        .env("CRUNCHY_CLI_NOTIFICATION_MESSAGE", message);
+    run(command)
+}
+
+#[cfg(not(any(target_os = "linux", target_os = "macos", target_os = "windows")))]
+fn send_notification(_title: &str, _message: &str) -> Result<()> {
+    bail!("notifications are not supported on this platform")
+}
+
+#[allow(dead_code)]
+fn run(mut command: Command) -> Result<()> {
+    let output = command
+        .stdin(Stdio::null())
+        .stdout(Stdio::null())
+        .stderr(Stdio::piped())
+        .output()?;
+    if !output.status.success() {
+        bail!(
+            "{}",
+            String::from_utf8_lossy(&output.stderr).trim().to_string()
+        )
+    }
+    Ok(())
+}
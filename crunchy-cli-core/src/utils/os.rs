@@ -1,10 +1,13 @@
-use log::debug;
+use crate::utils::cache::cache_options;
+use log::{debug, warn};
 use regex::{Regex, RegexBuilder};
 use std::borrow::Cow;
+use std::fmt::{Display, Formatter};
 use std::io::ErrorKind;
 use std::path::{Path, PathBuf};
 use std::pin::Pin;
 use std::process::{Command, Stdio};
+use std::sync::OnceLock;
 use std::task::{Context, Poll};
 use std::{env, fs, io};
 use tempfile::{Builder, NamedTempFile, TempPath};
@@ -24,6 +27,48 @@ pub fn has_ffmpeg() -> bool {
     }
 }
 
+/// Write a file which only the current user may read, e.g. because it contains credentials. The
+/// permissions of an already existing file are restricted too.
+pub fn write_private<P: AsRef<Path>, C: AsRef<[u8]>>(path: P, contents: C) -> io::Result<()> {
+    #[cfg(not(target_os = "windows"))]
+    {
+        use std::io::Write;
+        use std::os::unix::fs::{OpenOptionsExt, PermissionsExt};
+
+        let mut file = fs::OpenOptions::new()
+            .write(true)
+            .create(true)
+            .truncate(true)
+            .mode(0o600)
+            .open(path.as_ref())?;
+        file.set_permissions(fs::Permissions::from_mode(0o600))?;
+        file.write_all(contents.as_ref())
+    }
+    #[cfg(target_os = "windows")]
+    fs::write(path, contents)
+}
+
+/// Write a file atomically. The content is written to a temporary file in the same directory
+/// first, which is then renamed to `path`, so readers (and processes which are killed while writing)
+/// never see a half written file.
+pub fn write_atomic<P: AsRef<Path>, C: AsRef<[u8]>>(path: P, contents: C) -> io::Result<()> {
+    use std::io::Write;
+
+    let path = path.as_ref();
+    let dir = match path.parent() {
+        Some(parent) if !parent.as_os_str().is_empty() => parent,
+        _ => Path::new("."),
+    };
+    let mut file = Builder::default()
+        .prefix(".crunchy-cli_")
+        .suffix(".tmp")
+        .tempfile_in(dir)?;
+    file.write_all(contents.as_ref())?;
+    file.as_file().sync_all()?;
+    file.persist(path).map_err(|e| e.error)?;
+    Ok(())
+}
+
 /// Get the temp directory either by the specified `CRUNCHY_CLI_TEMP_DIR` env variable or the dir
 /// provided by the os.
 pub fn temp_directory() -> PathBuf {
@@ -47,7 +92,11 @@ pub fn tempfile<S: AsRef<str>>(suffix: S) -> io::Result<NamedTempFile> {
 }
 
 pub fn cache_dir<S: AsRef<str>>(name: S) -> io::Result<PathBuf> {
-    let cache_dir = temp_directory().join(format!(".crunchy-cli_{}_cache", name.as_ref()));
+    let cache_dir = if let Some(dir) = &cache_options().dir {
+        dir.join(name.as_ref())
+    } else {
+        temp_directory().join(format!(".crunchy-cli_{}_cache", name.as_ref()))
+    };
     fs::create_dir_all(&cache_dir)?;
     Ok(cache_dir)
 }
@@ -170,6 +219,89 @@ pub fn free_file(mut path: PathBuf) -> (PathBuf, bool) {
     (path, i != 0)
 }
 
+/// Move a corrupt state file (e.g. a truncated json file after an unclean shutdown) out of the way,
+/// so that it gets recreated instead of failing every following run. The file is kept as
+/// `<name>.corrupt` for inspection instead of being deleted.
+pub fn quarantine<D: Display>(path: &Path, reason: D) -> io::Result<PathBuf> {
+    let (quarantined, _) = free_file(PathBuf::from(format!("{}.corrupt", path.to_string_lossy())));
+    fs::rename(path, &quarantined)?;
+    warn!(
+        "'{}' is corrupt ({}) and was moved to '{}'",
+        path.to_string_lossy(),
+        reason,
+        quarantined.to_string_lossy()
+    );
+    Ok(quarantined)
+}
+
+/// Advisory lock of an output file. Prevents that multiple crunchy-cli instances (which may even
+/// run on different machines if the output is on a network share) write the same file at the same
+/// time. The lock is released and the lock file removed when this struct is dropped.
+pub struct OutputLock {
+    file: fs::File,
+    path: PathBuf,
+}
+
+impl OutputLock {
+    /// Try to lock the given output path. Returns [`None`] if the path is already locked by
+    /// another process.
+    pub fn acquire(output: &Path) -> io::Result<Option<Self>> {
+        let file_name = output.file_name().unwrap_or_default().to_string_lossy();
+        let path = output.with_file_name(format!(".{}.crunchy-cli.lock", file_name));
+        if let Some(parent) = path.parent() {
+            if !parent.as_os_str().is_empty() {
+                fs::create_dir_all(parent)?
+            }
+        }
+
+        loop {
+            let file = fs::OpenOptions::new()
+                .create(true)
+                .truncate(false)
+                .write(true)
+                .open(&path)?;
+            match fs2::FileExt::try_lock_exclusive(&file) {
+                Ok(_) => (),
+                Err(e) if e.kind() == fs2::lock_contended_error().kind() => return Ok(None),
+                Err(e) => return Err(e),
+            }
+            // the previous owner may have removed the lock file between it was opened and locked
+            // here. the lock is only valid if the path still points to the locked file, else it's
+            // tried again with a new file
+            if is_same_file(&file, &path) {
+                debug!("Locked output file {}", output.to_string_lossy());
+                return Ok(Some(Self { file, path }));
+            }
+        }
+    }
+}
+
+impl Drop for OutputLock {
+    fn drop(&mut self) {
+        let _ = fs2::FileExt::unlock(&self.file);
+        let _ = fs::remove_file(&self.path);
+    }
+}
+
+/// If `path` points to the opened `file`.
+fn is_same_file(file: &fs::File, path: &Path) -> bool {
+    let (Ok(file_metadata), Ok(path_metadata)) = (file.metadata(), fs::metadata(path)) else {
+        return false;
+    };
+    #[cfg(not(target_os = "windows"))]
+    {
+        use std::os::unix::fs::MetadataExt;
+        file_metadata.dev() == path_metadata.dev() && file_metadata.ino() == path_metadata.ino()
+    }
+    // windows has no stable api to get the file id of a path. open files can't be replaced there,
+    // so it's enough to check that the lock file wasn't removed
+    #[cfg(target_os = "windows")]
+    {
+        let _ = (file_metadata, path_metadata);
+        true
+    }
+}
+
 /// Check if the given path is a special file. On Linux this is probably a pipe and on Windows
 /// ¯\_(ツ)_/¯
 pub fn is_special_file<P: AsRef<Path>>(path: P) -> bool {
@@ -190,18 +322,92 @@ lazy_static::lazy_static! {
     static ref RESERVED_RE: Regex = Regex::new(r"^\.+$").unwrap();
 }
 
+static FILENAME_NORMALIZATION: OnceLock<FilenameNormalization> = OnceLock::new();
+
+#[derive(Clone, Copy, Debug, Default, Eq, PartialEq)]
+pub enum FilenameNormalization {
+    #[default]
+    None,
+    /// Convert full-width latin characters, digits and punctuation to their ascii equivalent.
+    Width,
+}
+
+impl Display for FilenameNormalization {
+    fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
+        let value = match self {
+            FilenameNormalization::None => "none",
+            FilenameNormalization::Width => "width",
+        };
+        write!(f, "{}", value)
+    }
+}
+
+impl FilenameNormalization {
+    pub fn parse(s: &str) -> Result<Self, String> {
+        match s.to_lowercase().as_str() {
+            "none" => Ok(Self::None),
+            "width" => Ok(Self::Width),
+            _ => Err(format!("invalid filename normalization '{}'", s)),
+        }
+    }
+
+    fn apply(&self, c: char) -> char {
+        match self {
+            FilenameNormalization::None => c,
+            FilenameNormalization::Width => match c {
+                '\u{FF01}'..='\u{FF5E}' => char::from_u32(c as u32 - 0xFEE0).unwrap(),
+                '\u{3000}' => ' ',
+                _ => c,
+            },
+        }
+    }
+}
+
+pub fn set_filename_normalization(normalization: FilenameNormalization) {
+    let _ = FILENAME_NORMALIZATION.set(normalization);
+}
+
+/// Invisible characters which only control how text is displayed, like the bidi marks which are
+/// common in arabic titles. They make filenames look broken and sort unexpectedly. Zero width
+/// (non-)joiners are kept as they change how some scripts are rendered.
+fn is_invisible_control(c: char) -> bool {
+    matches!(
+        c,
+        '\u{061C}'
+            | '\u{200B}'
+            | '\u{200E}'
+            | '\u{200F}'
+            | '\u{202A}'..='\u{202E}'
+            | '\u{2060}'..='\u{2064}'
+            | '\u{2066}'..='\u{2069}'
+            | '\u{FEFF}'
+    )
+}
+
 /// Sanitizes a filename with the option to include/exclude the path separator from sanitizing.
 pub fn sanitize<S: AsRef<str>>(path: S, include_path_separator: bool, universal: bool) -> String {
-    let path = Cow::from(path.as_ref().trim());
+    let normalization = FILENAME_NORMALIZATION.get().copied().unwrap_or_default();
+    let path: String = path
+        .as_ref()
+        .chars()
+        .filter(|c| !is_invisible_control(*c))
+        .map(|c| normalization.apply(c))
+        .collect();
+    let path = Cow::from(path.trim());
 
     let path = RESERVED_RE.replace(&path, "");
 
-    let collect = |name: String| {
+    let collect = |mut name: String| {
         if name.len() > 255 {
-            name[..255].to_string()
-        } else {
-            name
+            // the name must be cut at a char boundary, cutting in the middle of a multibyte char
+            // (e.g. japanese or arabic characters) would panic
+            let mut end = 255;
+            while !name.is_char_boundary(end) {
+                end -= 1
+            }
+            name.truncate(end)
         }
+        name
     };
 
     if universal || cfg!(windows) {
@@ -1,3 +1,4 @@
+use crate::utils::deprecation::{warn_deprecated, Deprecation};
 use anyhow::{anyhow, bail, Result};
 use crunchyroll_rs::media::Resolution;
 use crunchyroll_rs::{Crunchyroll, MediaCollection, UrlType};
@@ -138,13 +139,20 @@ pub async fn parse_url(
     let old_url_regex = Regex::new(r"https?://(www\.)?crunchyroll\.com/.+").unwrap();
     if old_url_regex.is_match(&url) {
         debug!("Detected maybe old url");
+        // the regex matches new urls too, only urls which can't be parsed without redirect are
+        // actually old ones
+        let is_classic = crunchyroll_rs::parse_url(url.clone()).is_none();
         // replace the 'http' prefix with 'https' as http is not supported by the reqwest client
         if url.starts_with("http://") {
             url.replace_range(0..4, "https")
         }
         // the old url redirects to the new url. request the old url, follow the redirects and
         // extract the final url
-        url = crunchy.client().get(&url).send().await?.url().to_string()
+        let redirected_url = crunchy.client().get(&url).send().await?.url().to_string();
+        if is_classic && redirected_url != url {
+            warn_deprecated(Deprecation::ClassicUrl)
+        }
+        url = redirected_url
     }
 
     let parsed_url = crunchyroll_rs::parse_url(url).ok_or(anyhow!("Invalid url"))?;
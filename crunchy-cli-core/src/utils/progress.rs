@@ -0,0 +1,320 @@
+use crate::utils::fmt::format_file_size;
+use crate::utils::theme::{paint, template_style, Role};
+use indicatif::{HumanDuration, ProgressBar, ProgressDrawTarget, ProgressFinish, ProgressStyle};
+use log::LevelFilter;
+use std::fmt::{Display, Formatter};
+use std::io::{stdout, IsTerminal};
+use std::sync::{Mutex, OnceLock};
+use std::time::{Duration, Instant};
+
+static PROGRESS_OUTPUT: OnceLock<ProgressOutput> = OnceLock::new();
+
+#[derive(Clone, Copy, Debug, Eq, PartialEq)]
+pub enum ProgressOutput {
+    /// Progress bars which are redrawn in place.
+    Bar,
+    /// A single line every 10 percent, for outputs which can't redraw lines (e.g. ci logs).
+    Compact,
+    /// A json object per line at most every second, for GUIs and scripts which render the
+    /// progress themselves.
+    Json,
+    Off,
+}
+
+impl Display for ProgressOutput {
+    fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
+        let value = match self {
+            ProgressOutput::Bar => "bar",
+            ProgressOutput::Compact => "compact",
+            ProgressOutput::Json => "json",
+            ProgressOutput::Off => "off",
+        };
+        write!(f, "{}", value)
+    }
+}
+
+impl ProgressOutput {
+    pub fn parse(s: &str) -> Result<Self, String> {
+        match s.to_lowercase().as_str() {
+            "bar" => Ok(Self::Bar),
+            "compact" => Ok(Self::Compact),
+            "json" => Ok(Self::Json),
+            "off" => Ok(Self::Off),
+            _ => Err(format!("invalid progress output '{}'", s)),
+        }
+    }
+}
+
+pub fn set_progress_output(progress_output: ProgressOutput) {
+    let _ = PROGRESS_OUTPUT.set(progress_output);
+}
+
+fn progress_output() -> ProgressOutput {
+    // verbose output logs the progress as debug messages and quiet output must not show anything
+    if log::max_level() != LevelFilter::Info {
+        return ProgressOutput::Off;
+    }
+    *PROGRESS_OUTPUT.get_or_init(|| {
+        if stdout().is_terminal() {
+            ProgressOutput::Bar
+        } else {
+            ProgressOutput::Compact
+        }
+    })
+}
+
+#[derive(Clone, Copy)]
+pub enum ProgressUnit {
+    Bytes,
+    Frames,
+}
+
+impl Display for ProgressUnit {
+    fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
+        let value = match self {
+            ProgressUnit::Bytes => "bytes",
+            ProgressUnit::Frames => "frames",
+        };
+        write!(f, "{}", value)
+    }
+}
+
+struct CompactProgress {
+    message: String,
+    unit: ProgressUnit,
+    length: u64,
+    position: u64,
+    started: Instant,
+    /// The last 10 percent step which was printed.
+    printed_step: u64,
+}
+
+impl CompactProgress {
+    fn update(&mut self) {
+        let step = (self.position * 10)
+            .checked_div(self.length)
+            .unwrap_or_default()
+            .min(10);
+        if step <= self.printed_step {
+            return;
+        }
+        self.printed_step = step;
+
+        let elapsed = self.started.elapsed();
+        let (position, speed) = match self.unit {
+            ProgressUnit::Bytes => (
+                format_file_size(self.position),
+                format!(
+                    "{}/s",
+                    format_file_size(
+                        (self.position as f64 / elapsed.as_secs_f64().max(1.0)) as u64
+                    )
+                ),
+            ),
+            ProgressUnit::Frames => (
+                format!("{} frames", self.position),
+                format!(
+                    "{:.0} fps",
+                    self.position as f64 / elapsed.as_secs_f64().max(1.0)
+                ),
+            ),
+        };
+        let eta = if self.position > 0 && self.position < self.length {
+            let remaining =
+                elapsed.as_secs_f64() * (self.length - self.position) as f64 / self.position as f64;
+            format!(
+                ", ETA {}",
+                HumanDuration(Duration::from_secs_f64(remaining))
+            )
+        } else {
+            "".to_string()
+        };
+        println!(
+            "{} {} {:>3}% ({}, {}{})",
+            paint(Role::Prefix, "::"),
+            self.message,
+            step * 10,
+            position,
+            paint(Role::Speed, speed),
+            eta
+        )
+    }
+}
+
+struct JsonProgress {
+    message: String,
+    unit: ProgressUnit,
+    length: u64,
+    position: u64,
+    /// Done and total number of segments, if the progress belongs to a segment download.
+    segments: Option<(usize, usize)>,
+    started: Instant,
+    printed: Option<Instant>,
+}
+
+impl JsonProgress {
+    fn update(&mut self) {
+        let finished = self.length > 0 && self.position >= self.length;
+        if !finished
+            && self
+                .printed
+                .is_some_and(|printed| printed.elapsed() < Duration::from_secs(1))
+        {
+            return;
+        }
+        self.printed = Some(Instant::now());
+
+        let elapsed = self.started.elapsed().as_secs_f64();
+        let speed = self.position as f64 / elapsed.max(1.0);
+        let eta = (self.position > 0 && self.position < self.length).then(|| {
+            (elapsed * (self.length - self.position) as f64 / self.position as f64).round() as u64
+        });
+        // stdout is used by log messages (and e.g. `-o -`), keeping the json on stderr makes it
+        // parseable without filtering
+        eprintln!(
+            "{}",
+            serde_json::json!({
+                "message": self.message,
+                "unit": self.unit.to_string(),
+                "position": self.position,
+                "length": self.length,
+                "segments_done": self.segments.map(|(done, _)| done),
+                "segments_total": self.segments.map(|(_, total)| total),
+                "speed": speed as u64,
+                "eta": eta,
+            })
+        )
+    }
+}
+
+/// Progress of a long running task, rendered as configured via `--progress`.
+pub struct Progress {
+    bar: Option<ProgressBar>,
+    compact: Option<Mutex<CompactProgress>>,
+    json: Option<Mutex<JsonProgress>>,
+}
+
+impl Progress {
+    pub fn new<S: AsRef<str>>(length: u64, unit: ProgressUnit, message: S) -> Self {
+        match progress_output() {
+            ProgressOutput::Bar => {
+                let template = match unit {
+                    ProgressUnit::Bytes => format!(
+                        "{} {{msg}} {{bytes:>10}} {{bytes_per_sec:>12{}}} [{{wide_bar}}] {{percent:>3}}% {{eta:>4}}",
+                        paint(Role::Prefix, "::"),
+                        template_style(Role::Speed)
+                    ),
+                    ProgressUnit::Frames => format!(
+                        "{} {{msg}} [{{wide_bar}}] {{percent:>3}}% {{eta:>4}}",
+                        paint(Role::Prefix, "::")
+                    ),
+                };
+                let bar = ProgressBar::new(length)
+                    .with_style(
+                        ProgressStyle::with_template(&template)
+                            .unwrap()
+                            .progress_chars("##-"),
+                    )
+                    .with_message(message.as_ref().to_string())
+                    .with_finish(ProgressFinish::Abandon);
+                if let ProgressUnit::Frames = unit {
+                    bar.set_draw_target(ProgressDrawTarget::stdout());
+                    bar.enable_steady_tick(Duration::from_millis(200));
+                }
+                Self {
+                    bar: Some(bar),
+                    compact: None,
+                    json: None,
+                }
+            }
+            ProgressOutput::Compact => Self {
+                bar: None,
+                compact: Some(Mutex::new(CompactProgress {
+                    message: message.as_ref().trim_end().to_string(),
+                    unit,
+                    length,
+                    position: 0,
+                    started: Instant::now(),
+                    printed_step: 0,
+                })),
+                json: None,
+            },
+            ProgressOutput::Json => Self {
+                bar: None,
+                compact: None,
+                json: Some(Mutex::new(JsonProgress {
+                    message: message.as_ref().trim_end().to_string(),
+                    unit,
+                    length,
+                    position: 0,
+                    segments: None,
+                    started: Instant::now(),
+                    printed: None,
+                })),
+            },
+            ProgressOutput::Off => Self {
+                bar: None,
+                compact: None,
+                json: None,
+            },
+        }
+    }
+
+    pub fn length(&self) -> u64 {
+        if let Some(bar) = &self.bar {
+            bar.length().unwrap_or_default()
+        } else if let Some(compact) = &self.compact {
+            compact.lock().unwrap().length
+        } else if let Some(json) = &self.json {
+            json.lock().unwrap().length
+        } else {
+            0
+        }
+    }
+
+    pub fn set_length(&self, length: u64) {
+        if let Some(bar) = &self.bar {
+            bar.set_length(length)
+        } else if let Some(compact) = &self.compact {
+            compact.lock().unwrap().length = length
+        } else if let Some(json) = &self.json {
+            json.lock().unwrap().length = length
+        }
+    }
+
+    pub fn inc(&self, delta: u64) {
+        if let Some(bar) = &self.bar {
+            bar.inc(delta)
+        } else if let Some(compact) = &self.compact {
+            let mut compact = compact.lock().unwrap();
+            compact.position += delta;
+            compact.update()
+        } else if let Some(json) = &self.json {
+            let mut json = json.lock().unwrap();
+            json.position += delta;
+            json.update()
+        }
+    }
+
+    pub fn set_position(&self, position: u64) {
+        if let Some(bar) = &self.bar {
+            bar.set_position(position)
+        } else if let Some(compact) = &self.compact {
+            let mut compact = compact.lock().unwrap();
+            compact.position = position;
+            compact.update()
+        } else if let Some(json) = &self.json {
+            let mut json = json.lock().unwrap();
+            json.position = position;
+            json.update()
+        }
+    }
+
+    /// Set the number of downloaded segments. Only shown with the json output, the bars and compact
+    /// lines show the downloaded bytes only.
+    pub fn set_segments(&self, done: usize, total: usize) {
+        if let Some(json) = &self.json {
+            json.lock().unwrap().segments = Some((done, total))
+        }
+    }
+}
@@ -7,6 +7,9 @@ use std::io;
 use std::pin::Pin;
 use std::sync::Arc;
 use std::task::{Context, Poll};
+use std::time::Duration;
+use tokio::sync::Mutex;
+use tokio::time::Instant;
 use tower_service::Service;
 
 #[derive(Clone)]
@@ -71,3 +74,54 @@ impl Service<Request> for RateLimiterService {
         })
     }
 }
+
+/// Limits how many requests per second are sent. Requests which exceed the limit are delayed until
+/// they're allowed, they're never rejected.
+#[derive(Clone)]
+pub struct RequestLimiter {
+    interval: Duration,
+    /// Earliest time the next request may be sent.
+    next: Arc<Mutex<Instant>>,
+}
+
+impl RequestLimiter {
+    pub fn new(requests_per_second: f64) -> Self {
+        Self {
+            interval: Duration::from_secs_f64(1.0 / requests_per_second),
+            next: Arc::new(Mutex::new(Instant::now())),
+        }
+    }
+
+    /// Wait until the next request is allowed.
+    pub async fn wait(&self) {
+        let at = {
+            let mut next = self.next.lock().await;
+            let at = (*next).max(Instant::now());
+            *next = at + self.interval;
+            at
+        };
+        tokio::time::sleep_until(at).await
+    }
+}
+
+/// Separate request limits for the cms endpoints (series, seasons, episodes, ...), which are the
+/// ones called the most when resolving big catalogs, and all other api endpoints.
+#[derive(Clone, Default)]
+pub struct RequestLimits {
+    pub cms: Option<RequestLimiter>,
+    pub other: Option<RequestLimiter>,
+}
+
+impl RequestLimits {
+    pub fn is_empty(&self) -> bool {
+        self.cms.is_none() && self.other.is_none()
+    }
+
+    pub fn limiter(&self, url: &str) -> Option<RequestLimiter> {
+        if url.contains("/cms/") {
+            self.cms.clone()
+        } else {
+            self.other.clone()
+        }
+    }
+}
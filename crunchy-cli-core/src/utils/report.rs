@@ -0,0 +1,153 @@
+use anyhow::{bail, Result};
+use log::debug;
+use reqwest::Client;
+use serde::Serialize;
+use std::io::Write;
+use std::process::{Command, Stdio};
+use std::sync::OnceLock;
+
+static ERROR_REPORTER: OnceLock<Box<dyn ErrorReporter>> = OnceLock::new();
+
+#[derive(Clone, Debug, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ErrorReportKind {
+    /// The program panicked.
+    Panic,
+    /// A response of the Crunchyroll api could not be decoded. This is very likely because
+    /// Crunchyroll changed their api.
+    Decode,
+    /// A request failed, even after retrying it.
+    Request,
+    /// Any other error.
+    Other,
+}
+
+#[derive(Clone, Debug, Serialize)]
+pub struct ErrorReport {
+    pub kind: ErrorReportKind,
+    pub message: String,
+    pub version: String,
+    pub timestamp: String,
+}
+
+impl ErrorReport {
+    pub fn new(kind: ErrorReportKind, message: String) -> Self {
+        Self {
+            kind,
+            message,
+            version: env!("CARGO_PKG_VERSION").to_string(),
+            timestamp: chrono::Utc::now().to_rfc3339(),
+        }
+    }
+
+    pub fn from_error(error: &anyhow::Error) -> Self {
+        let kind = match error.downcast_ref::<crunchyroll_rs::error::Error>() {
+            Some(crunchyroll_rs::error::Error::Decode { .. }) => ErrorReportKind::Decode,
+            Some(crunchyroll_rs::error::Error::Request { .. }) => ErrorReportKind::Request,
+            _ => ErrorReportKind::Other,
+        };
+        Self::new(kind, error.to_string())
+    }
+}
+
+/// Gets invoked on unexpected errors so that users which are running crunchy-cli unattended
+/// (e.g. via cron) get notified if something breaks.
+pub trait ErrorReporter: Send + Sync {
+    fn report(&self, report: &ErrorReport) -> Result<()>;
+}
+
+/// Sends the report as json via a post request to an url.
+pub struct WebhookErrorReporter {
+    url: String,
+    client: Client,
+}
+
+impl WebhookErrorReporter {
+    pub fn new(url: String, client: Client) -> Self {
+        Self { url, client }
+    }
+}
+
+impl ErrorReporter for WebhookErrorReporter {
+    fn report(&self, report: &ErrorReport) -> Result<()> {
+        let url = self.url.clone();
+        let client = self.client.clone();
+        let body = serde_json::to_string(report)?;
+
+        // reporting must also work from non-async contexts (like the panic hook) and from inside
+        // the already running tokio runtime, which can't be blocked. so the request is executed in
+        // a separate thread with its own runtime
+        std::thread::spawn(move || -> Result<()> {
+            let runtime = tokio::runtime::Builder::new_current_thread()
+                .enable_all()
+                .build()?;
+            runtime.block_on(async move {
+                client
+                    .post(url)
+                    .header("Content-Type", "application/json")
+                    .body(body)
+                    .send()
+                    .await?
+                    .error_for_status()?;
+                Ok(())
+            })
+        })
+        .join()
+        .map_err(|_| anyhow::anyhow!("error report thread panicked"))?
+    }
+}
+
+/// Executes a command and passes the report as json via stdin to it.
+pub struct CommandErrorReporter {
+    command: Vec<String>,
+}
+
+impl CommandErrorReporter {
+    pub fn new(command: &str) -> Result<Self> {
+        let Some(command) = shlex::split(command).filter(|c| !c.is_empty()) else {
+            bail!("invalid error report command '{}'", command)
+        };
+        Ok(Self { command })
+    }
+}
+
+impl ErrorReporter for CommandErrorReporter {
+    fn report(&self, report: &ErrorReport) -> Result<()> {
+        let mut child = Command::new(&self.command[0])
+            .args(&self.command[1..])
+            .stdin(Stdio::piped())
+            .stdout(Stdio::null())
+            .spawn()?;
+        if let Some(mut stdin) = child.stdin.take() {
+            stdin.write_all(serde_json::to_string(report)?.as_bytes())?;
+        }
+        let status = child.wait()?;
+        if !status.success() {
+            bail!("error report command exited with {}", status)
+        }
+        Ok(())
+    }
+}
+
+/// Set the global error reporter. Panics are reported too after this was called.
+pub fn set_error_reporter(error_reporter: Box<dyn ErrorReporter>) {
+    if ERROR_REPORTER.set(error_reporter).is_err() {
+        return;
+    }
+
+    let default_hook = std::panic::take_hook();
+    std::panic::set_hook(Box::new(move |info| {
+        report_error(ErrorReport::new(ErrorReportKind::Panic, info.to_string()));
+        default_hook(info)
+    }))
+}
+
+/// Report an error via the global error reporter, if one is set.
+pub fn report_error(report: ErrorReport) {
+    let Some(error_reporter) = ERROR_REPORTER.get() else {
+        return;
+    };
+    if let Err(e) = error_reporter.report(&report) {
+        debug!("Failed to report error: {}", e)
+    }
+}
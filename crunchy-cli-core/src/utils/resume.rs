@@ -0,0 +1,127 @@
+use crate::utils::os::{quarantine, write_atomic};
+use anyhow::Result;
+use log::debug;
+use serde::{Deserialize, Serialize};
+use std::fs;
+use std::ops::Deref;
+use std::path::{Path, PathBuf};
+use tempfile::TempPath;
+
+/// Path of a downloaded stream. Temporary files are deleted when they're dropped, part files of
+/// resumable downloads are kept until the output file was generated successfully.
+pub(crate) enum StreamPath {
+    Temp(TempPath),
+    Part(PathBuf),
+}
+
+impl Deref for StreamPath {
+    type Target = Path;
+
+    fn deref(&self) -> &Self::Target {
+        match self {
+            StreamPath::Temp(path) => path.as_ref(),
+            StreamPath::Part(path) => path.as_ref(),
+        }
+    }
+}
+
+/// Directory which contains the part files of `dst`. It's placed next to the output file, so that
+/// it survives reboots and cleanups of the temp directory.
+pub(crate) fn resume_dir(dst: &Path) -> PathBuf {
+    let file_name = dst.file_name().unwrap_or_default().to_string_lossy();
+    dst.with_file_name(format!(".{}.parts", file_name))
+}
+
+#[derive(Debug, Deserialize, Serialize)]
+struct ResumeManifest {
+    segments: usize,
+    bandwidth: u64,
+    done: usize,
+    size: u64,
+}
+
+/// Progress of a partially downloaded stream. The progress is stored as json next to the part
+/// file and updated after every segment which was completely written to it.
+pub(crate) struct ResumeFile {
+    path: PathBuf,
+    manifest_path: PathBuf,
+    manifest: ResumeManifest,
+}
+
+impl ResumeFile {
+    /// Load the progress of the part file `path`. If there is no progress or it belongs to another
+    /// stream (e.g. because a different resolution was requested), the download starts from the
+    /// beginning.
+    pub(crate) fn load(path: PathBuf, segments: usize, bandwidth: u64) -> Result<Self> {
+        let manifest_path = PathBuf::from(format!("{}.json", path.to_string_lossy()));
+        let mut manifest = ResumeManifest {
+            segments,
+            bandwidth,
+            done: 0,
+            size: 0,
+        };
+
+        if let Ok(content) = fs::read(&manifest_path) {
+            let file_len = path.metadata().map(|m| m.len()).unwrap_or_default();
+            match serde_json::from_slice::<ResumeManifest>(&content) {
+                Ok(stored)
+                    if stored.segments == segments
+                        && stored.bandwidth == bandwidth
+                        && stored.size <= file_len =>
+                {
+                    debug!(
+                        "Resuming {} at segment {}/{}",
+                        path.to_string_lossy(),
+                        stored.done,
+                        stored.segments
+                    );
+                    manifest = stored
+                }
+                Ok(_) => debug!(
+                    "Progress of {} doesn't match the stream, starting from the beginning",
+                    path.to_string_lossy()
+                ),
+                Err(e) => {
+                    quarantine(&manifest_path, e)?;
+                }
+            }
+        }
+
+        if let Some(parent) = path.parent() {
+            fs::create_dir_all(parent)?
+        }
+        Ok(Self {
+            path,
+            manifest_path,
+            manifest,
+        })
+    }
+
+    pub(crate) fn path(&self) -> &Path {
+        &self.path
+    }
+
+    /// Number of segments which are already written to the part file.
+    pub(crate) fn done(&self) -> usize {
+        self.manifest.done
+    }
+
+    /// Size of the part file after the last completely written segment. Everything behind it
+    /// belongs to a segment which was interrupted while writing and must be discarded.
+    pub(crate) fn size(&self) -> u64 {
+        self.manifest.size
+    }
+
+    pub(crate) fn is_complete(&self) -> bool {
+        self.manifest.done >= self.manifest.segments
+    }
+
+    /// Record that the next segment with `len` bytes was written. The segment must be flushed to
+    /// the part file before this is called.
+    pub(crate) fn segment_written(&mut self, len: u64) -> Result<()> {
+        self.manifest.done += 1;
+        self.manifest.size += len;
+        write_atomic(&self.manifest_path, serde_json::to_vec(&self.manifest)?)?;
+        Ok(())
+    }
+}
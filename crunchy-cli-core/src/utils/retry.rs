@@ -0,0 +1,80 @@
+use reqwest::{Method, Request, Response};
+use std::time::Duration;
+
+/// Timeout and retries of api requests. Only requests which don't change anything are retried,
+/// mutations like playhead updates or watchlist changes are sent only once, as a request which
+/// timed out might have been applied anyway.
+#[derive(Clone, Debug, Default)]
+pub struct RequestPolicy {
+    pub timeout: Option<Duration>,
+    pub retries: u32,
+}
+
+impl RequestPolicy {
+    pub fn is_default(&self) -> bool {
+        self.timeout.is_none() && self.retries == 0
+    }
+
+    /// Number of times `req` may be retried.
+    pub fn retries(&self, req: &Request) -> u32 {
+        if req.method() == Method::GET {
+            self.retries
+        } else {
+            0
+        }
+    }
+
+    /// If a request should be retried because of `res`. Client errors (4xx) are never retried as
+    /// the same request would fail again.
+    pub fn should_retry<E>(res: &Result<Response, E>) -> bool {
+        match res {
+            Ok(response) => response.status().is_server_error(),
+            Err(_) => true,
+        }
+    }
+
+    /// Time to wait before the `retry_count`th retry.
+    pub fn backoff(retry_count: u32) -> Duration {
+        Duration::from_secs(2u64.saturating_pow(retry_count - 1).min(30))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn backoff() {
+        assert_eq!(RequestPolicy::backoff(1), Duration::from_secs(1));
+        assert_eq!(RequestPolicy::backoff(2), Duration::from_secs(2));
+        assert_eq!(RequestPolicy::backoff(5), Duration::from_secs(16));
+        assert_eq!(RequestPolicy::backoff(6), Duration::from_secs(30));
+        assert_eq!(RequestPolicy::backoff(100), Duration::from_secs(30))
+    }
+
+    #[test]
+    fn retries() {
+        let policy = RequestPolicy {
+            timeout: None,
+            retries: 3,
+        };
+        let url = "https://www.crunchyroll.com".parse().unwrap();
+        assert_eq!(policy.retries(&Request::new(Method::GET, url.clone())), 3);
+        assert_eq!(policy.retries(&Request::new(Method::POST, url)), 0)
+    }
+
+    #[test]
+    fn should_retry() {
+        let response = |status: u16| -> Result<Response, ()> {
+            Ok(http::Response::builder()
+                .status(status)
+                .body("")
+                .unwrap()
+                .into())
+        };
+        assert!(RequestPolicy::should_retry(&response(503)));
+        assert!(!RequestPolicy::should_retry(&response(404)));
+        assert!(!RequestPolicy::should_retry(&response(200)));
+        assert!(RequestPolicy::should_retry::<()>(&Err(())))
+    }
+}
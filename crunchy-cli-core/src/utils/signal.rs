@@ -0,0 +1,158 @@
+use dialoguer::console::Term;
+use log::{debug, warn};
+use std::process::{Command, Output, Stdio};
+use std::sync::atomic::{AtomicBool, AtomicUsize, Ordering};
+use std::sync::Mutex;
+use std::{env, fs, io};
+
+/// Number of videos which are currently downloaded. Signals are only handled gracefully while a
+/// download is running, in every other state (e.g. interactive prompts) they exit immediately.
+static ACTIVE_JOBS: AtomicUsize = AtomicUsize::new(0);
+static STOP_REQUESTED: AtomicBool = AtomicBool::new(false);
+/// Processes which were detached from the signals of the terminal and must be killed manually if
+/// crunchy-cli is forced to exit.
+static DETACHED_PROCESSES: Mutex<Vec<u32>> = Mutex::new(vec![]);
+
+/// Marks that a video is downloaded as long as it's alive.
+pub(crate) struct Job;
+
+impl Job {
+    pub(crate) fn start() -> Self {
+        ACTIVE_JOBS.fetch_add(1, Ordering::SeqCst);
+        Self
+    }
+}
+
+impl Drop for Job {
+    fn drop(&mut self) {
+        ACTIVE_JOBS.fetch_sub(1, Ordering::SeqCst);
+    }
+}
+
+/// If a ctrl-c or termination signal was received while a video was downloaded. Commands should
+/// stop before starting the next video then.
+pub(crate) fn stop_requested() -> bool {
+    STOP_REQUESTED.load(Ordering::SeqCst)
+}
+
+/// Prevent that a ctrl-c in the terminal is also sent to the spawned process, so that e.g. ffmpeg
+/// can finish the current video if crunchy-cli should stop gracefully.
+pub(crate) fn detach_from_signals(command: &mut Command) -> &mut Command {
+    // a process in another process group gets suspended if it reads from the terminal
+    command.stdin(Stdio::null());
+    #[cfg(not(target_os = "windows"))]
+    {
+        use std::os::unix::process::CommandExt;
+        command.process_group(0)
+    }
+    #[cfg(target_os = "windows")]
+    {
+        use std::os::windows::process::CommandExt;
+        const CREATE_NEW_PROCESS_GROUP: u32 = 0x00000200;
+        command.creation_flags(CREATE_NEW_PROCESS_GROUP)
+    }
+}
+
+pub(crate) fn register_detached_process(pid: u32) {
+    DETACHED_PROCESSES.lock().unwrap().push(pid)
+}
+
+pub(crate) fn unregister_detached_process(pid: u32) {
+    DETACHED_PROCESSES.lock().unwrap().retain(|p| *p != pid)
+}
+
+/// Like [`Command::output`], but the process is detached from signals (see
+/// [`detach_from_signals`]) and killed if crunchy-cli is forced to exit. Unlike
+/// [`Command::output`], stdout and stderr are inherited if they're not set explicitly.
+pub(crate) fn detached_output(command: &mut Command) -> io::Result<Output> {
+    let child = detach_from_signals(command).spawn()?;
+    let pid = child.id();
+    register_detached_process(pid);
+    let output = child.wait_with_output();
+    unregister_detached_process(pid);
+    output
+}
+
+/// Handle ctrl-c and termination signals (`SIGTERM`, e.g. sent by `docker stop`). If a video is
+/// downloaded, the first signal lets it finish and stops afterwards, so no partial files are left
+/// behind. A second signal (or a signal while nothing is downloaded) exits immediately.
+pub fn set_signal_handler() {
+    ctrlc::set_handler(move || {
+        debug!("Ctrl-c detected");
+        if ACTIVE_JOBS.load(Ordering::SeqCst) > 0 && !STOP_REQUESTED.swap(true, Ordering::SeqCst) {
+            warn!("Stopping after the current video. Press ctrl-c again to exit immediately");
+            return;
+        }
+
+        for pid in DETACHED_PROCESSES.lock().unwrap().drain(..) {
+            kill(pid)
+        }
+        remove_temp_files();
+        // when pressing ctrl-c while interactively choosing seasons the cursor stays hidden, this
+        // line shows it again
+        let _ = Term::stdout().show_cursor();
+        std::process::exit(1)
+    })
+    .unwrap();
+}
+
+/// Kill a detached process, including all processes it spawned itself.
+pub(crate) fn kill(pid: u32) {
+    // detached processes are the leader of their own process group, killing the group also kills
+    // processes they spawned themselves (e.g. the commands of a `sh -c` post process hook)
+    #[cfg(not(target_os = "windows"))]
+    let result = nix::sys::signal::killpg(
+        nix::unistd::Pid::from_raw(pid as i32),
+        nix::sys::signal::Signal::SIGKILL,
+    )
+    .map_err(|e| e.to_string());
+    #[cfg(target_os = "windows")]
+    let result = Command::new("taskkill")
+        .args(["/F", "/T", "/PID", &pid.to_string()])
+        .output()
+        .map(|_| ())
+        .map_err(|e| e.to_string());
+
+    if let Err(e) = result {
+        debug!("Failed to kill process {}: {}", pid, e)
+    }
+}
+
+fn remove_temp_files() {
+    if let Ok(dir) = fs::read_dir(env::temp_dir()) {
+        for file in dir.flatten() {
+            if file
+                .path()
+                .file_name()
+                .unwrap_or_default()
+                .to_str()
+                .unwrap_or_default()
+                .starts_with(".crunchy-cli_")
+            {
+                if file.file_type().map_or(true, |ft| ft.is_file()) {
+                    let result = fs::remove_file(file.path());
+                    debug!(
+                        "Ctrl-c removed temporary file {} {}",
+                        file.path().to_string_lossy(),
+                        if result.is_ok() {
+                            "successfully"
+                        } else {
+                            "not successfully"
+                        }
+                    )
+                } else {
+                    let result = fs::remove_dir_all(file.path());
+                    debug!(
+                        "Ctrl-c removed temporary directory {} {}",
+                        file.path().to_string_lossy(),
+                        if result.is_ok() {
+                            "successfully"
+                        } else {
+                            "not successfully"
+                        }
+                    )
+                }
+            }
+        }
+    }
+}
@@ -0,0 +1,183 @@
+use crate::utils::format::SingleFormat;
+use crate::utils::os::{quarantine, write_atomic};
+use anyhow::Result;
+use crunchyroll_rs::error::Error;
+use reqwest::StatusCode;
+use serde::{Deserialize, Serialize};
+use std::fs;
+use std::path::PathBuf;
+
+/// A video which was skipped because it couldn't be downloaded.
+#[derive(Clone, Debug, Deserialize, Serialize)]
+pub struct SkippedEntry {
+    pub identifier: String,
+    pub url: String,
+    pub series_name: String,
+    pub season_number: u32,
+    pub episode_number: String,
+    pub title: String,
+    pub audio: String,
+    pub reason: String,
+}
+
+/// Keeps track of all videos which were skipped because they couldn't be downloaded (e.g. because
+/// of region restrictions or missing premium), so that they can be retried later.
+pub struct SkippedManifest {
+    path: PathBuf,
+    entries: Vec<SkippedEntry>,
+}
+
+impl SkippedManifest {
+    /// Load the manifest from the given path. If the file doesn't exist yet or is corrupt, the
+    /// manifest is empty.
+    pub fn load(path: PathBuf) -> Result<Self> {
+        let entries = if path.exists() {
+            match serde_json::from_str(&fs::read_to_string(&path)?) {
+                Ok(entries) => entries,
+                Err(e) => {
+                    quarantine(&path, e)?;
+                    vec![]
+                }
+            }
+        } else {
+            vec![]
+        };
+        Ok(Self { path, entries })
+    }
+
+    /// Urls of all skipped videos.
+    pub fn urls(&self) -> Vec<String> {
+        let mut urls: Vec<String> = vec![];
+        for entry in &self.entries {
+            if !urls.contains(&entry.url) {
+                urls.push(entry.url.clone())
+            }
+        }
+        urls
+    }
+
+    pub fn add(&mut self, format: &SingleFormat, reason: String) {
+        self.entries.retain(|e| {
+            !(e.identifier == format.identifier && e.audio == format.audio.to_string())
+        });
+        self.entries.push(SkippedEntry {
+            identifier: format.identifier.clone(),
+            url: format.url(),
+            series_name: format.series_name.clone(),
+            season_number: format.season_number,
+            episode_number: format.episode_number.clone(),
+            title: format.title.clone(),
+            audio: format.audio.to_string(),
+            reason,
+        })
+    }
+
+    /// Remove all entries of a video, e.g. because it was downloaded successfully.
+    pub fn remove(&mut self, identifier: &str) {
+        self.entries.retain(|e| e.identifier != identifier)
+    }
+
+    pub fn save(&self) -> Result<()> {
+        if let Some(parent) = self.path.parent() {
+            if !parent.as_os_str().is_empty() {
+                fs::create_dir_all(parent)?
+            }
+        }
+        write_atomic(&self.path, serde_json::to_string_pretty(&self.entries)?)?;
+        Ok(())
+    }
+}
+
+/// Check if `error` means that a video isn't available with the current account or in the current
+/// region, e.g. because it is premium only or region locked. Only these videos should be skipped,
+/// retrying them later may succeed. Every other error (network, ffmpeg, authentication, ...) is a
+/// real failure.
+pub fn is_unavailable(error: &anyhow::Error) -> bool {
+    matches!(
+        error.downcast_ref::<Error>(),
+        Some(Error::Request {
+            status: Some(
+                StatusCode::FORBIDDEN
+                    | StatusCode::NOT_FOUND
+                    | StatusCode::UNAVAILABLE_FOR_LEGAL_REASONS
+            ),
+            ..
+        })
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn entry(identifier: &str, url: &str) -> SkippedEntry {
+        SkippedEntry {
+            identifier: identifier.to_string(),
+            url: url.to_string(),
+            series_name: "Series".to_string(),
+            season_number: 1,
+            episode_number: "1".to_string(),
+            title: "Title".to_string(),
+            audio: "ja-JP".to_string(),
+            reason: "premium only".to_string(),
+        }
+    }
+
+    #[test]
+    fn round_trip() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("skipped").join("manifest.json");
+        let mut manifest = SkippedManifest {
+            path: path.clone(),
+            entries: vec![
+                entry("a", "https://www.crunchyroll.com/watch/A"),
+                entry("b", "https://www.crunchyroll.com/watch/B"),
+                entry("b", "https://www.crunchyroll.com/watch/B"),
+            ],
+        };
+        manifest.remove("a");
+        manifest.save().unwrap();
+
+        let loaded = SkippedManifest::load(path).unwrap();
+        assert_eq!(loaded.entries.len(), 2);
+        assert_eq!(loaded.entries[0].reason, "premium only");
+        assert_eq!(loaded.urls(), vec!["https://www.crunchyroll.com/watch/B"])
+    }
+
+    #[test]
+    fn load_missing() {
+        let dir = tempfile::tempdir().unwrap();
+        let manifest = SkippedManifest::load(dir.path().join("manifest.json")).unwrap();
+        assert!(manifest.entries.is_empty())
+    }
+
+    #[test]
+    fn load_corrupt() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("manifest.json");
+        fs::write(&path, "[{").unwrap();
+
+        let manifest = SkippedManifest::load(path.clone()).unwrap();
+        assert!(manifest.entries.is_empty());
+        assert!(!path.exists());
+        assert!(dir.path().join("manifest.json.corrupt").exists())
+    }
+
+    #[test]
+    fn unavailable() {
+        let request_error = |status| {
+            anyhow::Error::from(Error::Request {
+                message: String::new(),
+                status,
+                url: String::new(),
+            })
+        };
+        assert!(is_unavailable(&request_error(Some(StatusCode::FORBIDDEN))));
+        assert!(is_unavailable(&request_error(Some(StatusCode::NOT_FOUND))));
+        assert!(!is_unavailable(&request_error(Some(
+            StatusCode::INTERNAL_SERVER_ERROR
+        ))));
+        assert!(!is_unavailable(&request_error(None)));
+        assert!(!is_unavailable(&anyhow::anyhow!("ffmpeg failed")))
+    }
+}
@@ -6,22 +6,23 @@ use std::{
     mem,
     ops::Not,
     path::Path,
-    process::Command,
+    process::{Child, Command},
 };
 
 use chrono::TimeDelta;
 use crunchyroll_rs::Locale;
 use log::debug;
-use tempfile::TempPath;
 
 use anyhow::{bail, Result};
 use rusty_chromaprint::{Configuration, Fingerprinter};
 
 use super::fmt::format_time_delta;
+use super::resume::StreamPath;
+use super::signal::{detach_from_signals, register_detached_process, unregister_detached_process};
 
 pub struct SyncAudio {
     pub format_id: usize,
-    pub path: TempPath,
+    pub path: StreamPath,
     pub locale: Locale,
     pub sample_rate: u32,
     pub video_idx: usize,
@@ -253,11 +254,22 @@ fn generate_chromaprint(
         ])
         .arg("-");
 
-    let mut handle = command
+    let mut handle = detach_from_signals(&mut command)
         .stdout(Stdio::piped())
         .stderr(Stdio::piped())
         .spawn()?;
+    let pid = handle.id();
+    register_detached_process(pid);
+    let result = consume_fingerprint(&mut handle, &mut printer);
+    unregister_detached_process(pid);
+    result?;
 
+    printer.finish();
+    return Ok(printer.fingerprint().into());
+}
+
+/// Feed the raw audio data which ffmpeg writes to stdout into `printer`.
+fn consume_fingerprint(handle: &mut Child, printer: &mut Fingerprinter) -> Result<()> {
     // the stdout is read in chunks because keeping all the raw audio data in memory would take up
     // a significant amount of space
     let mut stdout = handle.stdout.take().unwrap();
@@ -274,11 +286,12 @@ fn generate_chromaprint(
     }
 
     if !handle.wait()?.success() {
-        bail!("{}", std::io::read_to_string(handle.stderr.unwrap())?)
+        bail!(
+            "{}",
+            std::io::read_to_string(handle.stderr.take().unwrap())?
+        )
     }
-
-    printer.finish();
-    return Ok(printer.fingerprint().into());
+    Ok(())
 }
 
 fn compare_chromaprints(
@@ -0,0 +1,135 @@
+use dialoguer::console::{set_colors_enabled, set_colors_enabled_stderr, Style};
+use std::env;
+use std::fmt::{Display, Formatter};
+use std::sync::OnceLock;
+
+static THEME: OnceLock<Theme> = OnceLock::new();
+
+#[derive(Clone, Copy, Debug, Default, Eq, PartialEq)]
+pub enum ColorMode {
+    /// Colorize if the output is a terminal and the `NO_COLOR` environment variable isn't set.
+    #[default]
+    Auto,
+    Always,
+    Never,
+}
+
+impl Display for ColorMode {
+    fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
+        let value = match self {
+            ColorMode::Auto => "auto",
+            ColorMode::Always => "always",
+            ColorMode::Never => "never",
+        };
+        write!(f, "{}", value)
+    }
+}
+
+impl ColorMode {
+    pub fn parse(s: &str) -> Result<Self, String> {
+        match s.to_lowercase().as_str() {
+            "auto" => Ok(Self::Auto),
+            "always" => Ok(Self::Always),
+            "never" => Ok(Self::Never),
+            _ => Err(format!("invalid color mode '{}'", s)),
+        }
+    }
+}
+
+#[derive(Clone, Copy, Debug, Default, Eq, PartialEq)]
+pub enum Theme {
+    /// Bright colors for terminals with a dark background.
+    #[default]
+    Dark,
+    /// Darker colors for terminals with a light background.
+    Light,
+}
+
+impl Display for Theme {
+    fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
+        let value = match self {
+            Theme::Dark => "dark",
+            Theme::Light => "light",
+        };
+        write!(f, "{}", value)
+    }
+}
+
+impl Theme {
+    pub fn parse(s: &str) -> Result<Self, String> {
+        match s.to_lowercase().as_str() {
+            "dark" => Ok(Self::Dark),
+            "light" => Ok(Self::Light),
+            _ => Err(format!("invalid theme '{}'", s)),
+        }
+    }
+
+    /// The style of a role in the notation of `console` / `indicatif` templates.
+    fn style(&self, role: Role) -> &'static str {
+        match (self, role) {
+            (Theme::Dark, Role::Prefix) => "cyan.bold",
+            (Theme::Dark, Role::Success) => "green",
+            (Theme::Dark, Role::Warning) => "yellow",
+            (Theme::Dark, Role::Error) => "red.bold",
+            (Theme::Dark, Role::Speed) => "cyan",
+            (Theme::Light, Role::Prefix) => "blue.bold",
+            (Theme::Light, Role::Success) => "green",
+            // yellow is barely readable on light backgrounds
+            (Theme::Light, Role::Warning) => "magenta",
+            (Theme::Light, Role::Error) => "red.bold",
+            (Theme::Light, Role::Speed) => "blue",
+        }
+    }
+}
+
+/// What a colored part of the output represents.
+#[derive(Clone, Copy)]
+pub(crate) enum Role {
+    /// The `::` in front of every line.
+    Prefix,
+    Success,
+    Warning,
+    Error,
+    /// Download and encoding speeds.
+    Speed,
+}
+
+/// Set how the output is colored. `console` (and with it `indicatif` templates) only checks if the
+/// output is a terminal, so the other conditions are applied here.
+pub fn set_color(color_mode: ColorMode, theme: Theme) {
+    let enabled = match color_mode {
+        ColorMode::Auto => env::var_os("NO_COLOR").map_or(true, |v| v.is_empty()),
+        ColorMode::Always => true,
+        ColorMode::Never => false,
+    };
+    // in auto mode `console` already disabled colors for outputs which aren't terminals
+    if !enabled || color_mode == ColorMode::Always {
+        set_colors_enabled(enabled);
+        set_colors_enabled_stderr(enabled);
+    }
+    let _ = THEME.set(theme);
+}
+
+fn theme() -> Theme {
+    *THEME.get_or_init(Theme::default)
+}
+
+/// Colorize text which is printed to stdout.
+pub(crate) fn paint<S: Display>(role: Role, text: S) -> String {
+    Style::from_dotted_str(theme().style(role))
+        .apply_to(text)
+        .to_string()
+}
+
+/// Colorize text which is printed to stderr.
+pub(crate) fn paint_stderr<S: Display>(role: Role, text: S) -> String {
+    Style::from_dotted_str(theme().style(role))
+        .for_stderr()
+        .apply_to(text)
+        .to_string()
+}
+
+/// The style of a role as suffix for `indicatif` template keys, e.g. `{bytes_per_sec:>12.cyan}`.
+pub(crate) fn template_style(role: Role) -> String {
+    format!(".{}", theme().style(role))
+}
@@ -0,0 +1,72 @@
+use anyhow::Result;
+use log::{debug, warn};
+use reqwest::Client;
+use serde::Deserialize;
+
+const LATEST_RELEASE_URL: &str =
+    "https://api.github.com/repos/crunchy-labs/crunchy-cli/releases/latest";
+
+#[derive(Debug, Deserialize)]
+pub(crate) struct Release {
+    pub(crate) tag_name: String,
+    pub(crate) html_url: String,
+    pub(crate) assets: Vec<ReleaseAsset>,
+}
+
+#[derive(Debug, Deserialize)]
+pub(crate) struct ReleaseAsset {
+    pub(crate) name: String,
+    pub(crate) browser_download_url: String,
+}
+
+impl Release {
+    pub(crate) fn version(&self) -> &str {
+        self.tag_name.trim_start_matches('v')
+    }
+
+    pub(crate) fn is_newer(&self) -> bool {
+        is_newer_version(self.version(), env!("CARGO_PKG_VERSION"))
+    }
+
+    pub(crate) fn asset(&self, name: &str) -> Option<&ReleaseAsset> {
+        self.assets.iter().find(|a| a.name == name)
+    }
+}
+
+pub(crate) async fn latest_release(client: &Client) -> Result<Release> {
+    Ok(client
+        .get(LATEST_RELEASE_URL)
+        .header("Accept", "application/vnd.github+json")
+        .send()
+        .await?
+        .error_for_status()?
+        .json()
+        .await?)
+}
+
+/// Compare two versions in format of `<major>.<minor>.<patch>`. Parts which aren't a number (e.g.
+/// pre-release suffixes) are treated as 0.
+fn is_newer_version(version: &str, current: &str) -> bool {
+    let parse = |v: &str| -> Vec<u64> {
+        v.split(['.', '-'])
+            .take(3)
+            .map(|p| p.parse().unwrap_or_default())
+            .collect()
+    };
+    parse(version) > parse(current)
+}
+
+/// Show a warning if a newer version is available. Errors are only logged, as the check should
+/// never prevent a command from running.
+pub async fn check_update(client: &Client) {
+    match latest_release(client).await {
+        Ok(release) if release.is_newer() => warn!(
+            "A new version of crunchy-cli is available ({}, currently installed is {}). Run `crunchy-cli update` or download it from {}",
+            release.version(),
+            env!("CARGO_PKG_VERSION"),
+            release.html_url
+        ),
+        Ok(_) => debug!("crunchy-cli is up to date"),
+        Err(e) => debug!("Failed to check for updates: {}", e),
+    }
+}
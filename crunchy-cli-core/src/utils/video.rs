@@ -1,10 +1,15 @@
 use anyhow::{bail, Result};
+use chrono::{DateTime, Utc};
 use crunchyroll_rs::media::{Resolution, Stream, StreamData};
 use crunchyroll_rs::Locale;
+use reqwest::StatusCode;
 
+/// Get the video and audio of `stream`. The video has the given resolution (or the closest one if
+/// `closest_resolution` is set and the resolution isn't available), the audio the best quality.
 pub async fn stream_data_from_stream(
     stream: &Stream,
     resolution: &Resolution,
+    closest_resolution: bool,
     hardsub_subtitle: Option<Locale>,
 ) -> Result<Option<(StreamData, StreamData, bool)>> {
     let (hardsub_locale, mut contains_hardsub) = if hardsub_subtitle.is_some() {
@@ -33,9 +38,69 @@ pub async fn stream_data_from_stream(
     let video_variant = match resolution.height {
         u64::MAX => Some(videos.into_iter().next().unwrap()),
         u64::MIN => Some(videos.into_iter().last().unwrap()),
+        _ if closest_resolution => closest_video(videos, resolution),
         _ => videos
             .into_iter()
             .find(|v| resolution.height == v.resolution().unwrap().height),
     };
     Ok(video_variant.map(|v| (v, audios.first().unwrap().clone(), contains_hardsub)))
 }
+
+/// Get the video whose height matches `resolution` or is the next lower one. If all videos are
+/// higher, the lowest one is used. `videos` must be sorted by their bandwidth, highest first.
+fn closest_video(videos: Vec<StreamData>, resolution: &Resolution) -> Option<StreamData> {
+    let height = |v: &StreamData| v.resolution().unwrap().height;
+    let closest_height = videos
+        .iter()
+        .map(height)
+        .filter(|h| *h <= resolution.height)
+        .max();
+    match closest_height {
+        // the first video with the height has the highest bandwidth
+        Some(closest_height) => videos.into_iter().find(|v| height(v) == closest_height),
+        None => videos.into_iter().last(),
+    }
+}
+
+/// Get the time until a signed url is valid. Crunchyroll uses akamai (`exp=<timestamp>` as part of
+/// the token) and cloudfront (`Expires=<timestamp>`) signed urls.
+pub fn signed_url_expiry(url: &str) -> Option<DateTime<Utc>> {
+    let url = reqwest::Url::parse(url).ok()?;
+    for (key, value) in url.query_pairs() {
+        let timestamp = if key == "Expires" {
+            value.parse::<i64>().ok()
+        } else {
+            value
+                .split('~')
+                .find_map(|part| part.strip_prefix("exp="))
+                .and_then(|exp| exp.parse::<i64>().ok())
+        };
+        if let Some(timestamp) = timestamp {
+            return DateTime::from_timestamp(timestamp, 0);
+        }
+    }
+    None
+}
+
+/// If `err` was caused by the expired signature of `url`. Crunchyroll responds with 403 to expired
+/// urls, retrying them is pointless as only a new stream has new signatures.
+pub fn is_expired_signature(err: &anyhow::Error, url: &str) -> bool {
+    err.downcast_ref::<reqwest::Error>()
+        .and_then(|e| e.status())
+        .is_some_and(|status| status == StatusCode::FORBIDDEN)
+        && signed_url_expiry(url).is_some_and(|expiry| expiry < Utc::now())
+}
+
+/// Get the time until the segment urls of the stream data are valid.
+pub fn stream_data_expiry(stream_data: &StreamData) -> Option<DateTime<Utc>> {
+    stream_data
+        .segments()
+        .first()
+        .and_then(|segment| signed_url_expiry(&segment.url))
+}
+
+/// If the stream is DRM protected. Crunchyroll serves streams without DRM if possible, those don't
+/// count towards the stream limits of the account.
+pub fn is_drm(stream: &Stream) -> bool {
+    stream.session.uses_stream_limits
+}
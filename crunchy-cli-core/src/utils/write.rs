@@ -0,0 +1,278 @@
+use anyhow::{bail, Result};
+use std::fmt::{Display, Formatter};
+use std::fs::File;
+use std::io::{self, Seek, SeekFrom, Write};
+use std::path::Path;
+
+/// Alignment of the buffer and of every write if the file is opened with `O_DIRECT`. 4096 is the
+/// logical block size of (almost) every modern filesystem.
+const DIRECT_IO_ALIGNMENT: usize = 4096;
+/// Maximal write buffer size if the memory usage should be low.
+const LOW_MEMORY_WRITE_BUFFER: u64 = 256 * 1024;
+
+#[derive(Clone, Debug, Default, Eq, PartialEq)]
+pub enum FsyncPolicy {
+    #[default]
+    Never,
+    Segment,
+    File,
+}
+
+impl Display for FsyncPolicy {
+    fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
+        let value = match self {
+            FsyncPolicy::Never => "never",
+            FsyncPolicy::Segment => "segment",
+            FsyncPolicy::File => "file",
+        };
+        write!(f, "{}", value)
+    }
+}
+
+impl FsyncPolicy {
+    fn parse(s: &str) -> Result<Self, String> {
+        match s.to_lowercase().as_str() {
+            "never" => Ok(Self::Never),
+            "segment" => Ok(Self::Segment),
+            "file" => Ok(Self::File),
+            _ => Err(format!("'{}' is not a valid fsync policy", s)),
+        }
+    }
+}
+
+#[derive(Clone, Debug, clap::Parser)]
+pub struct WriteOptions {
+    #[arg(
+        help = "Size of the buffer downloaded segments are collected in before they're written to disk. Must be in format of <number>[B|KB|MB|GB]"
+    )]
+    #[arg(
+        long_help = "Size of the buffer downloaded segments are collected in before they're written to disk. Must be in format of <number>[B|KB|MB|GB] (e.g. 16MB). \
+    Bigger buffers result in fewer but larger writes, which speeds up writing to network shares (NAS/SMB). \
+    Set it to 0 to write every segment directly"
+    )]
+    #[arg(long, default_value = "8MB", value_parser = crate::utils::clap::clap_parse_file_size)]
+    pub(crate) write_buffer: u64,
+    #[arg(
+        help = "When downloaded data is synced to disk. Valid options are 'never', 'segment' and 'file'"
+    )]
+    #[arg(
+        long_help = "When downloaded data is synced to disk. Valid options are 'never' (let the operating system decide), 'segment' (after every write, which is after every segment with `--write-buffer 0`) and 'file' (once the file is completely downloaded). \
+    Syncing makes sure that the data is actually stored on disk, but may slow down the download"
+    )]
+    #[arg(long, default_value_t = FsyncPolicy::Never, value_parser = FsyncPolicy::parse)]
+    pub(crate) fsync: FsyncPolicy,
+    #[arg(help = "Write downloaded data directly to disk, bypassing the page cache. Linux only")]
+    #[arg(
+        long_help = "Write downloaded data directly to disk (O_DIRECT), bypassing the page cache of the operating system. \
+    This prevents that downloads push everything else out of memory on devices with little ram. \
+    Only supported on Linux and not by every filesystem (e.g. tmpfs doesn't support it)"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) direct_io: bool,
+    #[arg(help = "Continue interrupted downloads instead of starting them from the beginning")]
+    #[arg(
+        long_help = "Continue interrupted downloads instead of starting them from the beginning. \
+    The downloaded streams are stored in a hidden `.<output filename>.parts` directory next to the output file, together with the progress of every stream. \
+    If the download gets interrupted, running the same command again continues after the last completely written segment. \
+    The directory is removed once the output file was generated"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) resume: bool,
+}
+
+impl Default for WriteOptions {
+    fn default() -> Self {
+        Self {
+            write_buffer: 8 * 1024 * 1024,
+            fsync: FsyncPolicy::Never,
+            direct_io: false,
+            resume: false,
+        }
+    }
+}
+
+impl WriteOptions {
+    pub(crate) fn check(&self) -> Result<()> {
+        if self.direct_io && !cfg!(target_os = "linux") {
+            bail!("`--direct-io` is only supported on Linux")
+        }
+        // sizes are 64-bit, but memory can only be addressed with 32-bit on 32-bit systems
+        if usize::try_from(self.write_buffer).is_err() {
+            bail!("`--write-buffer` is too big for this system")
+        }
+        // the progress of a resumable download is stored after every segment, but with O_DIRECT
+        // data which doesn't fill a complete block can't be written until the download is finished
+        if self.direct_io && self.resume {
+            bail!("`--resume` can't be used together with `--direct-io`")
+        }
+        if self.direct_io && self.write_buffer < DIRECT_IO_ALIGNMENT as u64 {
+            bail!(
+                "`--write-buffer` must be at least {}B when using `--direct-io`",
+                DIRECT_IO_ALIGNMENT
+            )
+        }
+        Ok(())
+    }
+
+    /// Reduce the write buffer to a size which is suitable for devices with little memory.
+    pub(crate) fn low_memory(&mut self) {
+        self.write_buffer = self.write_buffer.min(LOW_MEMORY_WRITE_BUFFER)
+    }
+
+    /// Open `path` (which must already exist) for writing downloaded segments.
+    pub(crate) fn open(&self, path: &Path) -> Result<SegmentWriter> {
+        self.open_at(path, 0)
+    }
+
+    /// Like [`WriteOptions::open`], but keeps the first `offset` bytes of the file and writes
+    /// after them. Used to continue interrupted downloads. The file is created if it doesn't
+    /// exist.
+    pub(crate) fn open_at(&self, path: &Path, offset: u64) -> Result<SegmentWriter> {
+        let mut options = File::options();
+        options.write(true).create(true);
+        #[cfg(target_os = "linux")]
+        if self.direct_io {
+            use std::os::unix::fs::OpenOptionsExt;
+            options.custom_flags(nix::fcntl::OFlag::O_DIRECT.bits());
+        }
+        let mut file = match options.open(path) {
+            Ok(file) => file,
+            Err(e) if self.direct_io => bail!(
+                "Failed to open {} with `--direct-io`, the filesystem probably doesn't support it: {}",
+                path.to_string_lossy(),
+                e
+            ),
+            Err(e) => return Err(e.into()),
+        };
+        file.set_len(offset)?;
+        file.seek(SeekFrom::End(0))?;
+
+        let capacity = if self.direct_io {
+            // only full blocks can be written with O_DIRECT
+            self.write_buffer as usize / DIRECT_IO_ALIGNMENT * DIRECT_IO_ALIGNMENT
+        } else {
+            self.write_buffer as usize
+        };
+        // O_DIRECT requires the memory of a write to be aligned too. a vec can't be allocated with
+        // a specific alignment, so a bit more is allocated and the buffer starts at the first
+        // aligned address
+        let (buf, offset) = if self.direct_io {
+            let buf = vec![0; capacity + DIRECT_IO_ALIGNMENT];
+            let offset = buf.as_ptr().align_offset(DIRECT_IO_ALIGNMENT);
+            (buf, offset)
+        } else {
+            (Vec::with_capacity(capacity), 0)
+        };
+
+        Ok(SegmentWriter {
+            file,
+            buf,
+            offset,
+            len: 0,
+            capacity,
+            fsync: self.fsync.clone(),
+            direct_io: self.direct_io,
+        })
+    }
+}
+
+/// Writes downloaded segments in batches of the write buffer size. [`SegmentWriter::finish`] must
+/// be called after the last segment, else buffered data is lost.
+pub(crate) struct SegmentWriter {
+    file: File,
+    buf: Vec<u8>,
+    /// Start of the (aligned) buffer in `buf`.
+    offset: usize,
+    len: usize,
+    capacity: usize,
+    fsync: FsyncPolicy,
+    direct_io: bool,
+}
+
+impl SegmentWriter {
+    /// Write all buffered data and sync it to disk, if requested.
+    pub(crate) fn finish(mut self) -> Result<()> {
+        if self.direct_io {
+            self.write_buffered(true)?;
+            // the remaining data is smaller than a block, which can't be written with O_DIRECT
+            #[cfg(target_os = "linux")]
+            {
+                use nix::fcntl::{fcntl, FcntlArg, OFlag};
+                use std::os::fd::AsRawFd;
+                let flags =
+                    OFlag::from_bits_truncate(fcntl(self.file.as_raw_fd(), FcntlArg::F_GETFL)?);
+                fcntl(
+                    self.file.as_raw_fd(),
+                    FcntlArg::F_SETFL(flags.difference(OFlag::O_DIRECT)),
+                )?;
+            }
+        }
+        self.write_buffered(false)?;
+        if self.fsync != FsyncPolicy::Never {
+            self.file.sync_all()?
+        }
+        Ok(())
+    }
+
+    /// Write the buffered data. If `full_blocks_only` is set, data which doesn't fill a complete
+    /// block stays in the buffer.
+    fn write_buffered(&mut self, full_blocks_only: bool) -> io::Result<()> {
+        let write_len = if full_blocks_only {
+            self.len / DIRECT_IO_ALIGNMENT * DIRECT_IO_ALIGNMENT
+        } else {
+            self.len
+        };
+        if write_len == 0 {
+            return Ok(());
+        }
+
+        if self.direct_io {
+            self.file
+                .write_all(&self.buf[self.offset..self.offset + write_len])?;
+            self.buf
+                .copy_within(self.offset + write_len..self.offset + self.len, self.offset);
+        } else {
+            self.file.write_all(&self.buf[..write_len])?;
+            self.buf.drain(..write_len);
+        }
+        self.len -= write_len;
+
+        if self.fsync == FsyncPolicy::Segment {
+            self.file.sync_data()?
+        }
+        Ok(())
+    }
+}
+
+impl Write for SegmentWriter {
+    fn write(&mut self, mut data: &[u8]) -> io::Result<usize> {
+        let written = data.len();
+        if self.capacity == 0 {
+            self.file.write_all(data)?;
+            if self.fsync == FsyncPolicy::Segment {
+                self.file.sync_data()?
+            }
+        }
+        while self.capacity > 0 && !data.is_empty() {
+            let n = data.len().min(self.capacity - self.len);
+            if self.direct_io {
+                let start = self.offset + self.len;
+                self.buf[start..start + n].copy_from_slice(&data[..n]);
+            } else {
+                self.buf.extend_from_slice(&data[..n]);
+            }
+            self.len += n;
+            data = &data[n..];
+
+            if self.len == self.capacity {
+                self.write_buffered(self.direct_io)?
+            }
+        }
+        Ok(written)
+    }
+
+    fn flush(&mut self) -> io::Result<()> {
+        self.write_buffered(self.direct_io)?;
+        self.file.flush()
+    }
+}
@@ -0,0 +1,151 @@
+use crate::utils::context::Context;
+use crate::Execute;
+use anyhow::{bail, Result};
+use chrono::{DateTime, Utc};
+use crunchyroll_rs::common::StreamExt;
+use crunchyroll_rs::crunchyroll::SessionToken;
+use crunchyroll_rs::MediaCollection;
+use serde::Serialize;
+
+#[derive(Debug, clap::Parser)]
+#[clap(about = "Show the watch history of your account")]
+pub struct WatchHistory {
+    #[arg(help = "Page of the watch history to show, starting at 1")]
+    #[arg(long, default_value_t = 1)]
+    page: u32,
+    #[arg(help = "Number of entries per page")]
+    #[arg(long, default_value_t = 50)]
+    size: u32,
+
+    #[arg(help = "Print the entries as json lines")]
+    #[arg(
+        long_help = "Print the entries as json lines, e.g. to sync the watch progress with other tools. \
+    Every line contains the id, title, series title, season and episode number, playhead and duration (both in seconds), the fully watched flag and when it was played (as unix timestamp)"
+    )]
+    #[arg(long, default_value_t = false)]
+    json: bool,
+}
+
+#[derive(Debug, Serialize)]
+struct WatchHistoryItem {
+    id: String,
+    title: String,
+    series_title: Option<String>,
+    season_number: Option<u32>,
+    episode_number: Option<String>,
+    playhead: u32,
+    duration: i64,
+    fully_watched: bool,
+    date_played: i64,
+}
+
+impl Execute for WatchHistory {
+    fn pre_check(&mut self) -> Result<()> {
+        if self.page == 0 {
+            bail!("`--page` must be at least 1")
+        }
+        if self.size == 0 {
+            bail!("`--size` must be at least 1")
+        }
+        Ok(())
+    }
+
+    async fn execute(self, ctx: Context) -> Result<()> {
+        if matches!(ctx.crunchy.session_token().await, SessionToken::Anonymous) {
+            bail!("The watch history cannot be shown when logging in anonymously")
+        }
+
+        let mut entries = ctx
+            .crunchy
+            .watch_history()
+            .skip(((self.page - 1) * self.size) as usize)
+            .take(self.size as usize);
+        while let Some(entry) = entries.next().await {
+            let entry = entry?;
+            let Some(item) = WatchHistoryItem::new(
+                entry.panel,
+                entry.playhead,
+                entry.fully_watched,
+                entry.date_played,
+            ) else {
+                continue;
+            };
+
+            if self.json {
+                println!("{}", serde_json::to_string(&item)?)
+            } else {
+                println!("{}", item)
+            }
+        }
+
+        Ok(())
+    }
+}
+
+impl WatchHistoryItem {
+    fn new(
+        media_collection: MediaCollection,
+        playhead: u32,
+        fully_watched: bool,
+        date_played: DateTime<Utc>,
+    ) -> Option<Self> {
+        let (id, title, series_title, season_number, episode_number, duration) =
+            match media_collection {
+                MediaCollection::Episode(episode) => (
+                    episode.id,
+                    episode.title,
+                    Some(episode.series_title),
+                    Some(episode.season_number),
+                    Some(episode.episode),
+                    episode.duration,
+                ),
+                MediaCollection::Movie(movie) => {
+                    (movie.id, movie.title, None, None, None, movie.duration)
+                }
+                _ => return None,
+            };
+
+        Some(Self {
+            id,
+            title,
+            series_title,
+            season_number,
+            episode_number,
+            playhead,
+            duration: duration.num_seconds(),
+            fully_watched,
+            date_played: date_played.timestamp(),
+        })
+    }
+}
+
+impl std::fmt::Display for WatchHistoryItem {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        let progress = if self.fully_watched {
+            "watched".to_string()
+        } else {
+            format!(
+                "{:02}:{:02}/{:02}:{:02}",
+                self.playhead / 60,
+                self.playhead % 60,
+                self.duration / 60,
+                self.duration % 60
+            )
+        };
+        let name = match (&self.series_title, self.season_number, &self.episode_number) {
+            (Some(series_title), Some(season_number), Some(episode_number)) => format!(
+                "{} S{:02}E{:0>2} - {}",
+                series_title, season_number, episode_number, self.title
+            ),
+            _ => self.title.clone(),
+        };
+        write!(
+            f,
+            "{}  {:<11}  {}",
+            DateTime::from_timestamp(self.date_played, 0)
+                .map_or("".to_string(), |d| d.format("%Y-%m-%d %H:%M").to_string()),
+            progress,
+            name
+        )
+    }
+}